@@ -0,0 +1,120 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// PodPortForwarder forwards a local port to a port of a pod through the API server, like
+// 'kubectl port-forward', to reach servers that are not exposed outside of the cluster.
+type PodPortForwarder struct {
+	Config          *rest.Config
+	Namespace, Name string
+
+	mu     sync.Mutex
+	stopCh chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// StartForwarding listens on the local port and forwards the connections to the remote port
+// of the pod until the context is cancelled or Stop is called. When the local port is 0, an
+// ephemeral port is allocated. It returns the address the listener is bound to.
+func (p *PodPortForwarder) StartForwarding(ctx context.Context, address *net.IPAddr, port ForwardedPort) (net.Addr, error) {
+	if port.Protocol != ProtocolTCP {
+		return nil, errors.New("unknown protocol: " + port.Protocol)
+	}
+
+	clientset, err := kubernetes.NewForConfig(p.Config)
+	if err != nil {
+		return nil, err
+	}
+	transport, upgrader, err := spdy.RoundTripperFor(p.Config)
+	if err != nil {
+		return nil, err
+	}
+	url := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(p.Namespace).
+		Name(p.Name).
+		SubResource("portforward").
+		URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+
+	stopCh := make(chan struct{})
+	readyCh := make(chan struct{})
+	forwarder, err := portforward.NewOnAddresses(dialer,
+		[]string{address.String()},
+		[]string{fmt.Sprintf("%d:%d", port.Local, port.Remote)},
+		stopCh, readyCh, io.Discard, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(done)
+		errCh <- forwarder.ForwardPorts()
+	}()
+
+	select {
+	case <-readyCh:
+	case err := <-errCh:
+		return nil, fmt.Errorf("can't forward to pod %s/%s: %w", p.Namespace, p.Name, err)
+	case <-ctx.Done():
+		close(stopCh)
+		<-done
+		return nil, ctx.Err()
+	}
+
+	ports, err := forwarder.GetPorts()
+	if err != nil || len(ports) == 0 {
+		close(stopCh)
+		<-done
+		return nil, fmt.Errorf("can't get the forwarded port of pod %s/%s: %v", p.Namespace, p.Name, err)
+	}
+
+	p.mu.Lock()
+	p.stopCh = stopCh
+	p.done = done
+	p.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Stop()
+		case <-done:
+		}
+	}()
+	return &net.TCPAddr{IP: address.IP, Zone: address.Zone, Port: int(ports[0].Local)}, nil
+}
+
+// Stop closes the listener and the forwarded connections, and waits for them to be released.
+func (p *PodPortForwarder) Stop() {
+	p.mu.Lock()
+	stopCh := p.stopCh
+	done := p.done
+	p.mu.Unlock()
+
+	if stopCh == nil {
+		return
+	}
+	p.once.Do(func() {
+		close(stopCh)
+	})
+	<-done
+}
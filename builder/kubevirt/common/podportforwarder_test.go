@@ -0,0 +1,210 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/common"
+)
+
+// portForwardServer is an API server serving the port-forward subresource of the pods, which
+// echoes the data sent to the pods.
+type portForwardServer struct {
+	mu    sync.Mutex
+	paths []string
+	ports []string
+}
+
+func (s *portForwardServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.paths = append(s.paths, r.URL.Path)
+	s.mu.Unlock()
+
+	if _, err := httpstream.Handshake(r, w, []string{portforward.PortForwardProtocolV1Name}); err != nil {
+		return
+	}
+
+	streams := make(chan httpstream.Stream)
+	conn := spdy.NewResponseUpgrader().UpgradeResponse(w, r, func(stream httpstream.Stream, replySent <-chan struct{}) error {
+		streams <- stream
+		return nil
+	})
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+
+	for {
+		select {
+		case stream := <-streams:
+			if stream.Headers().Get(corev1.StreamType) != corev1.StreamTypeData {
+				continue
+			}
+			s.mu.Lock()
+			s.ports = append(s.ports, stream.Headers().Get(corev1.PortHeader))
+			s.mu.Unlock()
+			go func() {
+				defer stream.Close()
+				_, _ = io.Copy(stream, stream)
+			}()
+		case <-conn.CloseChan():
+			return
+		}
+	}
+}
+
+var _ = Describe("PodPortForwarder", func() {
+	var (
+		api       *portForwardServer
+		server    *httptest.Server
+		forwarder *common.PodPortForwarder
+		address   *net.IPAddr
+	)
+
+	BeforeEach(func() {
+		api = &portForwardServer{}
+		server = httptest.NewServer(api)
+		DeferCleanup(server.Close)
+
+		forwarder = &common.PodPortForwarder{
+			Config:    &rest.Config{Host: server.URL},
+			Namespace: "test-ns",
+			Name:      "virt-export-test-vm",
+		}
+		address, _ = net.ResolveIPAddr("", "127.0.0.1")
+	})
+
+	AfterEach(func() {
+		forwarder.Stop()
+	})
+
+	echo := func(addr net.Addr) error {
+		conn, err := net.DialTimeout("tcp", addr.String(), time.Second)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return err
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return err
+		}
+		if string(buf) != "ping" {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	}
+
+	It("forwards the connections to the port of the pod once ready", func() {
+		addr, err := forwarder.StartForwarding(context.Background(), address, common.ForwardedPort{
+			Remote:   8443,
+			Protocol: common.ProtocolTCP,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(addr.(*net.TCPAddr).Port).NotTo(BeZero())
+
+		Expect(echo(addr)).To(Succeed())
+		Expect(echo(addr)).To(Succeed())
+
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		Expect(api.paths).To(ConsistOf("/api/v1/namespaces/test-ns/pods/virt-export-test-vm/portforward"))
+		Expect(api.ports).To(ConsistOf("8443", "8443"))
+	})
+
+	It("closes the listener when stopped", func() {
+		addr, err := forwarder.StartForwarding(context.Background(), address, common.ForwardedPort{
+			Remote:   8443,
+			Protocol: common.ProtocolTCP,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(echo(addr)).To(Succeed())
+
+		forwarder.Stop()
+		_, err = net.DialTimeout("tcp", addr.String(), time.Second)
+		Expect(err).To(HaveOccurred())
+
+		// Stopping again does nothing.
+		forwarder.Stop()
+	})
+
+	It("closes the listener when the context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		addr, err := forwarder.StartForwarding(ctx, address, common.ForwardedPort{
+			Remote:   8443,
+			Protocol: common.ProtocolTCP,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		cancel()
+		Eventually(func() error {
+			conn, err := net.DialTimeout("tcp", addr.String(), time.Second)
+			if err == nil {
+				conn.Close()
+			}
+			return err
+		}, 5*time.Second, 50*time.Millisecond).Should(HaveOccurred())
+	})
+
+	It("does nothing when stopped before forwarding", func() {
+		forwarder.Stop()
+
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		Expect(api.paths).To(BeEmpty())
+	})
+
+	It("fails with an unknown protocol", func() {
+		_, err := forwarder.StartForwarding(context.Background(), address, common.ForwardedPort{
+			Remote:   8443,
+			Protocol: "udp",
+		})
+		Expect(err).To(MatchError("unknown protocol: udp"))
+	})
+
+	It("fails when the API server refuses to forward the port", func() {
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `pods "virt-export-test-vm" is forbidden`, http.StatusForbidden)
+		})
+
+		_, err := forwarder.StartForwarding(context.Background(), address, common.ForwardedPort{
+			Remote:   8443,
+			Protocol: common.ProtocolTCP,
+		})
+		Expect(err).To(MatchError(ContainSubstring("can't forward to pod test-ns/virt-export-test-vm")))
+	})
+
+	It("fails when the local port is in use", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+
+		_, err = forwarder.StartForwarding(context.Background(), address, common.ForwardedPort{
+			Local:    listener.Addr().(*net.TCPAddr).Port,
+			Remote:   8443,
+			Protocol: common.ProtocolTCP,
+		})
+		Expect(err).To(MatchError(ContainSubstring("can't forward to pod test-ns/virt-export-test-vm")))
+	})
+})
@@ -20,10 +20,15 @@
 package common
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net"
 	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
 
 	kvcorev1 "kubevirt.io/client-go/kubevirt/typed/core/v1"
 	"kubevirt.io/client-go/log"
@@ -33,6 +38,15 @@ const (
 	ProtocolTCP = "tcp"
 )
 
+// DefaultStreamBackoff is the backoff used to retry opening a stream to the remote port,
+// e.g. while the VMI reboots. It retries for about two minutes.
+var DefaultStreamBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Steps:    8,
+	Cap:      30 * time.Second,
+}
+
 type PortForward struct {
 	Address  *net.IPAddr
 	Resource PortforwardableResource
@@ -41,6 +55,14 @@ type PortForward struct {
 type PortForwarder struct {
 	Kind, Namespace, Name string
 	Resource              PortforwardableResource
+	// Backoff is used to retry opening a stream to the remote port. DefaultStreamBackoff is
+	// used when it is not set.
+	Backoff wait.Backoff
+
+	mu       sync.Mutex
+	listener net.Listener
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
 }
 
 type ForwardedPort struct {
@@ -53,16 +75,19 @@ type PortforwardableResource interface {
 	PortForward(name string, port int, protocol string) (kvcorev1.StreamInterface, error)
 }
 
-func (p *PortForwarder) StartForwarding(address *net.IPAddr, port ForwardedPort) error {
+// StartForwarding listens on the local port and forwards the connections to the remote port
+// until the context is cancelled or Stop is called. When the local port is 0, an ephemeral
+// port is allocated. It returns the address the listener is bound to.
+func (p *PortForwarder) StartForwarding(ctx context.Context, address *net.IPAddr, port ForwardedPort) (net.Addr, error) {
 	log.Log.Infof("forwarding %s %s:%d to %d", port.Protocol, address, port.Local, port.Remote)
 
 	if port.Protocol == ProtocolTCP {
-		return p.StartForwardingTCP(address, port)
+		return p.StartForwardingTCP(ctx, address, port)
 	}
-	return errors.New("unknown protocol: " + port.Protocol)
+	return nil, errors.New("unknown protocol: " + port.Protocol)
 }
 
-func (p *PortForwarder) StartForwardingTCP(address *net.IPAddr, port ForwardedPort) error {
+func (p *PortForwarder) StartForwardingTCP(ctx context.Context, address *net.IPAddr, port ForwardedPort) (net.Addr, error) {
 	listener, err := net.ListenTCP(
 		port.Protocol,
 		&net.TCPAddr{
@@ -71,35 +96,117 @@ func (p *PortForwarder) StartForwardingTCP(address *net.IPAddr, port ForwardedPo
 			Port: port.Local,
 		})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	go p.WaitForConnection(listener, port)
-	return nil
+	// Log the actual port, which differs from the requested one when it is allocated.
+	port.Local = listener.Addr().(*net.TCPAddr).Port
+
+	ctx, cancel := context.WithCancel(ctx)
+	p.mu.Lock()
+	p.listener = listener
+	p.cancel = cancel
+	p.mu.Unlock()
+
+	p.wg.Add(2)
+	go func() {
+		defer p.wg.Done()
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go func() {
+		defer p.wg.Done()
+		p.WaitForConnection(ctx, listener, port)
+	}()
+	return listener.Addr(), nil
 }
 
-func (p *PortForwarder) WaitForConnection(listener net.Listener, port ForwardedPort) {
+// Stop closes the listener and the forwarded connections, and waits for them to be released.
+func (p *PortForwarder) Stop() {
+	p.mu.Lock()
+	cancel := p.cancel
+	p.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	p.wg.Wait()
+}
+
+func (p *PortForwarder) WaitForConnection(ctx context.Context, listener net.Listener, port ForwardedPort) {
+	// The delay after a failed accept grows the way net/http does, so that errors such as
+	// running out of file descriptors do not spin.
+	var delay time.Duration
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Log.Errorf("error accepting connection: %v", err)
-			return
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else {
+				delay = min(2*delay, time.Second)
+			}
+			log.Log.Errorf("error accepting connection, retrying in %s: %v", delay, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			continue
 		}
-		log.Log.Infof("opening new tcp tunnel to %d", port.Remote)
-		stream, err := p.Resource.PortForward(p.Name, port.Remote, port.Protocol)
-		if err != nil {
-			log.Log.Errorf("can't access %s/%s.%s: %v", p.Kind, p.Name, p.Namespace, err)
-			return
+		delay = 0
+
+		// The stream is opened in the goroutine of the connection, so that a retrying stream
+		// does not hold back the other connections.
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			log.Log.Infof("opening new tcp tunnel to %d", port.Remote)
+			stream, err := p.openStream(ctx, port)
+			if err != nil {
+				log.Log.Errorf("can't access %s/%s.%s: %v", p.Kind, p.Name, p.Namespace, err)
+				conn.Close()
+				return
+			}
+			p.HandleConnection(ctx, conn, stream.AsConn(), port)
+		}()
+	}
+}
+
+// openStream opens a stream to the remote port, retrying with backoff while the
+// resource cannot be reached.
+func (p *PortForwarder) openStream(ctx context.Context, port ForwardedPort) (kvcorev1.StreamInterface, error) {
+	backoff := p.Backoff
+	if backoff.Steps == 0 {
+		backoff = DefaultStreamBackoff
+	}
+
+	var stream kvcorev1.StreamInterface
+	var lastErr error
+	err := wait.ExponentialBackoffWithContext(ctx, backoff, func(context.Context) (bool, error) {
+		stream, lastErr = p.Resource.PortForward(p.Name, port.Remote, port.Protocol)
+		if lastErr != nil {
+			log.Log.Infof("retrying tcp tunnel to %d: %v", port.Remote, lastErr)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		if lastErr != nil {
+			return nil, lastErr
 		}
-		go p.HandleConnection(conn, stream.AsConn(), port)
+		return nil, err
 	}
+	return stream, nil
 }
 
-// handleConnection copies data between the local connection and the stream to
-// the remote server.
-func (p *PortForwarder) HandleConnection(local, remote net.Conn, port ForwardedPort) {
+// HandleConnection copies data between the local connection and the stream to
+// the remote server, until one side is closed or the context is cancelled.
+func (p *PortForwarder) HandleConnection(ctx context.Context, local, remote net.Conn, port ForwardedPort) {
 	log.Log.Infof("handling tcp connection for %d", port.Local)
-	errs := make(chan error)
+	errs := make(chan error, 2)
 	go func() {
 		_, err := io.Copy(remote, local)
 		errs <- err
@@ -109,7 +216,11 @@ func (p *PortForwarder) HandleConnection(local, remote net.Conn, port ForwardedP
 		errs <- err
 	}()
 
-	HandleConnectionError(<-errs, port)
+	select {
+	case err := <-errs:
+		HandleConnectionError(err, port)
+	case <-ctx.Done():
+	}
 	local.Close()
 	remote.Close()
 	HandleConnectionError(<-errs, port)
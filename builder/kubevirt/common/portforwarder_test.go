@@ -0,0 +1,249 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	kvcorev1 "kubevirt.io/client-go/kubevirt/typed/core/v1"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/common"
+)
+
+// flakyResource fails to open the first streams, like a VMI that is rebooting.
+type flakyResource struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	// hold, when set, blocks opening the first stream until it is closed.
+	hold chan struct{}
+}
+
+func (r *flakyResource) PortForward(name string, port int, protocol string) (kvcorev1.StreamInterface, error) {
+	r.mu.Lock()
+	r.calls++
+	call := r.calls
+	r.mu.Unlock()
+
+	if call == 1 && r.hold != nil {
+		<-r.hold
+	}
+	if call <= r.failures {
+		return nil, fmt.Errorf("virtual machine %s is not running", name)
+	}
+
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		_, _ = io.Copy(remote, remote)
+	}()
+	return &pipeStream{conn: local}, nil
+}
+
+// pipeStream is a port-forward stream over a connection.
+type pipeStream struct {
+	conn net.Conn
+}
+
+func (s *pipeStream) Stream(options kvcorev1.StreamOptions) error {
+	return nil
+}
+
+func (s *pipeStream) AsConn() net.Conn {
+	return s.conn
+}
+
+// failingListener fails to accept connections, like a process out of file descriptors.
+type failingListener struct {
+	mu      sync.Mutex
+	accepts int
+	closed  chan struct{}
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.accepts++
+	select {
+	case <-l.closed:
+		return nil, net.ErrClosed
+	default:
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}
+	}
+}
+
+func (l *failingListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func (l *failingListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+var _ = Describe("PortForwarder", func() {
+	var (
+		resource  *flakyResource
+		forwarder *common.PortForwarder
+		address   *net.IPAddr
+	)
+
+	BeforeEach(func() {
+		resource = &flakyResource{}
+		forwarder = &common.PortForwarder{
+			Kind:      "vm",
+			Namespace: "test-ns",
+			Name:      "test-vm",
+			Resource:  resource,
+			Backoff: wait.Backoff{
+				Duration: 10 * time.Millisecond,
+				Factor:   1,
+				Steps:    5,
+			},
+		}
+		address, _ = net.ResolveIPAddr("", "127.0.0.1")
+	})
+
+	AfterEach(func() {
+		forwarder.Stop()
+	})
+
+	echo := func(addr net.Addr) error {
+		conn, err := net.DialTimeout("tcp", addr.String(), time.Second)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return err
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return err
+		}
+		if string(buf) != "ping" {
+			return fmt.Errorf("unexpected response %q", buf)
+		}
+		return nil
+	}
+
+	It("retries opening the stream while the VM is unreachable", func() {
+		resource.failures = 3
+
+		addr, err := forwarder.StartForwarding(context.Background(), address, common.ForwardedPort{
+			Remote:   22,
+			Protocol: common.ProtocolTCP,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(echo(addr)).To(Succeed())
+		Expect(resource.calls).To(Equal(4))
+	})
+
+	It("keeps accepting connections when opening a stream fails", func() {
+		resource.failures = 5
+
+		addr, err := forwarder.StartForwarding(context.Background(), address, common.ForwardedPort{
+			Remote:   22,
+			Protocol: common.ProtocolTCP,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(echo(addr)).NotTo(Succeed())
+		Expect(echo(addr)).To(Succeed())
+	})
+
+	It("does not hold back the connections while a stream is being opened", func() {
+		resource.hold = make(chan struct{})
+		defer close(resource.hold)
+
+		addr, err := forwarder.StartForwarding(context.Background(), address, common.ForwardedPort{
+			Remote:   22,
+			Protocol: common.ProtocolTCP,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		blocked, err := net.DialTimeout("tcp", addr.String(), time.Second)
+		Expect(err).NotTo(HaveOccurred())
+		defer blocked.Close()
+		Eventually(func() int {
+			resource.mu.Lock()
+			defer resource.mu.Unlock()
+			return resource.calls
+		}, 5*time.Second, 10*time.Millisecond).Should(Equal(1))
+
+		Expect(echo(addr)).To(Succeed())
+	})
+
+	It("closes the listener when stopped", func() {
+		addr, err := forwarder.StartForwarding(context.Background(), address, common.ForwardedPort{
+			Remote:   22,
+			Protocol: common.ProtocolTCP,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(echo(addr)).To(Succeed())
+
+		forwarder.Stop()
+		_, err = net.DialTimeout("tcp", addr.String(), time.Second)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("closes the listener when the context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		addr, err := forwarder.StartForwarding(ctx, address, common.ForwardedPort{
+			Remote:   22,
+			Protocol: common.ProtocolTCP,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		cancel()
+		Eventually(func() error {
+			conn, err := net.DialTimeout("tcp", addr.String(), time.Second)
+			if err == nil {
+				conn.Close()
+			}
+			return err
+		}, 5*time.Second, 50*time.Millisecond).Should(HaveOccurred())
+	})
+	It("does nothing when stopped before forwarding", func() {
+		forwarder.Stop()
+		Expect(resource.calls).To(BeZero())
+	})
+
+	It("backs off when connections cannot be accepted", func() {
+		listener := &failingListener{closed: make(chan struct{})}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			forwarder.WaitForConnection(ctx, listener, common.ForwardedPort{
+				Remote:   22,
+				Protocol: common.ProtocolTCP,
+			})
+		}()
+
+		time.Sleep(200 * time.Millisecond)
+		cancel()
+		Eventually(done).Should(BeClosed())
+
+		listener.mu.Lock()
+		defer listener.mu.Unlock()
+		// The delays double from 5ms: 5, 10, 20, 40 and 80ms fit in 200ms.
+		Expect(listener.accepts).To(BeNumerically(">=", 2))
+		Expect(listener.accepts).To(BeNumerically("<=", 8))
+		Expect(resource.calls).To(BeZero())
+	})
+})
@@ -0,0 +1,73 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package diskimage converts raw disk images to the qcow2, VMDK and VHDX formats, without
+// depending on qemu-img. The raw data is streamed, and the clusters that only hold zeros
+// are not written, so that the images are sparse.
+package diskimage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Formats are the formats raw disk images can be converted to.
+var Formats = []string{"qcow2", "vmdk", "vhdx"}
+
+// Convert reads a raw disk image of size bytes from r, and writes it to w in the format.
+// The size must be a multiple of 512 bytes.
+func Convert(w io.WriteSeeker, r io.Reader, size int64, format string) error {
+	if size <= 0 || size%sectorSize != 0 {
+		return fmt.Errorf("disk image size %d is not a positive multiple of %d bytes", size, sectorSize)
+	}
+
+	r = io.LimitReader(r, size)
+	switch format {
+	case "qcow2":
+		return writeQCOW2(w, r, size)
+	case "vmdk":
+		return writeVMDK(w, r, size)
+	case "vhdx":
+		return writeVHDX(w, r, size)
+	default:
+		return fmt.Errorf("disk image format %q is not supported", format)
+	}
+}
+
+const sectorSize = 512
+
+// readChunk fills buf from r, and pads it with zeros after the end of the data.
+func readChunk(r io.Reader, buf []byte) error {
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		clear(buf[n:])
+		return nil
+	}
+	return err
+}
+
+// isZero returns whether buf only holds zeros.
+func isZero(buf []byte) bool {
+	for len(buf) > 0 {
+		n := min(len(buf), len(zeros))
+		if !bytes.Equal(buf[:n], zeros[:n]) {
+			return false
+		}
+		buf = buf[n:]
+	}
+	return true
+}
+
+var zeros = make([]byte, 64*1024)
+
+// divRoundUp returns n / d, rounded up.
+func divRoundUp(n, d int64) int64 {
+	return (n + d - 1) / d
+}
+
+// roundUp returns n rounded up to a multiple of d.
+func roundUp(n, d int64) int64 {
+	return divRoundUp(n, d) * d
+}
@@ -0,0 +1,16 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package diskimage_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestDiskImage(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Disk Image Suite")
+}
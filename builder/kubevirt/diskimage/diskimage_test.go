@@ -0,0 +1,140 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package diskimage_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/diskimage"
+)
+
+const (
+	kiB = 1024
+	miB = 1024 * kiB
+	giB = 1024 * miB
+)
+
+// extent is data of a sparse test image, the rest of the image is zero.
+type extent struct {
+	offset int64
+	data   []byte
+}
+
+// sparseImage is a raw test image made of extents of data.
+type sparseImage struct {
+	size    int64
+	extents []extent
+}
+
+// testImage returns an image with random and compressible data, zero clusters and a
+// partial cluster at the end.
+func testImage() *sparseImage {
+	random := make([]byte, 3*64*kiB+512)
+	rand.New(rand.NewSource(1)).Read(random)
+	text := bytes.Repeat([]byte("packer-plugin-kubevirt "), 20000)
+
+	return &sparseImage{
+		size: 7*miB + 64*kiB + 512,
+		extents: []extent{
+			{offset: 0, data: random},
+			{offset: 2*miB + 4096, data: text},
+			{offset: 7*miB + 64*kiB, data: []byte{0xaa, 0x55}},
+		},
+	}
+}
+
+// reader returns the raw content of the image.
+func (s *sparseImage) reader() io.Reader {
+	var readers []io.Reader
+	var offset int64
+	for _, e := range s.extents {
+		readers = append(readers, io.LimitReader(zeroReader{}, e.offset-offset), bytes.NewReader(e.data))
+		offset = e.offset + int64(len(e.data))
+	}
+	readers = append(readers, io.LimitReader(zeroReader{}, s.size-offset))
+	return io.MultiReader(readers...)
+}
+
+// readAt returns the raw content of the image at the offset.
+func (s *sparseImage) readAt(offset int64, length int) []byte {
+	buf := make([]byte, length)
+	for _, e := range s.extents {
+		start := max(offset, e.offset)
+		end := min(offset+int64(length), e.offset+int64(len(e.data)))
+		if start < end {
+			copy(buf[start-offset:end-offset], e.data[start-e.offset:end-e.offset])
+		}
+	}
+	return buf
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// convert converts the image and returns the content of the converted file.
+func convert(image *sparseImage, format string) []byte {
+	path := filepath.Join(GinkgoT().TempDir(), "disk."+format)
+	f, err := os.Create(path)
+	Expect(err).NotTo(HaveOccurred())
+	defer f.Close()
+
+	Expect(diskimage.Convert(f, image.reader(), image.size, format)).To(Succeed())
+	content, err := os.ReadFile(path)
+	Expect(err).NotTo(HaveOccurred())
+	return content
+}
+
+// expectImage checks the content of a converted image read by the function, which returns
+// the data at an offset.
+func expectImage(image *sparseImage, readAt func(offset int64, length int) []byte) {
+	const chunk = 64 * kiB
+	for offset := int64(0); offset < image.size; offset += chunk {
+		length := int(min(chunk, image.size-offset))
+		Expect(readAt(offset, length)).To(Equal(image.readAt(offset, length)), "content at offset %d", offset)
+	}
+}
+
+var _ = Describe("Convert", func() {
+	It("rejects unsupported formats", func() {
+		f, err := os.Create(filepath.Join(GinkgoT().TempDir(), "disk.vdi"))
+		Expect(err).NotTo(HaveOccurred())
+		defer f.Close()
+
+		err = diskimage.Convert(f, bytes.NewReader(make([]byte, 512)), 512, "vdi")
+		Expect(err).To(MatchError(`disk image format "vdi" is not supported`))
+	})
+
+	It("rejects sizes that are not a multiple of the sector size", func() {
+		f, err := os.Create(filepath.Join(GinkgoT().TempDir(), "disk.qcow2"))
+		Expect(err).NotTo(HaveOccurred())
+		defer f.Close()
+
+		err = diskimage.Convert(f, bytes.NewReader(make([]byte, 1000)), 1000, "qcow2")
+		Expect(err).To(MatchError(ContainSubstring("is not a positive multiple of 512 bytes")))
+	})
+
+	It("pads a short source with zeros", func() {
+		image := &sparseImage{size: 1 * miB, extents: []extent{{offset: 0, data: []byte("boot")}}}
+		path := filepath.Join(GinkgoT().TempDir(), "disk.vmdk")
+		f, err := os.Create(path)
+		Expect(err).NotTo(HaveOccurred())
+		defer f.Close()
+		Expect(diskimage.Convert(f, bytes.NewReader([]byte("boot")), image.size, "vmdk")).To(Succeed())
+
+		content, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		expectImage(image, readVMDK(content))
+	})
+})
@@ -0,0 +1,259 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package diskimage
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+)
+
+// qcow2 images are written with 64 KiB clusters and 16-bit refcounts, and every cluster of
+// data is compressed with deflate, as 'qemu-img convert -c' does.
+// Source: https://gitlab.com/qemu-project/qemu/-/blob/master/docs/interop/qcow2.txt
+const (
+	qcow2Magic             = 0x514649fb
+	qcow2Version           = 3
+	qcow2HeaderLength      = 104
+	qcow2ClusterBits       = 16
+	qcow2ClusterSize       = 1 << qcow2ClusterBits
+	qcow2L2Entries         = qcow2ClusterSize / 8
+	qcow2RefcountOrder     = 4
+	qcow2RefcountsPerBlock = qcow2ClusterSize / 2
+
+	// qcow2Copied marks the clusters whose refcount is 1.
+	qcow2Copied = 1 << 63
+	// qcow2Compressed marks the compressed clusters, whose L2 entries hold the number of
+	// additional sectors of the compressed data from bit qcow2CompressedSectorsShift.
+	qcow2Compressed             = 1 << 62
+	qcow2CompressedSectorsShift = 62 - (qcow2ClusterBits - 8)
+
+	// qcow2CompressionWindow is the deflate window size QEMU decompresses clusters with.
+	qcow2CompressionWindow = 4096
+)
+
+// qcow2Writer lays the clusters of a qcow2 image out in the file.
+type qcow2Writer struct {
+	w         io.WriteSeeker
+	offset    int64
+	refcounts []uint16
+}
+
+func writeQCOW2(w io.WriteSeeker, r io.Reader, size int64) error {
+	// The header is in the first cluster, it is written last.
+	q := &qcow2Writer{w: w, offset: qcow2ClusterSize}
+	q.ref(0, 1)
+
+	clusters := divRoundUp(size, qcow2ClusterSize)
+	l2 := make([]uint64, clusters)
+	buf := make([]byte, qcow2ClusterSize)
+	var compressed bytes.Buffer
+	for i := range l2 {
+		if err := readChunk(r, buf); err != nil {
+			return err
+		}
+		if isZero(buf) {
+			continue
+		}
+
+		compressed.Reset()
+		if err := compressQCOW2Cluster(&compressed, buf); err != nil {
+			return err
+		}
+
+		var err error
+		if compressed.Len() < qcow2ClusterSize-sectorSize {
+			l2[i], err = q.writeCompressed(compressed.Bytes())
+		} else {
+			l2[i], err = q.writeClusters(buf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// The L2 tables whose entries are all unallocated are left out.
+	q.offset = roundUp(q.offset, qcow2ClusterSize)
+	l1 := make([]uint64, divRoundUp(clusters, qcow2L2Entries))
+	table := make([]byte, qcow2ClusterSize)
+	for i := range l1 {
+		entries := l2[i*qcow2L2Entries : min((i+1)*qcow2L2Entries, len(l2))]
+		clear(table)
+		allocated := false
+		for j, entry := range entries {
+			binary.BigEndian.PutUint64(table[j*8:], entry)
+			allocated = allocated || entry != 0
+		}
+		if !allocated {
+			continue
+		}
+
+		entry, err := q.writeClusters(table)
+		if err != nil {
+			return err
+		}
+		l1[i] = entry
+	}
+
+	l1Offset := q.offset
+	if _, err := q.writeClusters(qcow2Table(l1)); err != nil {
+		return err
+	}
+
+	refcountTableOffset, refcountTableClusters, err := q.writeRefcounts()
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, qcow2HeaderLength)
+	be := binary.BigEndian
+	be.PutUint32(header[0:], qcow2Magic)
+	be.PutUint32(header[4:], qcow2Version)
+	be.PutUint32(header[20:], qcow2ClusterBits)
+	be.PutUint64(header[24:], uint64(size))
+	be.PutUint32(header[36:], uint32(len(l1)))
+	be.PutUint64(header[40:], uint64(l1Offset))
+	be.PutUint64(header[48:], uint64(refcountTableOffset))
+	be.PutUint32(header[56:], uint32(refcountTableClusters))
+	be.PutUint32(header[96:], qcow2RefcountOrder)
+	be.PutUint32(header[100:], qcow2HeaderLength)
+	if _, err := q.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = q.w.Write(header)
+	return err
+}
+
+// writeCompressed writes the compressed data of a cluster right after the previous one,
+// and returns its L2 entry.
+func (q *qcow2Writer) writeCompressed(data []byte) (uint64, error) {
+	offset := q.offset
+	end := offset + int64(len(data))
+	if err := q.write(data); err != nil {
+		return 0, err
+	}
+
+	// Every host cluster holding a part of the data is referenced once more.
+	for c := offset / qcow2ClusterSize; c <= (end-1)/qcow2ClusterSize; c++ {
+		q.ref(c, q.refcount(c)+1)
+	}
+	sectors := (end-1)/sectorSize - offset/sectorSize
+	return qcow2Compressed | uint64(sectors)<<qcow2CompressedSectorsShift | uint64(offset), nil
+}
+
+// writeClusters writes the data at the next cluster boundary, and returns its entry in
+// an L1 or L2 table.
+func (q *qcow2Writer) writeClusters(data []byte) (uint64, error) {
+	q.offset = roundUp(q.offset, qcow2ClusterSize)
+	offset := q.offset
+	if err := q.write(data); err != nil {
+		return 0, err
+	}
+	q.offset = roundUp(q.offset, qcow2ClusterSize)
+
+	for c := offset / qcow2ClusterSize; c < q.offset/qcow2ClusterSize; c++ {
+		q.ref(c, 1)
+	}
+	return qcow2Copied | uint64(offset), nil
+}
+
+// writeRefcounts writes the refcount blocks and the refcount table, which reference
+// themselves, and returns the offset and the number of clusters of the table.
+func (q *qcow2Writer) writeRefcounts() (int64, int64, error) {
+	q.offset = roundUp(q.offset, qcow2ClusterSize)
+	first := q.offset / qcow2ClusterSize
+
+	var blocks, tableClusters int64
+	for {
+		total := first + blocks + tableClusters
+		b := divRoundUp(total, qcow2RefcountsPerBlock)
+		t := divRoundUp(b*8, qcow2ClusterSize)
+		if b == blocks && t == tableClusters {
+			break
+		}
+		blocks, tableClusters = b, t
+	}
+	for c := first; c < first+blocks+tableClusters; c++ {
+		q.ref(c, 1)
+	}
+
+	table := make([]uint64, blocks)
+	block := make([]byte, qcow2ClusterSize)
+	for i := range table {
+		clear(block)
+		for j := range qcow2RefcountsPerBlock {
+			binary.BigEndian.PutUint16(block[j*2:], q.refcount(int64(i)*qcow2RefcountsPerBlock+int64(j)))
+		}
+		table[i] = uint64(q.offset)
+		if _, err := q.writeClusters(block); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	tableOffset := q.offset
+	if _, err := q.writeClusters(qcow2Table(table)); err != nil {
+		return 0, 0, err
+	}
+	return tableOffset, tableClusters, nil
+}
+
+func (q *qcow2Writer) write(data []byte) error {
+	if _, err := q.w.Seek(q.offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := q.w.Write(data); err != nil {
+		return err
+	}
+	q.offset += int64(len(data))
+	return nil
+}
+
+func (q *qcow2Writer) ref(cluster int64, refcount uint16) {
+	for int64(len(q.refcounts)) <= cluster {
+		q.refcounts = append(q.refcounts, 0)
+	}
+	q.refcounts[cluster] = refcount
+}
+
+func (q *qcow2Writer) refcount(cluster int64) uint16 {
+	if cluster < int64(len(q.refcounts)) {
+		return q.refcounts[cluster]
+	}
+	return 0
+}
+
+// qcow2Table returns the big-endian entries of a table, padded to a whole cluster.
+func qcow2Table(entries []uint64) []byte {
+	table := make([]byte, roundUp(int64(len(entries)*8), qcow2ClusterSize))
+	for i, entry := range entries {
+		binary.BigEndian.PutUint64(table[i*8:], entry)
+	}
+	return table
+}
+
+// compressQCOW2Cluster compresses the cluster as a raw deflate stream. QEMU inflates it
+// with a 4 KiB window, so every 4 KiB of the cluster is compressed independently: each
+// part is flushed to the byte boundary of a non-final block, and the last part ends the
+// stream.
+func compressQCOW2Cluster(w io.Writer, cluster []byte) error {
+	for offset := 0; offset < len(cluster); offset += qcow2CompressionWindow {
+		zw, err := flate.NewWriter(w, flate.DefaultCompression)
+		if err != nil {
+			return err
+		}
+		if _, err := zw.Write(cluster[offset : offset+qcow2CompressionWindow]); err != nil {
+			return err
+		}
+		if offset+qcow2CompressionWindow < len(cluster) {
+			err = zw.Flush()
+		} else {
+			err = zw.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package diskimage_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// qcow2Image is a parsed qcow2 image with 64 KiB clusters.
+type qcow2Image struct {
+	content []byte
+	size    int64
+	l2      []uint64
+}
+
+const (
+	qcow2ClusterSize = 64 * kiB
+	qcow2Copied      = 1 << 63
+	qcow2Compressed  = 1 << 62
+	qcow2OffsetMask  = 1<<54 - 1
+)
+
+func parseQCOW2(content []byte) *qcow2Image {
+	be := binary.BigEndian
+	Expect(be.Uint32(content[0:])).To(Equal(uint32(0x514649fb)))
+	Expect(be.Uint32(content[4:])).To(Equal(uint32(3)))
+	Expect(be.Uint32(content[20:])).To(Equal(uint32(16)))
+	Expect(be.Uint32(content[96:])).To(Equal(uint32(4)))
+
+	q := &qcow2Image{content: content, size: int64(be.Uint64(content[24:]))}
+	l1Size := be.Uint32(content[36:])
+	l1Offset := be.Uint64(content[40:])
+	for i := range l1Size {
+		l1Entry := be.Uint64(content[l1Offset+uint64(i)*8:])
+		l2Offset := l1Entry &^ qcow2Copied
+		for j := range uint64(qcow2ClusterSize / 8) {
+			if int64(len(q.l2))*qcow2ClusterSize >= q.size {
+				break
+			}
+			if l2Offset == 0 {
+				q.l2 = append(q.l2, 0)
+			} else {
+				Expect(l1Entry & qcow2Copied).NotTo(BeZero())
+				q.l2 = append(q.l2, be.Uint64(content[l2Offset+j*8:]))
+			}
+		}
+	}
+	return q
+}
+
+// readAt returns the guest data at the offset, within a cluster.
+func (q *qcow2Image) readAt(offset int64, length int) []byte {
+	entry := q.l2[offset/qcow2ClusterSize]
+	within := offset % qcow2ClusterSize
+	switch {
+	case entry == 0:
+		return make([]byte, length)
+	case entry&qcow2Compressed != 0:
+		hostOffset := entry & qcow2OffsetMask
+		sectors := (entry>>54)&0xff + 1
+		end := min((hostOffset&^511)+sectors*512, uint64(len(q.content)))
+		cluster, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(q.content[hostOffset:end])), qcow2ClusterSize))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cluster).To(HaveLen(qcow2ClusterSize))
+		return cluster[within : within+int64(length)]
+	default:
+		Expect(entry & qcow2Copied).NotTo(BeZero())
+		hostOffset := int64(entry &^ qcow2Copied)
+		return q.content[hostOffset+within : hostOffset+within+int64(length)]
+	}
+}
+
+// refcounts returns the refcounts of the host clusters, as stored in the image and as
+// computed from its metadata.
+func (q *qcow2Image) refcounts() (map[int64]uint16, map[int64]uint16) {
+	be := binary.BigEndian
+	stored := map[int64]uint16{}
+	tableOffset := int64(be.Uint64(q.content[48:]))
+	tableClusters := int64(be.Uint32(q.content[56:]))
+	for i := int64(0); i < tableClusters*qcow2ClusterSize/8; i++ {
+		blockOffset := int64(be.Uint64(q.content[tableOffset+i*8:]))
+		if blockOffset == 0 {
+			continue
+		}
+		for j := int64(0); j < qcow2ClusterSize/2; j++ {
+			if refcount := be.Uint16(q.content[blockOffset+j*2:]); refcount != 0 {
+				stored[i*qcow2ClusterSize/2+j] = refcount
+			}
+		}
+	}
+
+	computed := map[int64]uint16{0: 1}
+	ref := func(offset, length int64) {
+		for c := offset / qcow2ClusterSize; c <= (offset+length-1)/qcow2ClusterSize; c++ {
+			computed[c]++
+		}
+	}
+	l1Offset := int64(be.Uint64(q.content[40:]))
+	l1Size := int64(be.Uint32(q.content[36:]))
+	ref(l1Offset, l1Size*8)
+	for i := int64(0); i < l1Size; i++ {
+		if l2Offset := int64(be.Uint64(q.content[l1Offset+i*8:]) &^ qcow2Copied); l2Offset != 0 {
+			ref(l2Offset, qcow2ClusterSize)
+		}
+	}
+	for _, entry := range q.l2 {
+		switch {
+		case entry == 0:
+		case entry&qcow2Compressed != 0:
+			hostOffset := int64(entry & qcow2OffsetMask)
+			sectors := int64((entry>>54)&0xff) + 1
+			ref(hostOffset, (hostOffset&^511)+sectors*512-hostOffset)
+		default:
+			ref(int64(entry&^qcow2Copied), qcow2ClusterSize)
+		}
+	}
+	ref(tableOffset, tableClusters*qcow2ClusterSize)
+	for i := int64(0); i < tableClusters*qcow2ClusterSize/8; i++ {
+		if blockOffset := int64(be.Uint64(q.content[tableOffset+i*8:])); blockOffset != 0 {
+			ref(blockOffset, qcow2ClusterSize)
+		}
+	}
+	return stored, computed
+}
+
+var _ = Describe("qcow2", func() {
+	It("converts a raw image", func() {
+		image := testImage()
+		q := parseQCOW2(convert(image, "qcow2"))
+		Expect(q.size).To(Equal(image.size))
+		expectImage(image, q.readAt)
+	})
+
+	It("compresses the clusters with data and leaves the zero clusters out", func() {
+		image := testImage()
+		q := parseQCOW2(convert(image, "qcow2"))
+
+		var compressed, uncompressed, unallocated int
+		for _, entry := range q.l2 {
+			switch {
+			case entry == 0:
+				unallocated++
+			case entry&qcow2Compressed != 0:
+				compressed++
+			default:
+				uncompressed++
+			}
+		}
+		// The clusters full of random data do not compress.
+		Expect(uncompressed).To(Equal(3))
+		Expect(compressed).To(Equal(10))
+		Expect(unallocated).To(Equal(len(q.l2) - 13))
+	})
+
+	It("references the clusters in the refcount table", func() {
+		q := parseQCOW2(convert(testImage(), "qcow2"))
+		stored, computed := q.refcounts()
+		Expect(stored).To(Equal(computed))
+	})
+
+	It("converts an image that spans several L2 tables", func() {
+		image := &sparseImage{
+			size: 1*giB + 128*kiB,
+			extents: []extent{
+				{offset: 0, data: []byte("first cluster")},
+				{offset: 1*giB + 64*kiB, data: []byte("last cluster")},
+			},
+		}
+		content := convert(image, "qcow2")
+		q := parseQCOW2(content)
+		Expect(binary.BigEndian.Uint32(content[36:])).To(Equal(uint32(3)))
+		Expect(q.readAt(0, 13)).To(Equal([]byte("first cluster")))
+		Expect(q.readAt(1*giB+64*kiB, 12)).To(Equal([]byte("last cluster")))
+		Expect(q.readAt(512*miB, 64*kiB)).To(Equal(make([]byte, 64*kiB)))
+
+		stored, computed := q.refcounts()
+		Expect(stored).To(Equal(computed))
+	})
+})
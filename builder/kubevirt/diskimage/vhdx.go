@@ -0,0 +1,247 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package diskimage
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"unicode/utf16"
+)
+
+// VHDX images are written as dynamic disks with 2 MiB blocks and 512-byte logical sectors.
+// The blocks that only hold zeros are left out of the file.
+// Source: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-vhdx
+const (
+	vhdxMiB            = 1024 * 1024
+	vhdxBlockSize      = 2 * vhdxMiB
+	vhdxLogOffset      = 1 * vhdxMiB
+	vhdxLogLength      = 1 * vhdxMiB
+	vhdxMetadataOffset = 2 * vhdxMiB
+	vhdxMetadataLength = 1 * vhdxMiB
+	vhdxBATOffset      = 3 * vhdxMiB
+
+	// vhdxChunkRatio is the number of payload blocks between two sector bitmap entries of
+	// the block allocation table.
+	vhdxChunkRatio = (1 << 23) * sectorSize / vhdxBlockSize
+
+	// The payload block states of MS-VHDX 2.5.1.1: the content of a PAYLOAD_BLOCK_ZERO block
+	// reads as zeros, unlike the one of a PAYLOAD_BLOCK_UNMAPPED (3) block.
+	vhdxPayloadBlockZero         = 2
+	vhdxPayloadBlockFullyPresent = 6
+
+	vhdxMetadataIsVirtualDisk = 1 << 1
+	vhdxMetadataIsRequired    = 1 << 2
+)
+
+var (
+	vhdxBATRegion      = vhdxGUID(0x2dc27766, 0xf623, 0x4200, [8]byte{0x9d, 0x64, 0x11, 0x5e, 0x9b, 0xfd, 0x4a, 0x08})
+	vhdxMetadataRegion = vhdxGUID(0x8b7ca206, 0x4790, 0x4b9a, [8]byte{0xb8, 0xfe, 0x57, 0x5f, 0x05, 0x0f, 0x88, 0x6e})
+
+	vhdxFileParameters     = vhdxGUID(0xcaa16737, 0xfa36, 0x4d43, [8]byte{0xb3, 0xb6, 0x33, 0xf0, 0xaa, 0x44, 0xe7, 0x6b})
+	vhdxVirtualDiskSize    = vhdxGUID(0x2fa54224, 0xcd1b, 0x4876, [8]byte{0xb2, 0x11, 0x5d, 0xbe, 0xd8, 0x3b, 0xf4, 0xb8})
+	vhdxPage83Data         = vhdxGUID(0xbeca12ab, 0xb2e6, 0x4523, [8]byte{0x93, 0xef, 0xc3, 0x09, 0xe0, 0x00, 0xc7, 0x46})
+	vhdxLogicalSectorSize  = vhdxGUID(0x8141bf1d, 0xa96f, 0x4709, [8]byte{0xba, 0x47, 0xf2, 0x33, 0xa8, 0xfa, 0xab, 0x5f})
+	vhdxPhysicalSectorSize = vhdxGUID(0xcda348c7, 0x445d, 0x4471, [8]byte{0x9c, 0xc9, 0xe9, 0x88, 0x52, 0x51, 0xc5, 0x56})
+
+	crc32c = crc32.MakeTable(crc32.Castagnoli)
+)
+
+func writeVHDX(w io.WriteSeeker, r io.Reader, size int64) error {
+	blocks := divRoundUp(size, vhdxBlockSize)
+	bat := make([]uint64, blocks+(blocks-1)/vhdxChunkRatio)
+	batLength := roundUp(int64(len(bat)*8), vhdxMiB)
+
+	// The payload blocks follow the block allocation table.
+	offset := vhdxBATOffset + batLength
+	buf := make([]byte, vhdxBlockSize)
+	for i := range blocks {
+		if err := readChunk(r, buf); err != nil {
+			return err
+		}
+
+		entry := i + i/vhdxChunkRatio
+		if isZero(buf) {
+			bat[entry] = vhdxPayloadBlockZero
+			continue
+		}
+		if _, err := w.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		bat[entry] = uint64(offset/vhdxMiB)<<20 | vhdxPayloadBlockFullyPresent
+		offset += vhdxBlockSize
+	}
+
+	batTable := make([]byte, batLength)
+	for i, entry := range bat {
+		binary.LittleEndian.PutUint64(batTable[i*8:], entry)
+	}
+
+	metadata, err := vhdxMetadata(size)
+	if err != nil {
+		return err
+	}
+	headers, err := vhdxHeaders()
+	if err != nil {
+		return err
+	}
+	regions := vhdxRegionTable(batLength)
+
+	for _, part := range []struct {
+		offset int64
+		data   []byte
+	}{
+		{0, vhdxFileIdentifier()},
+		{64 * 1024, headers[0]},
+		{128 * 1024, headers[1]},
+		{192 * 1024, regions},
+		{256 * 1024, regions},
+		// The log is empty, its region is zeroed.
+		{vhdxLogOffset, make([]byte, vhdxLogLength)},
+		{vhdxMetadataOffset, metadata},
+		{vhdxBATOffset, batTable},
+	} {
+		if _, err := w.Seek(part.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := w.Write(part.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vhdxFileIdentifier returns the file type identifier at the start of the file.
+func vhdxFileIdentifier() []byte {
+	identifier := make([]byte, 64*1024)
+	copy(identifier, "vhdxfile")
+	for i, c := range utf16.Encode([]rune("packer-plugin-kubevirt")) {
+		binary.LittleEndian.PutUint16(identifier[8+i*2:], c)
+	}
+	return identifier
+}
+
+// vhdxHeaders returns the two headers of the file, the second one being the current one.
+func vhdxHeaders() ([2][]byte, error) {
+	var headers [2][]byte
+	fileWriteGUID, err := randomGUID()
+	if err != nil {
+		return headers, err
+	}
+	dataWriteGUID, err := randomGUID()
+	if err != nil {
+		return headers, err
+	}
+
+	for i := range headers {
+		header := make([]byte, 4*1024)
+		le := binary.LittleEndian
+		copy(header[0:], "head")
+		le.PutUint64(header[8:], uint64(i+1))
+		copy(header[16:], fileWriteGUID)
+		copy(header[32:], dataWriteGUID)
+		// The log GUID is zero, the log is empty.
+		le.PutUint16(header[64:], 0)
+		le.PutUint16(header[66:], 1)
+		le.PutUint32(header[68:], vhdxLogLength)
+		le.PutUint64(header[72:], vhdxLogOffset)
+		le.PutUint32(header[4:], crc32.Checksum(header, crc32c))
+		headers[i] = header
+	}
+	return headers, nil
+}
+
+// vhdxRegionTable returns the region table of the block allocation table and the metadata.
+func vhdxRegionTable(batLength int64) []byte {
+	table := make([]byte, 64*1024)
+	le := binary.LittleEndian
+	copy(table[0:], "regi")
+	le.PutUint32(table[8:], 2)
+
+	for i, region := range []struct {
+		guid   []byte
+		offset uint64
+		length uint32
+	}{
+		{vhdxBATRegion, vhdxBATOffset, uint32(batLength)},
+		{vhdxMetadataRegion, vhdxMetadataOffset, vhdxMetadataLength},
+	} {
+		entry := table[16+i*32:]
+		copy(entry[0:], region.guid)
+		le.PutUint64(entry[16:], region.offset)
+		le.PutUint32(entry[24:], region.length)
+		le.PutUint32(entry[28:], 1)
+	}
+	le.PutUint32(table[4:], crc32.Checksum(table, crc32c))
+	return table
+}
+
+// vhdxMetadata returns the metadata region of a disk of size bytes.
+func vhdxMetadata(size int64) ([]byte, error) {
+	page83, err := randomGUID()
+	if err != nil {
+		return nil, err
+	}
+
+	fileParameters := make([]byte, 8)
+	binary.LittleEndian.PutUint32(fileParameters, vhdxBlockSize)
+	virtualDiskSize := binary.LittleEndian.AppendUint64(nil, uint64(size))
+	logicalSectorSize := binary.LittleEndian.AppendUint32(nil, sectorSize)
+	physicalSectorSize := binary.LittleEndian.AppendUint32(nil, 4096)
+
+	items := []struct {
+		guid  []byte
+		data  []byte
+		flags uint32
+	}{
+		{vhdxFileParameters, fileParameters, vhdxMetadataIsRequired},
+		{vhdxVirtualDiskSize, virtualDiskSize, vhdxMetadataIsVirtualDisk | vhdxMetadataIsRequired},
+		{vhdxPage83Data, page83, vhdxMetadataIsVirtualDisk | vhdxMetadataIsRequired},
+		{vhdxLogicalSectorSize, logicalSectorSize, vhdxMetadataIsVirtualDisk | vhdxMetadataIsRequired},
+		{vhdxPhysicalSectorSize, physicalSectorSize, vhdxMetadataIsVirtualDisk | vhdxMetadataIsRequired},
+	}
+
+	metadata := make([]byte, vhdxMetadataLength)
+	le := binary.LittleEndian
+	copy(metadata[0:], "metadata")
+	le.PutUint16(metadata[10:], uint16(len(items)))
+
+	// The items follow the 64 KiB table.
+	offset := 64 * 1024
+	for i, item := range items {
+		entry := metadata[32+i*32:]
+		copy(entry[0:], item.guid)
+		le.PutUint32(entry[16:], uint32(offset))
+		le.PutUint32(entry[20:], uint32(len(item.data)))
+		le.PutUint32(entry[24:], item.flags)
+		copy(metadata[offset:], item.data)
+		offset += len(item.data)
+	}
+	return metadata, nil
+}
+
+// vhdxGUID returns the on-disk form of a GUID, whose first three fields are little-endian.
+func vhdxGUID(data1 uint32, data2, data3 uint16, data4 [8]byte) []byte {
+	guid := make([]byte, 16)
+	binary.LittleEndian.PutUint32(guid[0:], data1)
+	binary.LittleEndian.PutUint16(guid[4:], data2)
+	binary.LittleEndian.PutUint16(guid[6:], data3)
+	copy(guid[8:], data4[:])
+	return guid
+}
+
+// randomGUID returns a random version 4 GUID in its on-disk form.
+func randomGUID() ([]byte, error) {
+	guid := make([]byte, 16)
+	if _, err := rand.Read(guid); err != nil {
+		return nil, err
+	}
+	guid[7] = guid[7]&0x0f | 0x40
+	guid[8] = guid[8]&0x3f | 0x80
+	return guid, nil
+}
@@ -0,0 +1,159 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package diskimage_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	vhdxBlockSize  = 2 * miB
+	vhdxChunkRatio = 2048
+)
+
+// The payload block states, as numbered by MS-VHDX 2.5.1.1.
+const (
+	payloadBlockNotPresent       = 0
+	payloadBlockUndefined        = 1
+	payloadBlockZero             = 2
+	payloadBlockUnmapped         = 3
+	payloadBlockFullyPresent     = 6
+	payloadBlockPartiallyPresent = 7
+)
+
+// vhdxImage is a parsed dynamic VHDX image.
+type vhdxImage struct {
+	content []byte
+	size    int64
+	bat     []uint64
+}
+
+// expectChecksum checks the CRC-32C checksum at offset 4 of a structure.
+func expectChecksum(structure []byte) {
+	data := bytes.Clone(structure)
+	checksum := binary.LittleEndian.Uint32(data[4:])
+	binary.LittleEndian.PutUint32(data[4:], 0)
+	Expect(crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))).To(Equal(checksum))
+}
+
+func parseVHDX(content []byte) *vhdxImage {
+	le := binary.LittleEndian
+	Expect(string(content[0:8])).To(Equal("vhdxfile"))
+	for _, offset := range []int{64 * kiB, 128 * kiB} {
+		header := content[offset : offset+4*kiB]
+		Expect(string(header[0:4])).To(Equal("head"))
+		Expect(le.Uint32(header[68:])).To(Equal(uint32(1 * miB)))
+		expectChecksum(header)
+	}
+
+	var batOffset, batLength, metadataOffset uint64
+	for _, offset := range []int{192 * kiB, 256 * kiB} {
+		table := content[offset : offset+64*kiB]
+		Expect(string(table[0:4])).To(Equal("regi"))
+		expectChecksum(table)
+		for i := range int(le.Uint32(table[8:])) {
+			entry := table[16+i*32:]
+			switch le.Uint32(entry[0:]) {
+			case 0x2dc27766:
+				batOffset, batLength = le.Uint64(entry[16:]), uint64(le.Uint32(entry[24:]))
+			case 0x8b7ca206:
+				metadataOffset = le.Uint64(entry[16:])
+			}
+		}
+	}
+
+	v := &vhdxImage{content: content}
+	metadata := content[metadataOffset:]
+	Expect(string(metadata[0:8])).To(Equal("metadata"))
+	for i := range int(le.Uint16(metadata[10:])) {
+		entry := metadata[32+i*32:]
+		item := metadata[le.Uint32(entry[16:]):]
+		switch le.Uint32(entry[0:]) {
+		case 0xcaa16737:
+			Expect(le.Uint32(item)).To(Equal(uint32(vhdxBlockSize)))
+		case 0x2fa54224:
+			v.size = int64(le.Uint64(item))
+		case 0x8141bf1d:
+			Expect(le.Uint32(item)).To(Equal(uint32(512)))
+		}
+	}
+
+	for i := uint64(0); i < batLength/8; i++ {
+		v.bat = append(v.bat, le.Uint64(content[batOffset+i*8:]))
+	}
+	return v
+}
+
+// block returns the BAT entry of the payload block.
+func (v *vhdxImage) block(i int64) uint64 {
+	return v.bat[i+i/vhdxChunkRatio]
+}
+
+// readAt returns the guest data at the offset, within a block.
+func (v *vhdxImage) readAt(offset int64, length int) []byte {
+	entry := v.block(offset / vhdxBlockSize)
+	switch entry & 7 {
+	case payloadBlockNotPresent, payloadBlockZero:
+		return make([]byte, length)
+	case payloadBlockFullyPresent:
+		blockOffset := int64(entry>>20) * miB
+		within := offset % vhdxBlockSize
+		return v.content[blockOffset+within : blockOffset+within+int64(length)]
+	default:
+		// The content of the unmapped and undefined blocks is not defined.
+		Fail(fmt.Sprintf("unexpected payload block state %d", entry&7))
+		return nil
+	}
+}
+
+var _ = Describe("VHDX", func() {
+	It("converts a raw image", func() {
+		image := testImage()
+		v := parseVHDX(convert(image, "vhdx"))
+		Expect(v.size).To(Equal(image.size))
+		expectImage(image, v.readAt)
+	})
+
+	It("leaves the zero blocks out", func() {
+		image := testImage()
+		content := convert(image, "vhdx")
+		v := parseVHDX(content)
+
+		Expect(v.block(0) & 7).To(Equal(uint64(payloadBlockFullyPresent)))
+		Expect(v.block(1) & 7).To(Equal(uint64(payloadBlockFullyPresent)))
+		Expect(v.block(2) & 7).To(Equal(uint64(payloadBlockZero)))
+		Expect(v.block(3) & 7).To(Equal(uint64(payloadBlockFullyPresent)))
+		Expect(int64(len(content))).To(Equal(int64(4*miB + 3*vhdxBlockSize)))
+	})
+
+	It("only uses the payload block states whose content is defined", func() {
+		v := parseVHDX(convert(testImage(), "vhdx"))
+		for i := range (v.size + vhdxBlockSize - 1) / vhdxBlockSize {
+			Expect(v.block(i)&7).To(BeElementOf(uint64(payloadBlockZero), uint64(payloadBlockFullyPresent)),
+				"state of the payload block %d", i)
+		}
+	})
+
+	It("interleaves the sector bitmap entries in the block allocation table", func() {
+		image := &sparseImage{
+			size: 4*giB + 2*vhdxBlockSize,
+			extents: []extent{
+				{offset: 0, data: []byte("first block")},
+				{offset: 4*giB + vhdxBlockSize, data: []byte("last block")},
+			},
+		}
+		v := parseVHDX(convert(image, "vhdx"))
+		Expect(v.bat[vhdxChunkRatio]).To(BeZero())
+		Expect(v.block(vhdxChunkRatio+1) & 7).To(Equal(uint64(payloadBlockFullyPresent)))
+		Expect(v.readAt(0, 11)).To(Equal([]byte("first block")))
+		Expect(v.readAt(2*giB, 2*miB)).To(Equal(make([]byte, 2*miB)))
+		Expect(v.readAt(4*giB+vhdxBlockSize, 10)).To(Equal([]byte("last block")))
+	})
+})
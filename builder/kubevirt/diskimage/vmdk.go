@@ -0,0 +1,225 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package diskimage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// VMDK images are written as streamOptimized sparse extents, the format vSphere imports
+// and OVF packages hold: the grains are compressed with zlib and preceded by markers, and
+// the grain tables follow the grains they reference.
+// Source: https://www.vmware.com/app/vmdk/?src=vmdk, "Virtual Disk Format 5.0"
+const (
+	vmdkMagic        = 0x564d444b
+	vmdkVersion      = 3
+	vmdkGrainSectors = 128
+	vmdkGrainSize    = vmdkGrainSectors * sectorSize
+	vmdkGTEsPerGT    = 512
+	vmdkOverhead     = 128
+
+	// The flags of streamOptimized extents: a valid newline detection test, compressed
+	// grains and markers.
+	vmdkFlags = 1<<0 | 1<<16 | 1<<17
+
+	vmdkCompressionDeflate = 1
+	vmdkGDAtEnd            = 0xffffffffffffffff
+
+	vmdkMarkerEOS    = 0
+	vmdkMarkerGT     = 1
+	vmdkMarkerGD     = 2
+	vmdkMarkerFooter = 3
+)
+
+// vmdkWriter writes the sectors of a streamOptimized extent.
+type vmdkWriter struct {
+	w      io.Writer
+	sector uint64
+}
+
+func writeVMDK(w io.WriteSeeker, r io.Reader, size int64) error {
+	capacity := uint64(size / sectorSize)
+	descriptor, err := vmdkDescriptor(capacity)
+	if err != nil {
+		return err
+	}
+	descriptorSectors := uint64(divRoundUp(int64(len(descriptor)), sectorSize))
+
+	v := &vmdkWriter{w: w}
+	if err := v.writeSectors(vmdkHeader(capacity, descriptorSectors, vmdkGDAtEnd)); err != nil {
+		return err
+	}
+	if err := v.writeSectors(descriptor); err != nil {
+		return err
+	}
+	if err := v.pad(vmdkOverhead); err != nil {
+		return err
+	}
+
+	grains := divRoundUp(size, vmdkGrainSize)
+	gd := make([]uint32, divRoundUp(grains, vmdkGTEsPerGT))
+	gt := make([]uint32, vmdkGTEsPerGT)
+	buf := make([]byte, vmdkGrainSize)
+	var compressed bytes.Buffer
+	for i := range grains {
+		if err := readChunk(r, buf); err != nil {
+			return err
+		}
+		if !isZero(buf) {
+			compressed.Reset()
+			zw := zlib.NewWriter(&compressed)
+			if _, err := zw.Write(buf); err != nil {
+				return err
+			}
+			if err := zw.Close(); err != nil {
+				return err
+			}
+
+			gt[i%vmdkGTEsPerGT] = uint32(v.sector)
+			marker := make([]byte, 12, 12+compressed.Len())
+			binary.LittleEndian.PutUint64(marker[0:], uint64(i*vmdkGrainSectors))
+			binary.LittleEndian.PutUint32(marker[8:], uint32(compressed.Len()))
+			if err := v.writeSectors(append(marker, compressed.Bytes()...)); err != nil {
+				return err
+			}
+		}
+
+		// The grain table is written after its last grain, unless all its grains are zero.
+		if i%vmdkGTEsPerGT == vmdkGTEsPerGT-1 || i == grains-1 {
+			if !isZeroTable(gt) {
+				if err := v.writeMarker(vmdkMarkerGT, uint64(len(gt)*4/sectorSize)); err != nil {
+					return err
+				}
+				gd[i/vmdkGTEsPerGT] = uint32(v.sector)
+				if err := v.writeSectors(vmdkTable(gt)); err != nil {
+					return err
+				}
+			}
+			clear(gt)
+		}
+	}
+
+	gdTable := vmdkTable(gd)
+	if err := v.writeMarker(vmdkMarkerGD, uint64(divRoundUp(int64(len(gdTable)), sectorSize))); err != nil {
+		return err
+	}
+	gdOffset := v.sector
+	if err := v.writeSectors(gdTable); err != nil {
+		return err
+	}
+
+	if err := v.writeMarker(vmdkMarkerFooter, 1); err != nil {
+		return err
+	}
+	if err := v.writeSectors(vmdkHeader(capacity, descriptorSectors, gdOffset)); err != nil {
+		return err
+	}
+	return v.writeMarker(vmdkMarkerEOS, 0)
+}
+
+// writeSectors writes the data, padded to a whole number of sectors.
+func (v *vmdkWriter) writeSectors(data []byte) error {
+	if _, err := v.w.Write(data); err != nil {
+		return err
+	}
+	if rem := len(data) % sectorSize; rem != 0 {
+		if _, err := v.w.Write(zeros[:sectorSize-rem]); err != nil {
+			return err
+		}
+	}
+	v.sector += uint64(divRoundUp(int64(len(data)), sectorSize))
+	return nil
+}
+
+// pad writes zero sectors up to the sector.
+func (v *vmdkWriter) pad(sector uint64) error {
+	for v.sector < sector {
+		if err := v.writeSectors(zeros[:sectorSize]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMarker writes a metadata marker, for the given number of sectors of metadata.
+func (v *vmdkWriter) writeMarker(markerType uint32, sectors uint64) error {
+	marker := make([]byte, sectorSize)
+	binary.LittleEndian.PutUint64(marker[0:], sectors)
+	binary.LittleEndian.PutUint32(marker[12:], markerType)
+	return v.writeSectors(marker)
+}
+
+// vmdkHeader returns the sparse extent header.
+func vmdkHeader(capacity, descriptorSectors, gdOffset uint64) []byte {
+	header := make([]byte, sectorSize)
+	le := binary.LittleEndian
+	le.PutUint32(header[0:], vmdkMagic)
+	le.PutUint32(header[4:], vmdkVersion)
+	le.PutUint32(header[8:], vmdkFlags)
+	le.PutUint64(header[12:], capacity)
+	le.PutUint64(header[20:], vmdkGrainSectors)
+	le.PutUint64(header[28:], 1)
+	le.PutUint64(header[36:], descriptorSectors)
+	le.PutUint32(header[44:], vmdkGTEsPerGT)
+	le.PutUint64(header[48:], 0)
+	le.PutUint64(header[56:], gdOffset)
+	le.PutUint64(header[64:], vmdkOverhead)
+	header[72] = 0
+	header[73] = '\n'
+	header[74] = ' '
+	header[75] = '\r'
+	header[76] = '\n'
+	le.PutUint16(header[77:], vmdkCompressionDeflate)
+	return header
+}
+
+// vmdkDescriptor returns the embedded descriptor of a disk of capacity sectors, with the
+// geometry of an LSI Logic adapter.
+func vmdkDescriptor(capacity uint64) ([]byte, error) {
+	var cid [4]byte
+	if _, err := rand.Read(cid[:]); err != nil {
+		return nil, err
+	}
+	cylinders := min(capacity/(255*63), 65535)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Disk DescriptorFile\n")
+	fmt.Fprintf(&b, "version=1\n")
+	fmt.Fprintf(&b, "CID=%08x\n", binary.LittleEndian.Uint32(cid[:]))
+	fmt.Fprintf(&b, "parentCID=ffffffff\n")
+	fmt.Fprintf(&b, "createType=\"streamOptimized\"\n\n")
+	fmt.Fprintf(&b, "# Extent description\n")
+	fmt.Fprintf(&b, "RW %d SPARSE \"disk.vmdk\"\n\n", capacity)
+	fmt.Fprintf(&b, "# The Disk Data Base\n")
+	fmt.Fprintf(&b, "#DDB\n\n")
+	fmt.Fprintf(&b, "ddb.virtualHWVersion = \"4\"\n")
+	fmt.Fprintf(&b, "ddb.geometry.cylinders = \"%d\"\n", cylinders)
+	fmt.Fprintf(&b, "ddb.geometry.heads = \"255\"\n")
+	fmt.Fprintf(&b, "ddb.geometry.sectors = \"63\"\n")
+	fmt.Fprintf(&b, "ddb.adapterType = \"lsilogic\"\n")
+	return b.Bytes(), nil
+}
+
+// vmdkTable returns the little-endian entries of a grain table or directory.
+func vmdkTable(entries []uint32) []byte {
+	table := make([]byte, len(entries)*4)
+	for i, entry := range entries {
+		binary.LittleEndian.PutUint32(table[i*4:], entry)
+	}
+	return table
+}
+
+func isZeroTable(entries []uint32) bool {
+	for _, entry := range entries {
+		if entry != 0 {
+			return false
+		}
+	}
+	return true
+}
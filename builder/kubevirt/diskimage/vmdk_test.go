@@ -0,0 +1,129 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package diskimage_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	vmdkGrainSize = 64 * kiB
+	vmdkGTEs      = 512
+)
+
+// vmdkImage is a parsed streamOptimized VMDK image.
+type vmdkImage struct {
+	content  []byte
+	capacity int64
+	grains   []int64
+}
+
+func parseVMDK(content []byte) *vmdkImage {
+	le := binary.LittleEndian
+	Expect(le.Uint32(content[0:])).To(Equal(uint32(0x564d444b)))
+	Expect(le.Uint64(content[56:])).To(Equal(uint64(0xffffffffffffffff)))
+
+	// The footer is the sector before the end-of-stream marker, behind its own marker.
+	footerMarker := content[len(content)-3*512:]
+	Expect(le.Uint32(footerMarker[12:])).To(Equal(uint32(3)))
+	footer := content[len(content)-2*512:]
+	Expect(le.Uint32(footer[0:])).To(Equal(uint32(0x564d444b)))
+	Expect(le.Uint32(content[len(content)-512+12:])).To(Equal(uint32(0)))
+
+	v := &vmdkImage{content: content, capacity: int64(le.Uint64(footer[12:]))}
+	Expect(le.Uint64(footer[20:])).To(Equal(uint64(128)))
+	Expect(le.Uint32(footer[44:])).To(Equal(uint32(vmdkGTEs)))
+	Expect(le.Uint16(footer[77:])).To(Equal(uint16(1)))
+
+	gdOffset := int64(le.Uint64(footer[56:])) * 512
+	grains := (v.capacity*512 + vmdkGrainSize - 1) / vmdkGrainSize
+	for i := int64(0); i < (grains+vmdkGTEs-1)/vmdkGTEs; i++ {
+		gtOffset := int64(le.Uint32(content[gdOffset+i*4:])) * 512
+		for j := int64(0); j < vmdkGTEs && int64(len(v.grains)) < grains; j++ {
+			if gtOffset == 0 {
+				v.grains = append(v.grains, 0)
+			} else {
+				v.grains = append(v.grains, int64(le.Uint32(content[gtOffset+j*4:]))*512)
+			}
+		}
+	}
+	return v
+}
+
+// readAt returns the guest data at the offset, within a grain.
+func (v *vmdkImage) readAt(offset int64, length int) []byte {
+	i := offset / vmdkGrainSize
+	grainOffset := v.grains[i]
+	if grainOffset == 0 {
+		return make([]byte, length)
+	}
+
+	le := binary.LittleEndian
+	Expect(le.Uint64(v.content[grainOffset:])).To(Equal(uint64(i * vmdkGrainSize / 512)))
+	size := int64(le.Uint32(v.content[grainOffset+8:]))
+	zr, err := zlib.NewReader(bytes.NewReader(v.content[grainOffset+12 : grainOffset+12+size]))
+	Expect(err).NotTo(HaveOccurred())
+	grain, err := io.ReadAll(zr)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(grain).To(HaveLen(vmdkGrainSize))
+	within := offset % vmdkGrainSize
+	return grain[within : within+int64(length)]
+}
+
+func readVMDK(content []byte) func(offset int64, length int) []byte {
+	return parseVMDK(content).readAt
+}
+
+var _ = Describe("VMDK", func() {
+	It("converts a raw image", func() {
+		image := testImage()
+		content := convert(image, "vmdk")
+		v := parseVMDK(content)
+		Expect(v.capacity * 512).To(Equal(image.size))
+		expectImage(image, v.readAt)
+	})
+
+	It("embeds a streamOptimized descriptor", func() {
+		image := testImage()
+		content := convert(image, "vmdk")
+		descriptor := string(content[512 : 2*512])
+		Expect(descriptor).To(ContainSubstring(`createType="streamOptimized"`))
+		Expect(descriptor).To(ContainSubstring(`RW 14465 SPARSE "disk.vmdk"`))
+	})
+
+	It("leaves the zero grains out", func() {
+		image := testImage()
+		content := convert(image, "vmdk")
+		v := parseVMDK(content)
+
+		var allocated int
+		for _, grain := range v.grains {
+			if grain != 0 {
+				allocated++
+			}
+		}
+		Expect(allocated).To(Equal(13))
+		Expect(int64(len(content))).To(BeNumerically("<", image.size/4))
+	})
+
+	It("converts an image that spans several grain tables", func() {
+		image := &sparseImage{
+			size: 128*miB + 64*kiB,
+			extents: []extent{
+				{offset: 0, data: []byte("first grain")},
+				{offset: 128 * miB, data: []byte("last grain")},
+			},
+		}
+		v := parseVMDK(convert(image, "vmdk"))
+		Expect(v.readAt(0, 11)).To(Equal([]byte("first grain")))
+		Expect(v.readAt(64*miB, 64*kiB)).To(Equal(make([]byte, 64*kiB)))
+		Expect(v.readAt(128*miB, 10)).To(Equal([]byte("last grain")))
+	})
+})
@@ -3,16 +3,56 @@
 
 package iso
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	registryimage "github.com/hashicorp/packer-plugin-sdk/packer/registry/image"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"kubevirt.io/client-go/kubecli"
+)
+
+// ArtifactDeleteTimeout is how long Destroy waits for the image resources to be deleted.
+var ArtifactDeleteTimeout = 5 * time.Minute
+
 type Artifact struct {
 	Name string
+
+	// Client is the client of the cluster holding the image, used by Destroy.
+	Client kubecli.KubevirtClient
+
+	// StateData holds the data returned by State, such as the generated data
+	// that post-processors read through "generated_data", and the details of the
+	// created image, e.g. "namespace", "data_source" or "iso_source".
+	StateData map[string]interface{}
 }
 
 func (a *Artifact) BuilderId() string {
 	return "packer.kubevirt.iso"
 }
 
+// Files returns the disk image downloaded to export_path, if any, followed by the images
+// it was converted to, ordered by format.
 func (a *Artifact) Files() []string {
-	return nil
+	var files []string
+	if path, _ := a.StateData["export_path"].(string); path != "" {
+		files = append(files, path)
+	}
+	convertedImages, _ := a.StateData["converted_images"].(map[string]string)
+	for _, format := range slices.Sorted(maps.Keys(convertedImages)) {
+		files = append(files, convertedImages[format])
+	}
+	return files
 }
 
 func (a *Artifact) Id() string {
@@ -20,13 +60,171 @@ func (a *Artifact) Id() string {
 }
 
 func (a *Artifact) String() string {
-	return a.Name
+	namespace, _ := a.StateData["namespace"].(string)
+	if namespace == "" {
+		return a.Name
+	}
+	return fmt.Sprintf("DataSource %s/%s", namespace, a.Name)
 }
 
 func (a *Artifact) State(name string) interface{} {
-	return nil
+	if name == registryimage.ArtifactStateURI {
+		return a.stateHCPPackerRegistryMetadata()
+	}
+	return a.StateData[name]
 }
 
+// Destroy deletes the DataSource and its DataVolume or VolumeSnapshot, and waits until they
+// and the PersistentVolumeClaim of the DataVolume are gone. The downloaded and converted disk
+// images and their checksum files are removed last, once the image is deleted from the cluster.
 func (a *Artifact) Destroy() error {
+	if a.Client == nil {
+		return errors.New("artifact has no cluster client to delete the image")
+	}
+
+	if err := a.destroyDataSource(); err != nil {
+		return err
+	}
+
+	for _, path := range a.Files() {
+		for _, name := range []string{path, path + ".sha256"} {
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
 	return nil
 }
+
+// destroyDataSource deletes the DataSource and the resources backing it.
+func (a *Artifact) destroyDataSource() error {
+	namespace, _ := a.StateData["namespace"].(string)
+	dataVolume, _ := a.StateData["data_volume"].(string)
+	pvc, _ := a.StateData["pvc"].(string)
+	snapshot, _ := a.StateData["volume_snapshot"].(string)
+	cdiClient := a.Client.CdiClient().CdiV1beta1()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ArtifactDeleteTimeout)
+	defer cancel()
+
+	err := cdiClient.DataSources(namespace).Delete(ctx, a.Name, metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete DataSource %s/%s: %w", namespace, a.Name, err)
+	}
+
+	if dataVolume != "" {
+		err = cdiClient.DataVolumes(namespace).Delete(ctx, dataVolume, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete DataVolume %s/%s: %w", namespace, dataVolume, err)
+		}
+	}
+
+	if snapshot != "" {
+		err = a.Client.KubernetesSnapshotClient().SnapshotV1().VolumeSnapshots(namespace).Delete(ctx, snapshot, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete VolumeSnapshot %s/%s: %w", namespace, snapshot, err)
+		}
+	}
+
+	pollInterval := 5 * time.Second
+	poller := func(ctx context.Context) (bool, error) {
+		gets := []func() error{
+			func() error {
+				_, err := cdiClient.DataSources(namespace).Get(ctx, a.Name, metav1.GetOptions{})
+				return err
+			},
+		}
+		if dataVolume != "" {
+			gets = append(gets, func() error {
+				_, err := cdiClient.DataVolumes(namespace).Get(ctx, dataVolume, metav1.GetOptions{})
+				return err
+			})
+		}
+		if snapshot != "" {
+			gets = append(gets, func() error {
+				_, err := a.Client.KubernetesSnapshotClient().SnapshotV1().VolumeSnapshots(namespace).Get(ctx, snapshot, metav1.GetOptions{})
+				return err
+			})
+		}
+		if pvc != "" {
+			// The PersistentVolumeClaim is garbage collected once its DataVolume is deleted.
+			gets = append(gets, func() error {
+				_, err := a.Client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvc, metav1.GetOptions{})
+				return err
+			})
+		}
+
+		for _, get := range gets {
+			err := get()
+			if err == nil {
+				return false, nil
+			}
+			if !k8serrors.IsNotFound(err) {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	if err := wait.PollUntilContextCancel(ctx, pollInterval, true, poller); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s waiting for the DataSource %s/%s to be deleted", ArtifactDeleteTimeout, namespace, a.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// registryLabelPrefixes names the labels of the state data holding a map of the disk image
+// formats, e.g. "converted_image_qcow2".
+var registryLabelPrefixes = map[string]string{
+	"converted_images":        "converted_image_",
+	"converted_images_sha256": "converted_image_sha256_",
+}
+
+// stateHCPPackerRegistryMetadata returns the metadata of the DataSource recorded by
+// HCP Packer and the manifest post-processor.
+func (a *Artifact) stateHCPPackerRegistryMetadata() interface{} {
+	namespace, _ := a.StateData["namespace"].(string)
+	cluster, _ := a.StateData["cluster"].(string)
+	isoSource, _ := a.StateData["iso_source"].(string)
+
+	img, err := registryimage.FromArtifact(a,
+		registryimage.WithProvider("kubevirt"),
+		registryimage.WithID(fmt.Sprintf("%s/%s", namespace, a.Name)),
+		registryimage.WithRegion(cluster),
+		registryimage.WithSourceID(isoSource),
+		registryimage.SetLabels(a.registryLabels()),
+	)
+	if err != nil {
+		log.Printf("[DEBUG] error encountered when creating the HCP Packer registry image: %s", err)
+		return nil
+	}
+	return img
+}
+
+// registryLabels returns the state data as the string labels kept by the HCP Packer registry:
+// the maps are flattened into a label per key, and the other values are formatted.
+func (a *Artifact) registryLabels() map[string]interface{} {
+	labels := make(map[string]interface{})
+	for k, v := range a.StateData {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			labels[k] = v
+		case map[string]string:
+			prefix, ok := registryLabelPrefixes[k]
+			if !ok {
+				prefix = k + "_"
+			}
+			for key, value := range v {
+				labels[prefix+key] = value
+			}
+		case map[string]interface{}:
+			// The generated data is recorded by the post-processors.
+		default:
+			labels[k] = fmt.Sprint(v)
+		}
+	}
+	return labels
+}
@@ -0,0 +1,259 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	registryimage "github.com/hashicorp/packer-plugin-sdk/packer/registry/image"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+
+	fakecdiclient "kubevirt.io/client-go/containerizeddataimporter/fake"
+	fakesnapshotclient "kubevirt.io/client-go/externalsnapshotter/fake"
+	"kubevirt.io/client-go/kubecli"
+	cdiv1beta1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+)
+
+var _ = Describe("Artifact", func() {
+	var artifact *iso.Artifact
+
+	BeforeEach(func() {
+		artifact = &iso.Artifact{
+			Name: "fedora-42",
+			StateData: map[string]interface{}{
+				"generated_data": map[string]interface{}{"PodIP": "10.244.1.7"},
+				"cluster":        "https://api.example.com:6443",
+				"namespace":      "images",
+				"data_source":    "fedora-42",
+				"data_volume":    "fedora-42",
+				"pvc":            "fedora-42",
+				"disk_size":      "10Gi",
+				"iso_source":     "https://example.com/fedora.iso",
+			},
+		}
+	})
+
+	It("returns the state data", func() {
+		Expect(artifact.State("namespace")).To(Equal("images"))
+		Expect(artifact.State("generated_data")).To(HaveKeyWithValue("PodIP", "10.244.1.7"))
+		Expect(artifact.State("unknown")).To(BeNil())
+	})
+
+	It("describes the DataSource", func() {
+		Expect(artifact.Id()).To(Equal("fedora-42"))
+		Expect(artifact.String()).To(Equal("DataSource images/fedora-42"))
+	})
+
+	It("returns the downloaded disk image", func() {
+		Expect(artifact.Files()).To(BeEmpty())
+
+		artifact.StateData["export_path"] = "output/fedora-42.img"
+		Expect(artifact.Files()).To(ConsistOf("output/fedora-42.img"))
+	})
+
+	It("returns the converted disk images", func() {
+		artifact.StateData["export_path"] = "output/fedora-42.img"
+		artifact.StateData["converted_images"] = map[string]string{
+			"vmdk":  "output/fedora-42.vmdk",
+			"qcow2": "output/fedora-42.qcow2",
+		}
+		Expect(artifact.Files()).To(Equal([]string{
+			"output/fedora-42.img",
+			"output/fedora-42.qcow2",
+			"output/fedora-42.vmdk",
+		}))
+	})
+
+	It("returns the HCP Packer registry metadata", func() {
+		img, ok := artifact.State(registryimage.ArtifactStateURI).(*registryimage.Image)
+		Expect(ok).To(BeTrue())
+		Expect(img.Validate()).To(Succeed())
+		Expect(img.ProviderName).To(Equal("kubevirt"))
+		Expect(img.ImageID).To(Equal("images/fedora-42"))
+		Expect(img.ProviderRegion).To(Equal("https://api.example.com:6443"))
+		Expect(img.SourceImageID).To(Equal("https://example.com/fedora.iso"))
+		Expect(img.Labels).To(HaveKeyWithValue("disk_size", "10Gi"))
+		Expect(img.Labels).NotTo(HaveKey("generated_data"))
+	})
+
+	It("flattens the state data that is not a string into the HCP Packer registry labels", func() {
+		artifact.StateData["converted_images"] = map[string]string{
+			"qcow2": "output/fedora-42.qcow2",
+			"vmdk":  "output/fedora-42.vmdk",
+		}
+		artifact.StateData["converted_images_sha256"] = map[string]string{
+			"qcow2": "0a1b",
+			"vmdk":  "2c3d",
+		}
+		artifact.StateData["export_sha256"] = nil
+		artifact.StateData["retries"] = 3
+
+		img, ok := artifact.State(registryimage.ArtifactStateURI).(*registryimage.Image)
+		Expect(ok).To(BeTrue())
+		Expect(img.Labels).To(HaveKeyWithValue("converted_image_qcow2", "output/fedora-42.qcow2"))
+		Expect(img.Labels).To(HaveKeyWithValue("converted_image_vmdk", "output/fedora-42.vmdk"))
+		Expect(img.Labels).To(HaveKeyWithValue("converted_image_sha256_qcow2", "0a1b"))
+		Expect(img.Labels).To(HaveKeyWithValue("converted_image_sha256_vmdk", "2c3d"))
+		Expect(img.Labels).To(HaveKeyWithValue("retries", "3"))
+		Expect(img.Labels).NotTo(HaveKey("converted_images"))
+		Expect(img.Labels).NotTo(HaveKey("export_sha256"))
+	})
+
+	Context("Destroy", func() {
+		const namespace = "images"
+
+		var (
+			mockCtrl       *gomock.Controller
+			kubeClient     *fakek8sclient.Clientset
+			cdiClient      *fakecdiclient.Clientset
+			snapshotClient *fakesnapshotclient.Clientset
+		)
+
+		BeforeEach(func() {
+			mockCtrl = gomock.NewController(GinkgoT())
+			kubeClient = fakek8sclient.NewSimpleClientset()
+			cdiClient = fakecdiclient.NewSimpleClientset(
+				&cdiv1beta1.DataSource{ObjectMeta: metav1.ObjectMeta{Name: "fedora-42", Namespace: namespace}},
+				&cdiv1beta1.DataVolume{ObjectMeta: metav1.ObjectMeta{Name: "fedora-42", Namespace: namespace}},
+			)
+			snapshotClient = fakesnapshotclient.NewSimpleClientset()
+
+			kubecli.GetKubevirtClientFromClientConfig = kubecli.GetMockKubevirtClientFromClientConfig
+			mockVirt := kubecli.NewMockKubevirtClient(mockCtrl)
+			kubecli.MockKubevirtClientInstance = mockVirt
+			mockVirt.EXPECT().CoreV1().Return(kubeClient.CoreV1()).AnyTimes()
+			mockVirt.EXPECT().CdiClient().Return(cdiClient).AnyTimes()
+			mockVirt.EXPECT().KubernetesSnapshotClient().Return(snapshotClient).AnyTimes()
+			artifact.Client, _ = kubecli.GetKubevirtClientFromClientConfig(nil)
+
+			timeout := iso.ArtifactDeleteTimeout
+			iso.ArtifactDeleteTimeout = time.Second
+			DeferCleanup(func() {
+				iso.ArtifactDeleteTimeout = timeout
+			})
+		})
+
+		AfterEach(func() {
+			mockCtrl.Finish()
+		})
+
+		It("deletes the DataSource and the DataVolume", func() {
+			Expect(artifact.Destroy()).To(Succeed())
+
+			_, err := cdiClient.CdiV1beta1().DataSources(namespace).Get(context.Background(), "fedora-42", metav1.GetOptions{})
+			Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+			_, err = cdiClient.CdiV1beta1().DataVolumes(namespace).Get(context.Background(), "fedora-42", metav1.GetOptions{})
+			Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("deletes the VolumeSnapshot of a snapshot image", func() {
+			_, err := snapshotClient.SnapshotV1().VolumeSnapshots(namespace).Create(context.Background(), &snapshotv1.VolumeSnapshot{
+				ObjectMeta: metav1.ObjectMeta{Name: "fedora-42", Namespace: namespace},
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			artifact.StateData["data_volume"] = ""
+			artifact.StateData["pvc"] = ""
+			artifact.StateData["volume_snapshot"] = "fedora-42"
+
+			Expect(artifact.Destroy()).To(Succeed())
+
+			_, err = snapshotClient.SnapshotV1().VolumeSnapshots(namespace).Get(context.Background(), "fedora-42", metav1.GetOptions{})
+			Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+			_, err = cdiClient.CdiV1beta1().DataVolumes(namespace).Get(context.Background(), "fedora-42", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("succeeds when the resources are already gone", func() {
+			Expect(artifact.Destroy()).To(Succeed())
+			Expect(artifact.Destroy()).To(Succeed())
+		})
+
+		It("waits for the PersistentVolumeClaim to be deleted", func() {
+			_, err := kubeClient.CoreV1().PersistentVolumeClaims(namespace).Create(context.Background(), &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "fedora-42", Namespace: namespace},
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = artifact.Destroy()
+			Expect(err).To(MatchError(ContainSubstring("timed out after 1s waiting for the DataSource images/fedora-42 to be deleted")))
+		})
+
+		It("fails when the DataSource cannot be deleted", func() {
+			cdiClient.PrependReactor("delete", "datasources", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				gr := schema.GroupResource{Group: "cdi.kubevirt.io", Resource: "datasources"}
+				return true, nil, k8serrors.NewForbidden(gr, "fedora-42", nil)
+			})
+
+			err := artifact.Destroy()
+			Expect(err).To(MatchError(ContainSubstring("failed to delete DataSource images/fedora-42")))
+		})
+
+		It("removes the downloaded disk image and its checksum", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "fedora-42.img")
+			Expect(os.WriteFile(path, []byte("disk"), 0644)).To(Succeed())
+			Expect(os.WriteFile(path+".sha256", []byte("checksum"), 0644)).To(Succeed())
+			artifact.StateData["export_path"] = path
+
+			Expect(artifact.Destroy()).To(Succeed())
+			Expect(path).NotTo(BeAnExistingFile())
+			Expect(path + ".sha256").NotTo(BeAnExistingFile())
+		})
+
+		It("removes the converted disk images and their checksums", func() {
+			dir := GinkgoT().TempDir()
+			path := filepath.Join(dir, "fedora-42.img")
+			qcow2Path := filepath.Join(dir, "fedora-42.qcow2")
+			for _, name := range []string{path, path + ".sha256", qcow2Path, qcow2Path + ".sha256"} {
+				Expect(os.WriteFile(name, []byte("content"), 0644)).To(Succeed())
+			}
+			artifact.StateData["export_path"] = path
+			artifact.StateData["converted_images"] = map[string]string{"qcow2": qcow2Path}
+
+			Expect(artifact.Destroy()).To(Succeed())
+			Expect(qcow2Path).NotTo(BeAnExistingFile())
+			Expect(qcow2Path + ".sha256").NotTo(BeAnExistingFile())
+		})
+
+		It("keeps the disk images when the DataSource cannot be deleted", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "fedora-42.img")
+			Expect(os.WriteFile(path, []byte("disk"), 0644)).To(Succeed())
+			artifact.StateData["export_path"] = path
+			cdiClient.PrependReactor("delete", "datasources", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				gr := schema.GroupResource{Group: "cdi.kubevirt.io", Resource: "datasources"}
+				return true, nil, k8serrors.NewForbidden(gr, "fedora-42", nil)
+			})
+
+			Expect(artifact.Destroy()).To(HaveOccurred())
+			Expect(path).To(BeAnExistingFile())
+		})
+
+		It("fails without a cluster client", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "fedora-42.img")
+			Expect(os.WriteFile(path, []byte("disk"), 0644)).To(Succeed())
+			artifact.StateData["export_path"] = path
+			artifact.Client = nil
+
+			Expect(artifact.Destroy()).To(MatchError(ContainSubstring("no cluster client")))
+			Expect(path).To(BeAnExistingFile())
+		})
+	})
+})
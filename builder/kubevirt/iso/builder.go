@@ -5,20 +5,19 @@ package iso
 
 import (
 	"context"
-	"fmt"
-
-	ssh "golang.org/x/crypto/ssh"
+	"errors"
+	"time"
 
 	"github.com/hashicorp/hcl/v2/hcldec"
 	"github.com/hashicorp/packer-plugin-sdk/communicator"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/multistep/commonsteps"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
-	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+	"golang.org/x/crypto/ssh"
 
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
 
+	instancetypeapi "kubevirt.io/api/instancetype"
 	"kubevirt.io/client-go/kubecli"
 )
 
@@ -39,37 +38,62 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 		return nil, warnings, errs
 	}
 
-	kubeConfig := b.config.KubeConfig
-	if kubeConfig == "" {
-		return nil, warnings, fmt.Errorf("KUBECONFIG environment variable is not set")
+	generatedData := []string{
+		"Host",
+		"Port",
+		"SSHPublicKey",
+		"SSHPrivateKey",
+		"Namespace",
+		"VMName",
+		"PodIP",
+		"NodeName",
 	}
+	return generatedData, warnings, nil
+}
 
-	client, err := kubecli.GetKubevirtClientFromFlags("", kubeConfig)
+func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (packer.Artifact, error) {
+	client, clientset, err := newClients(b.config)
 	if err != nil {
-		return nil, warnings, fmt.Errorf("failed to get kubevirt client: %w", err)
+		return nil, err
 	}
 	b.client = client
-
-	config, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
-	if err != nil {
-		return nil, warnings, fmt.Errorf("failed to build kubeconfig: %w", err)
-	}
-
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, warnings, fmt.Errorf("failed to create Kubernetes clientset: %w", err)
-	}
 	b.clientset = clientset
-	return nil, warnings, nil
-}
 
-func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (packer.Artifact, error) {
 	steps := []multistep.Step{}
 	steps = append(steps,
 		&StepValidateIsoDataVolume{
 			Config: b.config,
 			Client: b.client,
 		},
+	)
+
+	if b.config.OutputNamespace != b.config.Namespace {
+		steps = append(steps,
+			&StepValidateOutputNamespace{
+				Config: b.config,
+				Client: b.client,
+			},
+		)
+	}
+
+	if b.config.Comm.Type == "ssh" && (b.config.Comm.SSHPrivateKeyFile != "" || b.config.SSHTemporaryKeyPair) {
+		steps = append(steps,
+			&StepCreateSSHKeyPair{
+				Config: b.config,
+				Client: b.client,
+			},
+		)
+	}
+
+	if b.config.Comm.Type == "ssh" && (b.config.SSHHostKeyVerification == "generated" || b.config.SSHHostKeyVerification == "known_hosts") {
+		steps = append(steps,
+			&StepPrepareSSHHostKeys{
+				Config: b.config,
+			},
+		)
+	}
+
+	steps = append(steps,
 		&StepCopyMediaFiles{
 			Config: b.config,
 			Client: b.clientset,
@@ -84,26 +108,11 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 		},
 		&StepWaitForInstallation{
 			Config: b.config,
+			Client: b.client,
 		},
 	)
 
-	if b.config.Communicator == "ssh" {
-		sshSteps, err := b.buildSSHSteps()
-		if err != nil {
-			ui.Errorf("SSH communicator config error: %v", err)
-			return nil, nil
-		}
-		steps = append(steps, sshSteps...)
-	}
-
-	if b.config.Communicator == "winrm" {
-		winRMSteps, err := b.buildWinRMSteps()
-		if err != nil {
-			ui.Errorf("WinRM communicator config error: %v", err)
-			return nil, nil
-		}
-		steps = append(steps, winRMSteps...)
-	}
+	steps = append(steps, b.buildCommunicatorSteps()...)
 
 	steps = append(steps,
 		&StepStopVirtualMachine{
@@ -116,103 +125,271 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 		},
 	)
 
+	if b.config.ExportPath != "" {
+		steps = append(steps,
+			&StepExportVolume{
+				Config:        b.config,
+				Client:        b.client,
+				ForwarderFunc: DefaultExportPortForwarder,
+			},
+		)
+	}
+
+	if len(b.config.ExportConvertFormats) > 0 {
+		steps = append(steps,
+			&StepConvertExport{
+				Config: b.config,
+			},
+		)
+	}
+
+	if b.config.ContainerDiskImage != "" {
+		steps = append(steps,
+			&StepPushContainerDisk{
+				Config: b.config,
+			},
+		)
+	}
+
 	state := new(multistep.BasicStateBag)
 	state.Put("hook", hook)
 	state.Put("ui", ui)
 
+	startedAt := time.Now().UTC()
 	b.runner = commonsteps.NewRunner(steps, b.config.PackerConfig, ui)
 	b.runner.Run(ctx, state)
+	finishedAt := time.Now().UTC()
+
+	bootableVolumeName, err := BootableVolumeName(state)
+	if err != nil {
+		return nil, err
+	}
+	stateData := b.artifactStateData(state)
+	stateData["build_started_at"] = startedAt.Format(time.RFC3339)
+	stateData["build_finished_at"] = finishedAt.Format(time.RFC3339)
+	return &Artifact{
+		Name:      bootableVolumeName,
+		Client:    b.client,
+		StateData: stateData,
+	}, nil
+}
+
+// BootableVolumeName returns the name of the DataSource created by the steps run with the
+// state, or the reason why the run did not create it: the error of the failed step, or the
+// cancellation or the halt of the build.
+func BootableVolumeName(state multistep.StateBag) (string, error) {
+	if err, ok := state.GetOk("error"); ok {
+		return "", err.(error)
+	}
+
+	if _, ok := state.GetOk(multistep.StateCancelled); ok {
+		return "", errors.New("build was cancelled")
+	}
+
+	if _, ok := state.GetOk(multistep.StateHalted); ok {
+		return "", errors.New("build was halted")
+	}
 
 	bootableVolumeName, ok := state.Get("bootable_volume_name").(string)
 	if !ok || bootableVolumeName == "" {
-		return nil, fmt.Errorf("bootable volume name not found in state")
+		return "", errors.New("bootable volume name not found in state")
 	}
-	return &Artifact{Name: bootableVolumeName}, nil
+	return bootableVolumeName, nil
 }
 
-func (b *Builder) buildSSHSteps() ([]multistep.Step, []error) {
-	commConfig := &communicator.Config{
-		Type: b.config.Communicator,
-		SSH: communicator.SSH{
-			SSHHost:     b.config.SSHHost,
-			SSHPort:     b.config.SSHLocalPort,
-			SSHUsername: b.config.SSHUsername,
-			SSHPassword: b.config.SSHPassword,
-			SSHTimeout:  b.config.SSHWaitTimeout,
-		},
+// artifactStateData returns the details of the created image, for post-processors and
+// HCP Packer to record where it is and how it was built.
+func (b *Builder) artifactStateData(state multistep.StateBag) map[string]interface{} {
+	dataVolume, _ := state.Get("bootable_volume_data_volume").(string)
+	isoSource, _ := state.Get("iso_source").(string)
+	namespace, _ := state.Get("bootable_volume_namespace").(string)
+	if namespace == "" {
+		namespace = b.config.Namespace
 	}
 
-	if err := commConfig.Prepare(&interpolate.Context{}); err != nil {
-		return nil, err
+	instanceTypeKind := b.config.InstanceTypeKind
+	if instanceTypeKind == "" {
+		instanceTypeKind = instancetypeapi.ClusterSingularResourceName
+	}
+	preferenceKind := b.config.PreferenceKind
+	if preferenceKind == "" {
+		preferenceKind = instancetypeapi.ClusterSingularPreferenceResourceName
 	}
 
-	steps := []multistep.Step{
-		&StepStartPortForward{
-			Config:        b.config,
-			Client:        b.client,
-			ForwarderFunc: DefaultPortForwarder,
-		},
-		&communicator.StepConnect{
-			Config: commConfig,
-			Host: func(state multistep.StateBag) (string, error) {
-				return commConfig.SSH.SSHHost, nil
-			},
-			SSHConfig: func(state multistep.StateBag) (*ssh.ClientConfig, error) {
-				return &ssh.ClientConfig{
-					User: b.config.SSHUsername,
-					Auth: []ssh.AuthMethod{
-						ssh.Password(b.config.SSHPassword),
-					},
-					HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-				}, nil
-			},
-			SSHPort: func(state multistep.StateBag) (int, error) {
-				return b.config.SSHLocalPort, nil
-			},
-		},
-		&commonsteps.StepProvision{},
+	var cluster string
+	if restConfig := b.client.Config(); restConfig != nil {
+		cluster = restConfig.Host
+	}
+
+	stateData := map[string]interface{}{
+		"generated_data":     state.Get("generated_data"),
+		"cluster":            cluster,
+		"namespace":          namespace,
+		"build_namespace":    b.config.Namespace,
+		"data_source":        state.Get("bootable_volume_name"),
+		"data_volume":        dataVolume,
+		"pvc":                dataVolume,
+		"storage_class":      b.config.StorageClassName,
+		"output_type":        b.config.OutputType,
+		"disk_size":          b.config.DiskSize,
+		"instance_type":      b.config.InstanceType,
+		"instance_type_kind": instanceTypeKind,
+		"preference":         b.config.Preference,
+		"preference_kind":    preferenceKind,
+		"os_type":            b.config.OperatingSystemType,
+		"iso_volume_name":    b.config.IsoVolumeName,
+		"iso_source":         isoSource,
+	}
+
+	if snapshot, ok := state.GetOk("bootable_volume_snapshot"); ok {
+		stateData["volume_snapshot"] = snapshot
+		stateData["volume_snapshot_class"] = b.config.VolumeSnapshotClass
 	}
-	return steps, nil
+	if exportPath, ok := state.GetOk("export_path"); ok {
+		stateData["export_path"] = exportPath
+		stateData["export_format"] = state.Get("export_format")
+		stateData["export_sha256"] = state.Get("export_sha256")
+	}
+	if convertedImages, ok := state.GetOk("converted_images"); ok {
+		stateData["converted_images"] = convertedImages
+		stateData["converted_images_sha256"] = state.Get("converted_images_sha256")
+	}
+	if containerDiskImage, ok := state.GetOk("container_disk_image"); ok {
+		stateData["container_disk_image"] = containerDiskImage
+		stateData["container_disk_image_digest"] = state.Get("container_disk_image_digest")
+		stateData["container_disk_digest"] = state.Get("container_disk_digest")
+	}
+	return stateData
 }
 
-func (b *Builder) buildWinRMSteps() ([]multistep.Step, []error) {
-	commConfig := &communicator.Config{
-		Type: b.config.Communicator,
-		WinRM: communicator.WinRM{
-			WinRMHost:     b.config.WinRMHost,
-			WinRMPort:     b.config.WinRMLocalPort,
-			WinRMUser:     b.config.WinRMUsername,
-			WinRMPassword: b.config.WinRMPassword,
-			WinRMTimeout:  b.config.WinRMWaitTimeout,
+// buildCommunicatorSteps returns the steps that connect the communicator to the VM and run
+// the provisioners. Without a communicator, only the provisioners that do not need one run.
+func (b *Builder) buildCommunicatorSteps() []multistep.Step {
+	// Authenticates with the password, ssh_private_key_file, or the temporary key pair from the state.
+	sshConfig := b.config.Comm.SSHConfigFunc()
+	if b.config.Comm.Type == "ssh" && b.config.SSHHostKeyVerification != "none" {
+		sshConfig = pinnedHostKeySSHConfig(sshConfig)
+	}
+
+	connect := &communicator.StepConnect{
+		Config:    &b.config.Comm,
+		Host:      communicatorHost,
+		SSHConfig: sshConfig,
+		SSHPort:   communicatorPort,
+		WinRMConfig: func(state multistep.StateBag) (*communicator.WinRMConfig, error) {
+			return &communicator.WinRMConfig{
+				Username: b.config.Comm.WinRMUser,
+				Password: b.config.Comm.WinRMPassword,
+			}, nil
 		},
+		WinRMPort: communicatorPort,
 	}
 
-	if err := commConfig.Prepare(&interpolate.Context{}); err != nil {
-		return nil, err
+	steps := []multistep.Step{}
+	switch b.config.Comm.Type {
+	case "ssh":
+		if b.config.ConnectionMode == "stream" {
+			connect.CustomConnect = map[string]multistep.Step{
+				"ssh": &StepConnectStream{
+					Config:    b.config,
+					Client:    b.client,
+					SSHConfig: sshConfig,
+				},
+			}
+		}
+
+		steps = append(steps, b.buildConnectionSteps()...)
+		if b.config.SSHHostKeyVerification == "console" {
+			steps = append(steps,
+				&StepReadConsoleHostKeys{
+					Config: b.config,
+					Client: b.client,
+				},
+			)
+		}
+	case "winrm":
+		steps = append(steps, b.buildConnectionSteps()...)
 	}
 
-	steps := []multistep.Step{
-		&StepStartPortForward{
-			Config:        b.config,
-			Client:        b.client,
-			ForwarderFunc: DefaultPortForwarder,
+	steps = append(steps,
+		connect,
+		&StepUpdateGeneratedData{
+			Config: b.config,
+			Client: b.client,
 		},
-		&communicator.StepConnect{
-			Config: commConfig,
-			Host: func(state multistep.StateBag) (string, error) {
-				return commConfig.WinRMHost, nil
+		&commonsteps.StepProvision{},
+		&StepRunShutdownCommand{
+			Config: b.config,
+			Client: b.client,
+		},
+	)
+	return steps
+}
+
+// buildConnectionSteps returns the steps that make the VM reachable by the communicator,
+// depending on the connection mode.
+func (b *Builder) buildConnectionSteps() []multistep.Step {
+	switch b.config.ConnectionMode {
+	case "stream":
+		// The communicator dials the streams itself.
+		return []multistep.Step{}
+	case "port_forward":
+		return []multistep.Step{
+			&StepStartPortForward{
+				Config:        b.config,
+				Client:        b.client,
+				ForwarderFunc: DefaultPortForwarder,
 			},
-			WinRMConfig: func(state multistep.StateBag) (*communicator.WinRMConfig, error) {
-				return &communicator.WinRMConfig{
-					Username: b.config.WinRMUsername,
-					Password: b.config.WinRMPassword,
-				}, nil
+		}
+	case "service":
+		return []multistep.Step{
+			&StepCreateService{
+				Config: b.config,
+				Client: b.client,
 			},
-			WinRMPort: func(state multistep.StateBag) (int, error) {
-				return b.config.WinRMLocalPort, nil
+		}
+	default:
+		return []multistep.Step{
+			&StepWaitForVirtualMachineAddress{
+				Config: b.config,
+				Client: b.client,
 			},
-		},
-		&commonsteps.StepProvision{},
+		}
+	}
+}
+
+// pinnedHostKeySSHConfig wraps an SSH client config function so that the SSH communicator
+// only accepts the host keys found in the state.
+func pinnedHostKeySSHConfig(sshConfig func(multistep.StateBag) (*ssh.ClientConfig, error)) func(multistep.StateBag) (*ssh.ClientConfig, error) {
+	return func(state multistep.StateBag) (*ssh.ClientConfig, error) {
+		config, err := sshConfig(state)
+		if err != nil {
+			return nil, err
+		}
+
+		keys, ok := state.Get("ssh_host_keys").([]ssh.PublicKey)
+		if !ok || len(keys) == 0 {
+			return nil, errors.New("SSH host keys not found in state")
+		}
+		config.HostKeyCallback = PinnedHostKeyCallback(keys)
+		return config, nil
+	}
+}
+
+// communicatorHost returns the host the communicator connects to, as set by the connection steps.
+func communicatorHost(state multistep.StateBag) (string, error) {
+	host, ok := state.Get("communicator_host").(string)
+	if !ok || host == "" {
+		return "", errors.New("communicator host not found in state")
+	}
+	return host, nil
+}
+
+// communicatorPort returns the port the communicator connects to, as set by the connection steps.
+func communicatorPort(state multistep.StateBag) (int, error) {
+	port, ok := state.Get("communicator_port").(int)
+	if !ok || port == 0 {
+		return 0, errors.New("communicator port not found in state")
 	}
-	return steps, nil
+	return port, nil
 }
@@ -0,0 +1,84 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+var _ = Describe("Builder", func() {
+	Context("Prepare", func() {
+		It("does not need access to the cluster", func() {
+			raw := map[string]interface{}{
+				"kube_config":               "/nonexistent/kubeconfig",
+				"name":                      "fedora",
+				"namespace":                 "images",
+				"iso_volume_name":           "fedora-iso",
+				"disk_size":                 "10Gi",
+				"instance_type":             "o1.medium",
+				"preference":                "fedora",
+				"installation_wait_timeout": "15m",
+			}
+
+			var b iso.Builder
+			generatedData, _, err := b.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(generatedData).To(ConsistOf(
+				"Host", "Port", "SSHPublicKey", "SSHPrivateKey", "Namespace", "VMName", "PodIP", "NodeName",
+			))
+		})
+	})
+
+	Context("BootableVolumeName", func() {
+		var state *multistep.BasicStateBag
+
+		BeforeEach(func() {
+			state = new(multistep.BasicStateBag)
+			state.Put("bootable_volume_name", "fedora")
+		})
+
+		It("returns the name of the DataSource of a successful build", func() {
+			name, err := iso.BootableVolumeName(state)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(Equal("fedora"))
+		})
+
+		It("returns the error of the failed step", func() {
+			stepErr := errors.New("VirtualMachine (images/fedora) failed to start: ErrorUnschedulable")
+			state.Put("error", stepErr)
+			state.Put(multistep.StateHalted, true)
+
+			_, err := iso.BootableVolumeName(state)
+			Expect(err).To(BeIdenticalTo(stepErr))
+		})
+
+		It("reports a cancelled build", func() {
+			state.Put(multistep.StateCancelled, true)
+			state.Put(multistep.StateHalted, true)
+
+			_, err := iso.BootableVolumeName(state)
+			Expect(err).To(MatchError("build was cancelled"))
+		})
+
+		It("reports a halted build", func() {
+			state.Put(multistep.StateHalted, true)
+
+			_, err := iso.BootableVolumeName(state)
+			Expect(err).To(MatchError("build was halted"))
+		})
+
+		It("fails when no DataSource was created", func() {
+			state.Remove("bootable_volume_name")
+
+			_, err := iso.BootableVolumeName(state)
+			Expect(err).To(MatchError("bootable volume name not found in state"))
+		})
+	})
+})
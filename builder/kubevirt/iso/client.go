@@ -0,0 +1,90 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"kubevirt.io/client-go/kubecli"
+)
+
+// NewRESTConfig builds the configuration to access the cluster. It follows the standard
+// kubeconfig loading rules, and falls back to the in-cluster service account configuration
+// when no kubeconfig is found and Packer runs in a pod.
+func NewRESTConfig(config Config) (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if config.KubeConfig != "" {
+		var paths []string
+		for _, path := range filepath.SplitList(config.KubeConfig) {
+			if path != "" {
+				paths = append(paths, expandHome(path))
+			}
+		}
+		if len(paths) == 1 {
+			loadingRules.ExplicitPath = paths[0]
+		} else {
+			loadingRules.Precedence = paths
+		}
+	}
+
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: config.KubeContext,
+	}
+
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+	if err != nil {
+		if clientcmd.IsEmptyConfig(err) {
+			return nil, fmt.Errorf("no cluster configuration found: set kube_config or the KUBECONFIG environment variable, or run Packer in a pod")
+		}
+		return nil, fmt.Errorf("failed to build kubeconfig: %w", err)
+	}
+
+	if config.KubeImpersonateUser != "" {
+		restConfig.Impersonate = rest.ImpersonationConfig{
+			UserName: config.KubeImpersonateUser,
+			Groups:   config.KubeImpersonateGroups,
+		}
+	}
+	return restConfig, nil
+}
+
+// newClients creates the KubeVirt client and the Kubernetes clientset for the cluster.
+// It is only called when the build runs, so that Prepare does not need access to the cluster.
+func newClients(config Config) (kubecli.KubevirtClient, *kubernetes.Clientset, error) {
+	restConfig, err := NewRESTConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := kubecli.GetKubevirtClientFromRESTConfig(restConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get kubevirt client: %w", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Kubernetes clientset: %w", err)
+	}
+	return client, clientset, nil
+}
+
+// expandHome replaces a leading "~" in a path with the home directory of the user.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
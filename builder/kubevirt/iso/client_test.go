@@ -0,0 +1,109 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+)
+
+const (
+	stagingKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: staging
+  cluster:
+    server: https://staging.example.com:6443
+users:
+- name: staging
+  user:
+    token: staging-token
+contexts:
+- name: staging
+  context:
+    cluster: staging
+    user: staging
+current-context: staging
+`
+	productionKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: production
+  cluster:
+    server: https://production.example.com:6443
+users:
+- name: production
+  user:
+    token: production-token
+contexts:
+- name: production
+  context:
+    cluster: production
+    user: production
+`
+)
+
+var _ = Describe("NewRESTConfig", func() {
+	var (
+		stagingPath    string
+		productionPath string
+	)
+
+	BeforeEach(func() {
+		dir := GinkgoT().TempDir()
+		stagingPath = filepath.Join(dir, "staging")
+		productionPath = filepath.Join(dir, "production")
+		Expect(os.WriteFile(stagingPath, []byte(stagingKubeConfig), 0600)).To(Succeed())
+		Expect(os.WriteFile(productionPath, []byte(productionKubeConfig), 0600)).To(Succeed())
+	})
+
+	It("uses the current context of the kubeconfig", func() {
+		restConfig, err := iso.NewRESTConfig(iso.Config{KubeConfig: stagingPath})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restConfig.Host).To(Equal("https://staging.example.com:6443"))
+		Expect(restConfig.BearerToken).To(Equal("staging-token"))
+	})
+
+	It("merges multiple kubeconfig files and selects the context", func() {
+		restConfig, err := iso.NewRESTConfig(iso.Config{
+			KubeConfig:  stagingPath + string(os.PathListSeparator) + productionPath,
+			KubeContext: "production",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restConfig.Host).To(Equal("https://production.example.com:6443"))
+	})
+
+	It("uses the KUBECONFIG environment variable when kube_config is not set", func() {
+		GinkgoT().Setenv("KUBECONFIG", stagingPath+string(os.PathListSeparator)+productionPath)
+		restConfig, err := iso.NewRESTConfig(iso.Config{KubeContext: "production"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restConfig.Host).To(Equal("https://production.example.com:6443"))
+	})
+
+	It("sets the impersonation", func() {
+		restConfig, err := iso.NewRESTConfig(iso.Config{
+			KubeConfig:            stagingPath,
+			KubeImpersonateUser:   "system:serviceaccount:images:packer",
+			KubeImpersonateGroups: []string{"builders"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restConfig.Impersonate.UserName).To(Equal("system:serviceaccount:images:packer"))
+		Expect(restConfig.Impersonate.Groups).To(ConsistOf("builders"))
+	})
+
+	It("fails when the context does not exist", func() {
+		_, err := iso.NewRESTConfig(iso.Config{KubeConfig: stagingPath, KubeContext: "missing"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails when the kubeconfig file does not exist", func() {
+		_, err := iso.NewRESTConfig(iso.Config{KubeConfig: filepath.Join(GinkgoT().TempDir(), "missing")})
+		Expect(err).To(HaveOccurred())
+	})
+})
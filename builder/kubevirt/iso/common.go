@@ -5,15 +5,52 @@ package iso
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+	"k8s.io/client-go/util/retry"
+	ptr "k8s.io/utils/ptr"
 
+	v1 "kubevirt.io/api/core/v1"
 	"kubevirt.io/client-go/kubecli"
 	"kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
 )
 
+// WaitUntilVolumeSnapshotReady waits until the VolumeSnapshot is ready to be used to
+// provision volumes, and fails as soon as the snapshot reports an error.
+func WaitUntilVolumeSnapshotReady(ctx context.Context, client kubecli.KubevirtClient, namespace, name string) error {
+	pollInterval := 15 * time.Second
+	pollTimeout := 3600 * time.Second
+	poller := func(ctx context.Context) (bool, error) {
+		snapshot, err := client.KubernetesSnapshotClient().SnapshotV1().VolumeSnapshots(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		if snapshot.Status == nil {
+			return false, nil
+		}
+		if snapshotErr := snapshot.Status.Error; snapshotErr != nil && snapshotErr.Message != nil {
+			return false, fmt.Errorf("VolumeSnapshot (%s/%s) failed: %s", namespace, name, *snapshotErr.Message)
+		}
+		return ptr.Deref(snapshot.Status.ReadyToUse, false), nil
+	}
+	return wait.PollUntilContextTimeout(ctx, pollInterval, pollTimeout, true, poller)
+}
+
 func WaitUntilDataVolumeSucceeded(ctx context.Context, client kubecli.KubevirtClient, namespace, name string) error {
 	pollInterval := 15 * time.Second
 	pollTimeout := 3600 * time.Second
@@ -30,3 +67,220 @@ func WaitUntilDataVolumeSucceeded(ctx context.Context, client kubecli.KubevirtCl
 	}
 	return wait.PollUntilContextTimeout(ctx, pollInterval, pollTimeout, true, poller)
 }
+
+// WaitUntilVirtualMachineStopped waits until the guest of the VirtualMachineInstance powers
+// off: the VMI is watched, so that the build goes on as soon as it reaches the Succeeded phase
+// or is deleted. It fails if the VMI reaches the Failed phase.
+func WaitUntilVirtualMachineStopped(ctx context.Context, client kubecli.KubevirtClient, namespace, name string) error {
+	vmis := client.VirtualMachineInstance(namespace)
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector
+			return vmis.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			return vmis.Watch(ctx, options)
+		},
+	}
+
+	// The VMI is gone already when the guest powered off before the watch started.
+	precondition := func(store cache.Store) (bool, error) {
+		_, exists, err := store.GetByKey(namespace + "/" + name)
+		return !exists, err
+	}
+	_, err := watchtools.UntilWithSync(ctx, lw, &v1.VirtualMachineInstance{}, precondition, func(event watch.Event) (bool, error) {
+		if event.Type == watch.Deleted {
+			return true, nil
+		}
+		vmi, ok := event.Object.(*v1.VirtualMachineInstance)
+		if !ok {
+			return false, nil
+		}
+		switch vmi.Status.Phase {
+		case v1.Succeeded:
+			return true, nil
+		case v1.Failed:
+			return false, fmt.Errorf("VirtualMachineInstance (%s/%s) failed", namespace, name)
+		}
+		return false, nil
+	})
+	return err
+}
+
+// WaitUntilGuestAgentConnected waits until the QEMU guest agent of the installed OS is
+// connected and has reported the guest OS information, or the context is done.
+//
+// When no agent is connected during the ISO boot, the first agent to connect is the one of
+// the installed OS. When the installer runs a guest agent too, that agent is only accepted
+// once the guest went through a reboot: either the VirtualMachineInstance was recreated,
+// or the agent disconnected and connected again.
+func WaitUntilGuestAgentConnected(ctx context.Context, client kubecli.KubevirtClient, namespace, name string) (*v1.VirtualMachineInstance, error) {
+	vmis := client.VirtualMachineInstance(namespace)
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector
+			return vmis.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			return vmis.Watch(ctx, options)
+		},
+	}
+
+	var (
+		installerUID   types.UID
+		installerAgent bool
+		rebooted       bool
+	)
+	event, err := watchtools.UntilWithSync(ctx, lw, &v1.VirtualMachineInstance{}, nil, func(event watch.Event) (bool, error) {
+		vmi, ok := event.Object.(*v1.VirtualMachineInstance)
+		if !ok {
+			return false, nil
+		}
+		if event.Type == watch.Deleted {
+			// The VMI can be briefly missing while the VM restarts.
+			rebooted = rebooted || vmi.UID == installerUID
+			return false, nil
+		}
+
+		connected := isGuestAgentConnected(vmi)
+		if installerUID == "" {
+			installerUID = vmi.UID
+			installerAgent = connected
+		} else if vmi.UID != installerUID {
+			rebooted = true
+		}
+
+		if !connected {
+			rebooted = rebooted || installerAgent
+			return false, nil
+		}
+		return (!installerAgent || rebooted) && vmi.Status.GuestOSInfo.Name != "", nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return event.Object.(*v1.VirtualMachineInstance), nil
+}
+
+// isGuestAgentConnected returns whether the QEMU guest agent of the VirtualMachineInstance is connected.
+func isGuestAgentConnected(vmi *v1.VirtualMachineInstance) bool {
+	for _, condition := range vmi.Status.Conditions {
+		if condition.Type == v1.VirtualMachineInstanceAgentConnected && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitUntilVirtualMachineInstanceDeleted waits until the VirtualMachineInstance no longer exists,
+// or the context is done.
+func WaitUntilVirtualMachineInstanceDeleted(ctx context.Context, client kubecli.KubevirtClient, namespace, name string) error {
+	pollInterval := 5 * time.Second
+	poller := func(ctx context.Context) (bool, error) {
+		_, err := client.VirtualMachineInstance(namespace).Get(ctx, name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	return wait.PollUntilContextCancel(ctx, pollInterval, true, poller)
+}
+
+// WaitUntilLauncherPodsTerminated waits until no running virt-launcher pod of the
+// VirtualMachineInstance is left, so that its volumes are released, or the context is done.
+func WaitUntilLauncherPodsTerminated(ctx context.Context, client kubecli.KubevirtClient, namespace, name string) error {
+	pollInterval := 5 * time.Second
+	labelSelector := labels.SelectorFromSet(labels.Set{
+		v1.AppLabel:                "virt-launcher",
+		v1.VirtualMachineNameLabel: name,
+	})
+	poller := func(ctx context.Context) (bool, error) {
+		pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: labelSelector.String(),
+		})
+		if err != nil {
+			return false, err
+		}
+
+		for _, pod := range pods.Items {
+			if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	return wait.PollUntilContextCancel(ctx, pollInterval, true, poller)
+}
+
+// UpdateVirtualMachineRunStrategy sets the run strategy of an existing VirtualMachine,
+// retrying when the VirtualMachine was modified concurrently.
+func UpdateVirtualMachineRunStrategy(ctx context.Context, client kubecli.KubevirtClient, namespace, name string, runStrategy v1.VirtualMachineRunStrategy) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		vm, err := client.VirtualMachine(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		vm.Spec.RunStrategy = ptr.To(runStrategy)
+
+		_, err = client.VirtualMachine(namespace).Update(ctx, vm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// WaitUntilInterfaceAddress waits until the VirtualMachineInstance reports an IP address for
+// the named network interface, or the context is done.
+func WaitUntilInterfaceAddress(ctx context.Context, client kubecli.KubevirtClient, namespace, name, interfaceName string) (string, error) {
+	var address string
+	pollInterval := 5 * time.Second
+	poller := func(ctx context.Context) (bool, error) {
+		vmi, err := client.VirtualMachineInstance(namespace).Get(ctx, name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		for _, iface := range vmi.Status.Interfaces {
+			if iface.Name != interfaceName {
+				continue
+			}
+			// Addresses of bridged interfaces are only known once reported by the guest agent or DHCP.
+			if iface.IP != "" {
+				address = iface.IP
+				return true, nil
+			}
+			if len(iface.IPs) > 0 {
+				address = iface.IPs[0]
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	if err := wait.PollUntilContextCancel(ctx, pollInterval, true, poller); err != nil {
+		return "", err
+	}
+	return address, nil
+}
+
+// isTransientError returns whether a request failed because the object does not exist yet
+// or the API server is temporarily unavailable, so that the request can be retried.
+func isTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	return k8serrors.IsNotFound(err) ||
+		k8serrors.IsTimeout(err) ||
+		k8serrors.IsServerTimeout(err) ||
+		k8serrors.IsTooManyRequests(err) ||
+		k8serrors.IsInternalError(err) ||
+		k8serrors.IsServiceUnavailable(err) ||
+		k8serrors.IsUnexpectedServerError(err) ||
+		errors.As(err, &netErr)
+}
@@ -11,9 +11,13 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ptr "k8s.io/utils/ptr"
 
 	fakecdiclient "kubevirt.io/client-go/containerizeddataimporter/fake"
+	fakesnapshotclient "kubevirt.io/client-go/externalsnapshotter/fake"
 	"kubevirt.io/client-go/kubecli"
 	cdiv1beta1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
 
@@ -93,3 +97,64 @@ var _ = Describe("WaitUntilDataVolumeSucceeded", func() {
 		Expect(err.Error()).To(ContainSubstring("context canceled"))
 	})
 })
+
+var _ = Describe("WaitUntilVolumeSnapshotReady", func() {
+	const (
+		namespace = "test-ns"
+		name      = "test-snapshot"
+	)
+
+	var (
+		ctrl           *gomock.Controller
+		virtClient     kubecli.KubevirtClient
+		snapshotClient *fakesnapshotclient.Clientset
+	)
+
+	createSnapshot := func(status *snapshotv1.VolumeSnapshotStatus) {
+		_, err := snapshotClient.SnapshotV1().VolumeSnapshots(namespace).Create(context.Background(), &snapshotv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Status:     status,
+		}, metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		snapshotClient = fakesnapshotclient.NewSimpleClientset()
+
+		kubecli.GetKubevirtClientFromClientConfig = kubecli.GetMockKubevirtClientFromClientConfig
+		kubecli.MockKubevirtClientInstance = kubecli.NewMockKubevirtClient(ctrl)
+		kubecli.MockKubevirtClientInstance.EXPECT().KubernetesSnapshotClient().Return(snapshotClient).AnyTimes()
+
+		virtClient, _ = kubecli.GetKubevirtClientFromClientConfig(nil)
+	})
+
+	AfterEach(func() {
+		ctrl.Finish()
+	})
+
+	It("returns nil when the VolumeSnapshot is ready to use", func() {
+		createSnapshot(&snapshotv1.VolumeSnapshotStatus{ReadyToUse: ptr.To(true)})
+
+		err := iso.WaitUntilVolumeSnapshotReady(context.Background(), virtClient, namespace, name)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("returns the error of the VolumeSnapshot", func() {
+		createSnapshot(&snapshotv1.VolumeSnapshotStatus{
+			Error: &snapshotv1.VolumeSnapshotError{Message: ptr.To("failed to take snapshot")},
+		})
+
+		err := iso.WaitUntilVolumeSnapshotReady(context.Background(), virtClient, namespace, name)
+		Expect(err).To(MatchError("VolumeSnapshot (test-ns/test-snapshot) failed: failed to take snapshot"))
+	})
+
+	It("returns error when context is cancelled before the VolumeSnapshot is ready", func() {
+		createSnapshot(nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := iso.WaitUntilVolumeSnapshotReady(ctx, virtClient, namespace, name)
+		Expect(err).To(MatchError(ContainSubstring("context canceled")))
+	})
+})
@@ -7,11 +7,28 @@
 package iso
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/diskimage"
 	"github.com/hashicorp/packer-plugin-sdk/common"
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
+	"github.com/hashicorp/packer-plugin-sdk/communicator/sshkey"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/shutdowncommand"
 	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	instancetypeapi "kubevirt.io/api/instancetype"
 )
 
 // Network represents a network type and a resource that should be connected to the VM.
@@ -60,11 +77,25 @@ type MultusNetwork struct {
 }
 
 type Config struct {
-	common.PackerConfig `mapstructure:",squash"`
+	common.PackerConfig            `mapstructure:",squash"`
+	shutdowncommand.ShutdownConfig `mapstructure:",squash"`
+	Comm                           communicator.Config `mapstructure:",squash"`
 
-	// KubeConfig is the path to the kubeconfig file.
-	KubeConfig string `mapstructure:"kube_config" required:"true"`
+	// KubeConfig is the path to the kubeconfig file. Multiple paths separated by the OS path
+	// list separator are merged like the KUBECONFIG environment variable. When it is not set,
+	// the standard loading rules are used: the KUBECONFIG environment variable, then
+	// ~/.kube/config, then the in-cluster service account configuration when Packer runs in a pod.
+	KubeConfig string `mapstructure:"kube_config" required:"false"`
+	// KubeContext is the kubeconfig context to use. Default is the current context.
+	KubeContext string `mapstructure:"kube_context" required:"false"`
+	// KubeImpersonateUser is the user to impersonate for all the requests to the cluster.
+	KubeImpersonateUser string `mapstructure:"kube_impersonate_user" required:"false"`
+	// KubeImpersonateGroups are the groups to impersonate for all the requests to the cluster.
+	// Requires kube_impersonate_user.
+	KubeImpersonateGroups []string `mapstructure:"kube_impersonate_groups" required:"false"`
 	// Name is the name of the VM image.
+	// It also names the temporary resources of the build, so it must be a DNS-1035 label
+	// of at most 50 characters.
 	Name string `mapstructure:"name" required:"true"`
 	// Namespace is the namespace in which to create the VM image.
 	Namespace string `mapstructure:"namespace" required:"true"`
@@ -76,15 +107,31 @@ type Config struct {
 	// StorageClassName is the name of the storage class to use for the root disk.
 	// If not specified, the default storage class will be used.
 	StorageClassName string `mapstructure:"storage_class_name" required:"false"`
+	// OutputNamespace is the namespace the DataSource of the image and its DataVolume are
+	// published to, e.g. a namespace of golden images shared by its consumers. The root disk
+	// is cloned from the build namespace, which requires the permission to create
+	// 'datavolumes/source' in the build namespace. The temporary resources of the build
+	// stay in the build namespace. Default is the build namespace.
+	OutputNamespace string `mapstructure:"output_namespace" required:"false"`
+	// OutputType is how the DataSource of the image holds the disk, 'pvc' or 'snapshot'.
+	// With 'pvc', the root disk is cloned to a DataVolume. With 'snapshot', a VolumeSnapshot
+	// of the root disk is taken instead, which is cheaper on CSI storage with snapshot
+	// support. The snapshot is kept when the root disk is deleted. Default is 'pvc'.
+	OutputType string `mapstructure:"output_type" required:"false"`
+	// VolumeSnapshotClass is the name of the VolumeSnapshotClass of the snapshot when
+	// output_type is 'snapshot'. Default is the default class of the CSI driver.
+	VolumeSnapshotClass string `mapstructure:"volume_snapshot_class" required:"false"`
 	// InstanceType is the name of the InstanceType resource to use in the temporary VM.
 	InstanceType string `mapstructure:"instance_type" required:"true"`
 	// InstanceTypeKind is the kind of the InstanceType resource to use in the temporary VM.
-	// Other supported value is "virtualmachineclusterinstancetype".
+	// Supported values are "virtualmachineclusterinstancetype" and "virtualmachineinstancetype".
+	// Default is "virtualmachineclusterinstancetype".
 	InstanceTypeKind string `mapstructure:"instance_type_kind" required:"false"`
 	// Preference is the name of the Preference resource to use in the temporary VM.
 	Preference string `mapstructure:"preference" required:"true"`
 	// PreferenceKind is the kind of the Preference resource to use in the temporary VM.
-	// Other supported value is "virtualmachineclusterpreference".
+	// Supported values are "virtualmachineclusterpreference" and "virtualmachinepreference".
+	// Default is "virtualmachineclusterpreference".
 	PreferenceKind string `mapstructure:"preference_kind" required:"false"`
 	// OperatingSystemType is the type of operating system to install.
 	// Supported values are "linux" and "windows". Default is "linux".
@@ -101,34 +148,90 @@ type Config struct {
 	// This is useful if the VM takes some time to boot and be ready to accept keystrokes.
 	BootWait time.Duration `mapstructure:"boot_wait" required:"false"`
 	// InstallationWaitTimeout is the amount of time to wait for the installation to be completed.
-	InstallationWaitTimeout time.Duration `mapstructure:"installation_wait_timeout" required:"true"`
-	// Communicator is the type of communicator to use to connect to the VM.
-	// Supported values are "ssh" and "winrm".
-	Communicator string `mapstructure:"communicator" required:"false"`
-	// SSHHost is the hostname or IP address to use to connect via SSH.
-	SSHHost string `mapstructure:"ssh_host" required:"false"`
-	// SSHLocalPort is the local port to use to connect via SSH.
+	// It is required when installation_complete_on is "timer". Otherwise, it is the upper bound
+	// to wait for the completion signal, and the builder waits without limit when it is not set.
+	InstallationWaitTimeout time.Duration `mapstructure:"installation_wait_timeout" required:"false"`
+	// InstallationCompleteOn is the signal used to detect that the ISO installation has completed.
+	// Supported values are "timer", "shutdown" and "guest_agent". Default is "timer".
+	//
+	// With "timer", the builder waits for the whole installation_wait_timeout.
+	// With "shutdown", the builder waits until the guest powers itself off (e.g. "poweroff"
+	// in a kickstart file). The VM is then started again for provisioning if a communicator is set.
+	// With "guest_agent", the builder waits until the QEMU guest agent of the installed OS
+	// connects and reports the guest OS information. When the installer runs a guest agent too,
+	// the agent is only accepted once the guest rebooted after the ISO boot: the VirtualMachineInstance
+	// was recreated, or the agent disconnected and connected again.
+	InstallationCompleteOn string `mapstructure:"installation_complete_on" required:"false"`
+	// ConnectionMode is how the communicator reaches the VM.
+	// With "port_forward", a local port is forwarded to the VM and the communicator connects to it.
+	// With "stream", the SSH communicator dials the KubeVirt port-forward stream directly,
+	// without binding a local port. Only supported with the "ssh" communicator, and
+	// ssh_bastion_* and ssh_proxy_* cannot be set.
+	// With "pod_ip", the communicator connects to the IP address of the pod network interface,
+	// which requires Packer to run inside the cluster.
+	// With "interface:<name>", the communicator connects to the IP address reported for the
+	// named network, e.g. a Multus bridge network that routes to the host running Packer.
+	// With "service", a temporary Service of type service_type exposes the VM and the
+	// communicator connects through it.
+	// Supported values are "port_forward", "stream", "pod_ip", "interface:<name>" and "service".
+	// Default is "port_forward".
+	ConnectionMode string `mapstructure:"connection_mode" required:"false"`
+	// ServiceType is the type of the temporary Service when connection_mode is "service".
+	// With "ClusterIP", Packer must run inside the cluster. With "NodePort", the communicator
+	// connects to the node running the VM. With "LoadBalancer", it connects to the load balancer
+	// address once assigned.
+	// Supported values are "ClusterIP", "NodePort" and "LoadBalancer". Default is "ClusterIP".
+	ServiceType string `mapstructure:"service_type" required:"false"`
+	// ServicePorts is a list of extra ports of the VM to expose through the temporary Service,
+	// in addition to the communicator port.
+	ServicePorts []int `mapstructure:"service_ports" required:"false"`
+	// SSHLocalPort is the local port forwarded to the VM when connection_mode is "port_forward".
+	// The SSH communicator connects to it on ssh_host, which defaults to "127.0.0.1".
+	// Default is 0, which allocates a free port.
 	SSHLocalPort int `mapstructure:"ssh_local_port" required:"false"`
-	// SSHRemotePort is the remote port to use to connect via SSH.
+	// SSHRemotePort is the port of the SSH service in the VM. Default is ssh_port.
 	SSHRemotePort int `mapstructure:"ssh_remote_port" required:"false"`
-	// SSHUsername is the username to use to connect via SSH.
-	SSHUsername string `mapstructure:"ssh_username" required:"false"`
-	// SSHPassword is the password to use to connect via SSH.
-	SSHPassword string `mapstructure:"ssh_password" required:"false"`
-	// SSHWaitTimeout is the amount of time to wait for the SSH service to be available.
-	SSHWaitTimeout time.Duration `mapstructure:"ssh_wait_timeout" required:"false"`
-	// WinRMHost is the hostname or IP address to use to connect via WinRM.
-	WinRMHost string `mapstructure:"winrm_host" required:"false"`
-	// WinRMLocalPort is the local port to use to connect via WinRM.
+	// SSHTemporaryKeyPair generates a key pair for the build to connect via SSH, of type
+	// temporary_key_pair_type (default "ed25519") and size temporary_key_pair_bits.
+	// The public key must be installed in the guest, see ssh_public_key_injection. Both keys
+	// are available as the build.SSHPublicKey and build.SSHPrivateKey generated data.
+	// Default is false.
+	SSHTemporaryKeyPair bool `mapstructure:"ssh_temporary_key_pair" required:"false"`
+	// SSHPublicKeyInjection is how the public key of ssh_private_key_file or ssh_temporary_key_pair
+	// is installed in the guest.
+	// With "media_files", it is added as "authorized_keys" to the media files, for the
+	// installer (e.g. a kickstart %post section) to copy it.
+	// With "access_credentials", it is propagated to the authorized keys of ssh_username by
+	// the QEMU guest agent, which must run in the installed OS.
+	// Supported values are "none", "media_files" and "access_credentials". Default is "none".
+	SSHPublicKeyInjection string `mapstructure:"ssh_public_key_injection" required:"false"`
+	// SSHHostKeyVerification is how the host key of the guest is verified.
+	// With "generated", an ED25519 host key is generated for the build and stored in a Secret,
+	// attached to the VM as a CD-ROM labelled SSHHOSTKEY with the "ssh_host_ed25519_key" and
+	// "ssh_host_ed25519_key.pub" files, for the installer to copy them to /etc/ssh.
+	// With "console", the host keys printed by cloud-init between the "BEGIN SSH HOST KEY KEYS"
+	// and "END SSH HOST KEY KEYS" markers are read from the serial console log of the VM,
+	// which requires the serial console log to be enabled in KubeVirt.
+	// With "known_hosts", the host keys are read from ssh_known_hosts_file.
+	// Supported values are "none", "generated", "console" and "known_hosts". Default is "none",
+	// which accepts any host key.
+	SSHHostKeyVerification string `mapstructure:"ssh_host_key_verification" required:"false"`
+	// SSHKnownHostsFile is the path to a known_hosts file with the trusted host keys of the guest,
+	// when ssh_host_key_verification is "known_hosts". The host names of the entries are ignored.
+	SSHKnownHostsFile string `mapstructure:"ssh_known_hosts_file" required:"false"`
+	// WinRMLocalPort is the local port forwarded to the VM when connection_mode is "port_forward".
+	// The WinRM communicator connects to it on winrm_host, which defaults to "127.0.0.1".
+	// Default is 0, which allocates a free port.
 	WinRMLocalPort int `mapstructure:"winrm_local_port" required:"false"`
-	// WinRMRemotePort is the remote port to use to connect via WinRM.
+	// WinRMRemotePort is the port of the WinRM service in the VM. Default is winrm_port.
 	WinRMRemotePort int `mapstructure:"winrm_remote_port" required:"false"`
-	// WinRMUsername is the username to use to connect via WinRM.
-	WinRMUsername string `mapstructure:"winrm_username" required:"false"`
-	// WinRMPassword is the password to use to connect via WinRM.
-	WinRMPassword string `mapstructure:"winrm_password" required:"false"`
-	// WinRMWaitTimeout is the amount of time to wait for the WinRM service to be available.
-	WinRMWaitTimeout time.Duration `mapstructure:"winrm_wait_timeout" required:"false"`
+	// WinRMWaitTimeout is a deprecated alias of winrm_timeout.
+	WinRMWaitTimeout time.Duration `mapstructure:"winrm_wait_timeout" undocumented:"true"`
+	// StopTimeout is the amount of time to wait for the guest to shut down gracefully
+	// once the VM is stopped, before it is forcibly stopped. Default is 5m.
+	// It also sets the termination grace period of the VM, so that KubeVirt does not kill
+	// the guest before it is reached.
+	StopTimeout time.Duration `mapstructure:"stop_timeout" required:"false"`
 
 	// KeepVM indicates whether to keep the temporary VM after the image has been created.
 	// If false, the VM and all its resources will be deleted after the image is created.
@@ -139,21 +242,506 @@ type Config struct {
 	// However, it is recommended to set this to false in production environments to avoid
 	// resource leaks.
 	KeepVM bool `mapstructure:"keep_vm" required:"false"`
+	// ExportPath is the local file the disk of the image is downloaded to once it is created,
+	// through a VirtualMachineExport of its PersistentVolumeClaim. The export server is
+	// reached through a port forward to its pod, so no Ingress or Route is needed.
+	// The SHA-256 checksum of the file is written next to it, with the ".sha256" suffix.
+	// The disk is not downloaded when it is empty.
+	ExportPath string `mapstructure:"export_path" required:"false"`
+	// ExportFormat is the format the disk is downloaded in, 'raw' or 'gzip'. Default is 'raw'.
+	ExportFormat string `mapstructure:"export_format" required:"false"`
+	// ExportTimeout is the amount of time to wait for the export server to be ready.
+	// Default is 10m.
+	ExportTimeout time.Duration `mapstructure:"export_timeout" required:"false"`
+	// ExportConvertFormats lists the formats the downloaded disk is converted to: 'qcow2'
+	// (compressed), 'vmdk' (streamOptimized) or 'vhdx' (dynamic). The conversion does not
+	// need qemu-img, and the clusters that only hold zeros are left out of the images.
+	// Each image is written next to export_path with the extension of its format, e.g.
+	// "output/fedora.qcow2" for "output/fedora.img", along with its ".sha256" checksum file.
+	ExportConvertFormats []string `mapstructure:"export_convert_formats" required:"false"`
+	// ContainerDiskImage is the reference of the containerDisk image the downloaded disk is
+	// pushed to, e.g. "registry.example.com/images/fedora:42". The image is built without a
+	// container engine and holds the disk in /disk as a compressed qcow2 image, so that VMs
+	// can boot from it with a containerDisk volume. It requires export_path.
+	ContainerDiskImage string `mapstructure:"container_disk_image" required:"false"`
+	// ContainerDiskUsername is the user name to authenticate to the registry with. When no
+	// credentials are set, the credentials of the Docker configuration are used, if any.
+	ContainerDiskUsername string `mapstructure:"container_disk_username" required:"false"`
+	// ContainerDiskPassword is the password or token to authenticate to the registry with.
+	ContainerDiskPassword string `mapstructure:"container_disk_password" required:"false"`
+	// ContainerDiskInsecure allows pushing to a registry over plain HTTP or with a certificate
+	// that cannot be verified, e.g. a local test registry. Default is false.
+	ContainerDiskInsecure bool `mapstructure:"container_disk_insecure" required:"false"`
+	// ContainerDiskArchitecture is the CPU architecture set in the configuration of the
+	// containerDisk image. Default is "amd64".
+	ContainerDiskArchitecture string `mapstructure:"container_disk_architecture" required:"false"`
+
+	ctx interpolate.Context
 }
 
 func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 	err := config.Decode(c, &config.DecodeOpts{
-		PluginType:  "builder.kubevirt.iso",
-		Interpolate: true,
+		PluginType:         "builder.kubevirt.iso",
+		Interpolate:        true,
+		InterpolateContext: &c.ctx,
 	}, raws...)
 	if err != nil {
 		return nil, err
 	}
 
+	var errs *packer.MultiError
+	errs = packer.MultiErrorAppend(errs, c.ShutdownConfig.Prepare(&c.ctx)...)
+
+	if c.StopTimeout == 0 {
+		c.StopTimeout = 5 * time.Minute
+	}
+
+	if c.InstallationCompleteOn == "" {
+		c.InstallationCompleteOn = "timer"
+	}
+
+	if c.OperatingSystemType == "" {
+		c.OperatingSystemType = "linux"
+	}
+
+	if len(c.KubeImpersonateGroups) > 0 && c.KubeImpersonateUser == "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("kube_impersonate_groups requires kube_impersonate_user"))
+	}
+
+	errs = packer.MultiErrorAppend(errs, validateName("name", c.Name, isBuildName)...)
+	errs = packer.MultiErrorAppend(errs, validateName("namespace", c.Namespace, validation.IsDNS1123Label)...)
+	errs = packer.MultiErrorAppend(errs, validateName("iso_volume_name", c.IsoVolumeName, validation.IsDNS1123Subdomain)...)
+	errs = packer.MultiErrorAppend(errs, validateName("instance_type", c.InstanceType, validation.IsDNS1123Subdomain)...)
+	errs = packer.MultiErrorAppend(errs, validateName("preference", c.Preference, validation.IsDNS1123Subdomain)...)
+
+	if c.StorageClassName != "" {
+		errs = packer.MultiErrorAppend(errs, validateName("storage_class_name", c.StorageClassName, validation.IsDNS1123Subdomain)...)
+	}
+
+	if c.OutputNamespace == "" {
+		c.OutputNamespace = c.Namespace
+	} else {
+		errs = packer.MultiErrorAppend(errs, validateName("output_namespace", c.OutputNamespace, validation.IsDNS1123Label)...)
+	}
+
+	if c.OutputType == "" {
+		c.OutputType = "pvc"
+	}
+	switch c.OutputType {
+	case "pvc":
+		if c.VolumeSnapshotClass != "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("volume_snapshot_class requires output_type 'snapshot'"))
+		}
+	case "snapshot":
+		// A VolumeSnapshot can only be taken in the namespace of its PersistentVolumeClaim.
+		if c.OutputNamespace != c.Namespace {
+			errs = packer.MultiErrorAppend(errs, errors.New("output_namespace requires output_type 'pvc', the snapshot is taken in the build namespace"))
+		}
+		if c.VolumeSnapshotClass != "" {
+			errs = packer.MultiErrorAppend(errs, validateName("volume_snapshot_class", c.VolumeSnapshotClass, validation.IsDNS1123Subdomain)...)
+		}
+	default:
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("output_type %q is not supported, set 'pvc' or 'snapshot'", c.OutputType))
+	}
+
+	if c.DiskSize == "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("disk_size must be specified"))
+	} else if size, err := resource.ParseQuantity(c.DiskSize); err != nil {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("disk_size %q is not a valid quantity: %w", c.DiskSize, err))
+	} else if size.Sign() <= 0 {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("disk_size %q must be greater than zero", c.DiskSize))
+	}
+
+	switch c.InstanceTypeKind {
+	case "", instancetypeapi.SingularResourceName, instancetypeapi.ClusterSingularResourceName:
+	default:
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("instance_type_kind %q is not supported, set '%s' or '%s'",
+			c.InstanceTypeKind, instancetypeapi.ClusterSingularResourceName, instancetypeapi.SingularResourceName))
+	}
+
+	switch c.PreferenceKind {
+	case "", instancetypeapi.SingularPreferenceResourceName, instancetypeapi.ClusterSingularPreferenceResourceName:
+	default:
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("preference_kind %q is not supported, set '%s' or '%s'",
+			c.PreferenceKind, instancetypeapi.ClusterSingularPreferenceResourceName, instancetypeapi.SingularPreferenceResourceName))
+	}
+
+	if c.OperatingSystemType != "linux" && c.OperatingSystemType != "windows" {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("os_type %q is not supported, set 'linux' or 'windows'", c.OperatingSystemType))
+	}
+
+	switch c.InstallationCompleteOn {
+	case "timer":
+		if c.InstallationWaitTimeout <= 0 {
+			errs = packer.MultiErrorAppend(errs, errors.New("installation_wait_timeout must be specified when installation_complete_on is 'timer'"))
+		}
+	case "shutdown", "guest_agent":
+		if c.InstallationWaitTimeout < 0 {
+			errs = packer.MultiErrorAppend(errs, errors.New("installation_wait_timeout must not be negative"))
+		}
+	default:
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("installation_complete_on %q is not supported, set 'timer', 'shutdown' or 'guest_agent'", c.InstallationCompleteOn))
+	}
+
+	errs = packer.MultiErrorAppend(errs, c.prepareCommunicator()...)
+	errs = packer.MultiErrorAppend(errs, c.prepareNetworks()...)
+	errs = packer.MultiErrorAppend(errs, c.prepareExport()...)
+	errs = packer.MultiErrorAppend(errs, c.prepareContainerDisk()...)
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return nil, errs
+	}
+	return nil, nil
+}
+
+// prepareCommunicator sets the defaults of the communicator and validates its fields.
+func (c *Config) prepareCommunicator() []error {
+	var errs []error
+
+	if c.ConnectionMode == "" {
+		c.ConnectionMode = "port_forward"
+	}
+
+	switch c.ConnectionMode {
+	case "port_forward":
+	case "stream":
+		if c.Comm.Type != "ssh" {
+			errs = append(errs, errors.New("connection_mode 'stream' requires the 'ssh' communicator"))
+		}
+		if c.Comm.SSHBastionHost != "" {
+			errs = append(errs, errors.New("connection_mode 'stream' does not support ssh_bastion_host, the stream connects to the VM directly"))
+		}
+		if c.Comm.SSHProxyHost != "" {
+			errs = append(errs, errors.New("connection_mode 'stream' does not support ssh_proxy_host, the stream connects to the VM directly"))
+		}
+	case "service":
+		if c.Comm.Type != "ssh" && c.Comm.Type != "winrm" {
+			errs = append(errs, errors.New("connection_mode 'service' requires the 'ssh' or 'winrm' communicator"))
+		}
+		if c.ServiceType == "" {
+			c.ServiceType = string(corev1.ServiceTypeClusterIP)
+		}
+		switch corev1.ServiceType(c.ServiceType) {
+		case corev1.ServiceTypeClusterIP, corev1.ServiceTypeNodePort, corev1.ServiceTypeLoadBalancer:
+		default:
+			errs = append(errs, fmt.Errorf("service_type %q is not supported, set 'ClusterIP', 'NodePort' or 'LoadBalancer'", c.ServiceType))
+		}
+		for _, port := range c.ServicePorts {
+			errs = append(errs, validatePort("service_ports", port)...)
+		}
+	case "pod_ip":
+		if c.Comm.Type != "ssh" && c.Comm.Type != "winrm" {
+			errs = append(errs, errors.New("connection_mode 'pod_ip' requires the 'ssh' or 'winrm' communicator"))
+		}
+		if c.connectionInterface() == "" {
+			errs = append(errs, errors.New("connection_mode 'pod_ip' requires a pod network"))
+		}
+	default:
+		if !strings.HasPrefix(c.ConnectionMode, "interface:") {
+			errs = append(errs, fmt.Errorf("connection_mode %q is not supported, set 'port_forward', 'stream', 'pod_ip', 'interface:<name>' or 'service'", c.ConnectionMode))
+			break
+		}
+		if c.Comm.Type != "ssh" && c.Comm.Type != "winrm" {
+			errs = append(errs, fmt.Errorf("connection_mode %q requires the 'ssh' or 'winrm' communicator", c.ConnectionMode))
+		}
+		if c.connectionInterface() == "" {
+			errs = append(errs, fmt.Errorf("connection_mode %q does not match any network, known networks are: %s", c.ConnectionMode, strings.Join(c.networkNames(), ", ")))
+		}
+	}
+
+	// Unlike other builders, no communicator is used unless one is configured.
+	if c.Comm.Type == "" {
+		c.Comm.Type = "none"
+	}
+	if c.WinRMWaitTimeout != 0 && c.Comm.WinRMTimeout == 0 {
+		c.Comm.WinRMTimeout = c.WinRMWaitTimeout
+	}
+
+	switch c.Comm.Type {
+	case "none":
+	case "ssh":
+		// The port forward listens on ssh_host.
+		if c.Comm.SSHHost == "" {
+			c.Comm.SSHHost = "127.0.0.1"
+		}
+		errs = append(errs, c.Comm.Prepare(&c.ctx)...)
+		if c.SSHRemotePort == 0 {
+			c.SSHRemotePort = c.Comm.SSHPort
+		}
+		errs = append(errs, c.prepareSSHKeys()...)
+		errs = append(errs, c.prepareSSHHostKeys()...)
+		if c.SSHLocalPort != 0 {
+			errs = append(errs, validatePort("ssh_local_port", c.SSHLocalPort)...)
+		}
+		errs = append(errs, validatePort("ssh_remote_port", c.SSHRemotePort)...)
+	case "winrm":
+		// The port forward listens on winrm_host.
+		if c.Comm.WinRMHost == "" {
+			c.Comm.WinRMHost = "127.0.0.1"
+		}
+		errs = append(errs, c.Comm.Prepare(&c.ctx)...)
+		if c.WinRMRemotePort == 0 {
+			c.WinRMRemotePort = c.Comm.WinRMPort
+		}
+		if c.Comm.WinRMPassword == "" {
+			errs = append(errs, errors.New("winrm_password must be specified"))
+		}
+		if c.WinRMLocalPort != 0 {
+			errs = append(errs, validatePort("winrm_local_port", c.WinRMLocalPort)...)
+		}
+		errs = append(errs, validatePort("winrm_remote_port", c.WinRMRemotePort)...)
+	default:
+		errs = append(errs, fmt.Errorf("communicator %q is not supported, set 'none', 'ssh' or 'winrm'", c.Comm.Type))
+	}
+
+	if c.ConnectionMode != "service" && (c.ServiceType != "" || len(c.ServicePorts) > 0) {
+		errs = append(errs, errors.New("service_type and service_ports require connection_mode 'service'"))
+	}
+
+	if c.Comm.Type != "ssh" && c.Comm.SSHUsername != "" {
+		errs = append(errs, errors.New("ssh_username is set but communicator is not 'ssh'"))
+	}
+	if c.Comm.Type != "ssh" && c.SSHHostKeyVerification != "" {
+		errs = append(errs, errors.New("ssh_host_key_verification is set but communicator is not 'ssh'"))
+	}
+	if c.Comm.Type != "winrm" && c.Comm.WinRMUser != "" {
+		errs = append(errs, errors.New("winrm_username is set but communicator is not 'winrm'"))
+	}
+
+	if c.Comm.Type == "none" {
+		if c.ShutdownCommand != "" {
+			errs = append(errs, errors.New("shutdown_command requires the 'ssh' or 'winrm' communicator"))
+		}
+	}
+	return errs
+}
+
+// prepareSSHKeys validates the SSH authentication and the injection of the public key.
+func (c *Config) prepareSSHKeys() []error {
+	var errs []error
+
+	if c.Comm.SSHPassword == "" && c.Comm.SSHPrivateKeyFile == "" && !c.SSHTemporaryKeyPair && !c.Comm.SSHAgentAuth {
+		errs = append(errs, errors.New("one of ssh_password, ssh_private_key_file, ssh_temporary_key_pair or ssh_agent_auth must be specified"))
+	}
+
+	// The SDK validates the content of ssh_private_key_file.
+	if c.Comm.SSHPrivateKeyFile != "" && c.SSHTemporaryKeyPair {
+		errs = append(errs, errors.New("only one of ssh_private_key_file or ssh_temporary_key_pair can be specified"))
+	}
+
+	if c.Comm.SSHTemporaryKeyPairType != "" {
+		if _, err := sshkey.AlgorithmString(c.Comm.SSHTemporaryKeyPairType); err != nil {
+			errs = append(errs, fmt.Errorf("temporary_key_pair_type %q is not supported, set 'dsa', 'ecdsa', 'ed25519' or 'rsa'", c.Comm.SSHTemporaryKeyPairType))
+		}
+	}
+
+	if c.SSHPublicKeyInjection == "" {
+		c.SSHPublicKeyInjection = "none"
+	}
+
+	switch c.SSHPublicKeyInjection {
+	case "none":
+	case "media_files", "access_credentials":
+		if c.Comm.SSHPrivateKeyFile == "" && !c.SSHTemporaryKeyPair {
+			errs = append(errs, fmt.Errorf("ssh_public_key_injection '%s' requires ssh_private_key_file or ssh_temporary_key_pair", c.SSHPublicKeyInjection))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("ssh_public_key_injection %q is not supported, set 'none', 'media_files' or 'access_credentials'", c.SSHPublicKeyInjection))
+	}
+	return errs
+}
+
+// prepareSSHHostKeys validates the verification of the SSH host key of the guest.
+func (c *Config) prepareSSHHostKeys() []error {
+	var errs []error
+
+	if c.SSHHostKeyVerification == "" {
+		c.SSHHostKeyVerification = "none"
+	}
+
+	switch c.SSHHostKeyVerification {
+	case "none", "generated", "console":
+	case "known_hosts":
+		if c.SSHKnownHostsFile == "" {
+			errs = append(errs, errors.New("ssh_known_hosts_file must be specified when ssh_host_key_verification is 'known_hosts'"))
+		} else if _, err := ReadKnownHostsKeys(expandHome(c.SSHKnownHostsFile)); err != nil {
+			errs = append(errs, fmt.Errorf("ssh_known_hosts_file is invalid: %w", err))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("ssh_host_key_verification %q is not supported, set 'none', 'generated', 'console' or 'known_hosts'", c.SSHHostKeyVerification))
+	}
+
+	if c.SSHHostKeyVerification != "known_hosts" && c.SSHKnownHostsFile != "" {
+		errs = append(errs, errors.New("ssh_known_hosts_file requires ssh_host_key_verification 'known_hosts'"))
+	}
+	return errs
+}
+
+// prepareExport sets the defaults of the download of the disk and validates its fields.
+func (c *Config) prepareExport() []error {
+	var errs []error
+
+	if c.ExportPath == "" {
+		if c.ExportFormat != "" || c.ExportTimeout != 0 || len(c.ExportConvertFormats) > 0 {
+			errs = append(errs, errors.New("export_format, export_timeout and export_convert_formats require export_path"))
+		}
+		return errs
+	}
+
+	if c.ExportFormat == "" {
+		c.ExportFormat = "raw"
+	}
+	if c.ExportTimeout == 0 {
+		c.ExportTimeout = 10 * time.Minute
+	}
+
+	if c.ExportFormat != "raw" && c.ExportFormat != "gzip" {
+		errs = append(errs, fmt.Errorf("export_format %q is not supported, set 'raw' or 'gzip'", c.ExportFormat))
+	}
+	if c.ExportTimeout < 0 {
+		errs = append(errs, errors.New("export_timeout must not be negative"))
+	}
+	if info, err := os.Stat(expandHome(c.ExportPath)); err == nil && info.IsDir() {
+		errs = append(errs, fmt.Errorf("export_path %q is a directory", c.ExportPath))
+	}
+
+	converted := map[string]bool{}
+	for _, format := range c.ExportConvertFormats {
+		switch {
+		case !slices.Contains(diskimage.Formats, format):
+			errs = append(errs, fmt.Errorf("export_convert_formats: format %q is not supported, set one of %s",
+				format, strings.Join(diskimage.Formats, ", ")))
+		case converted[format]:
+			errs = append(errs, fmt.Errorf("export_convert_formats: format %q is set more than once", format))
+		case convertedPath(c.ExportPath, c.ExportFormat, format) == c.ExportPath:
+			errs = append(errs, fmt.Errorf("export_convert_formats: the %s image would overwrite export_path %q",
+				format, c.ExportPath))
+		}
+		converted[format] = true
+	}
+	return errs
+}
+
+// prepareContainerDisk sets the defaults of the containerDisk image and validates its fields.
+func (c *Config) prepareContainerDisk() []error {
+	var errs []error
+
+	if c.ContainerDiskImage == "" {
+		if c.ContainerDiskUsername != "" || c.ContainerDiskPassword != "" || c.ContainerDiskInsecure || c.ContainerDiskArchitecture != "" {
+			errs = append(errs, errors.New("container_disk_username, container_disk_password, container_disk_insecure and container_disk_architecture require container_disk_image"))
+		}
+		return errs
+	}
+
+	if c.ContainerDiskArchitecture == "" {
+		c.ContainerDiskArchitecture = "amd64"
+	}
+
+	if _, err := name.ParseReference(c.ContainerDiskImage); err != nil {
+		errs = append(errs, fmt.Errorf("container_disk_image %q is not a valid image reference: %w", c.ContainerDiskImage, err))
+	}
+	if c.ExportPath == "" {
+		errs = append(errs, errors.New("container_disk_image requires export_path"))
+	}
+	if (c.ContainerDiskUsername == "") != (c.ContainerDiskPassword == "") {
+		errs = append(errs, errors.New("container_disk_username and container_disk_password must be specified together"))
+	}
+	return errs
+}
+
+// connectionInterface returns the name of the network interface whose IP address the
+// communicator connects to, or an empty string when the connection mode does not use one
+// or the network does not exist.
+func (c *Config) connectionInterface() string {
+	if c.ConnectionMode == "pod_ip" {
+		if len(c.Networks) == 0 {
+			// KubeVirt attaches the pod network as "default" when no network is defined.
+			return "default"
+		}
+		for _, n := range c.Networks {
+			// A network without a type is a pod network.
+			if n.Pod != nil || n.Multus == nil {
+				return n.Name
+			}
+		}
+		return ""
+	}
+
+	name, ok := strings.CutPrefix(c.ConnectionMode, "interface:")
+	if !ok || !slices.Contains(c.networkNames(), name) {
+		return ""
+	}
+	return name
+}
+
+// networkNames returns the names of the networks of the temporary VM.
+func (c *Config) networkNames() []string {
+	if len(c.Networks) == 0 {
+		return []string{"default"}
+	}
+
+	names := make([]string, len(c.Networks))
+	for i, n := range c.Networks {
+		names[i] = n.Name
+	}
+	return names
+}
+
+// prepareNetworks validates the networks of the temporary VM.
+func (c *Config) prepareNetworks() []error {
+	var errs []error
+
+	names := make(map[string]bool)
 	for _, n := range c.Networks {
+		errs = append(errs, validateName("network name", n.Name, validation.IsDNS1123Label)...)
+
+		if names[n.Name] {
+			errs = append(errs, fmt.Errorf("network %q is defined more than once", n.Name))
+		}
+		names[n.Name] = true
+
 		if n.Pod != nil && n.Multus != nil {
-			return nil, fmt.Errorf("network %q: only one of pod or multus can be defined", n.Name)
+			errs = append(errs, fmt.Errorf("network %q: only one of pod or multus can be defined", n.Name))
 		}
+
+		if n.Multus != nil && n.Multus.NetworkName == "" {
+			errs = append(errs, fmt.Errorf("network %q: multus networkName must be specified", n.Name))
+		}
+	}
+	return errs
+}
+
+// validateName checks that a required Kubernetes object name is set and valid.
+func validateName(field, value string, validate func(string) []string) []error {
+	if value == "" {
+		return []error{fmt.Errorf("%s must be specified", field)}
+	}
+
+	var errs []error
+	for _, msg := range validate(value) {
+		errs = append(errs, fmt.Errorf("%s %q is invalid: %s", field, value, msg))
+	}
+	return errs
+}
+
+// maxNameLength is the maximum length of the name of the image, so that the names derived
+// from it, the longest being the one of the export token Secret, fit in 63 characters.
+const maxNameLength = validation.DNS1035LabelMaxLength - len("-export-token")
+
+// isBuildName checks that the name of the image can name the temporary Service of the build
+// and be used as a label value, with room left for the suffixes of the derived resource names.
+func isBuildName(value string) []string {
+	errs := validation.IsDNS1035Label(value)
+	if len(value) > maxNameLength && len(value) <= validation.DNS1035LabelMaxLength {
+		errs = append(errs, validation.MaxLenError(maxNameLength))
+	}
+	return errs
+}
+
+// validatePort checks that a port is within the valid TCP range.
+func validatePort(field string, port int) []error {
+	if port < 1 || port > 65535 {
+		return []error{fmt.Errorf("%s must be between 1 and 65535, got %d", field, port)}
 	}
-	return nil, err
+	return nil
 }
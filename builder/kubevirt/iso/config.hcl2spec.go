@@ -10,44 +10,111 @@ import (
 // FlatConfig is an auto-generated flat version of Config.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatConfig struct {
-	PackerBuildName         *string           `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
-	PackerBuilderType       *string           `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
-	PackerCoreVersion       *string           `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
-	PackerDebug             *bool             `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
-	PackerForce             *bool             `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
-	PackerOnError           *string           `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
-	PackerUserVars          map[string]string `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
-	PackerSensitiveVars     []string          `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
-	KubeConfig              *string           `mapstructure:"kube_config" required:"true" cty:"kube_config" hcl:"kube_config"`
-	Name                    *string           `mapstructure:"name" required:"true" cty:"name" hcl:"name"`
-	Namespace               *string           `mapstructure:"namespace" required:"true" cty:"namespace" hcl:"namespace"`
-	IsoVolumeName           *string           `mapstructure:"iso_volume_name" required:"true" cty:"iso_volume_name" hcl:"iso_volume_name"`
-	DiskSize                *string           `mapstructure:"disk_size" required:"true" cty:"disk_size" hcl:"disk_size"`
-	StorageClassName        *string           `mapstructure:"storage_class_name" required:"false" cty:"storage_class_name" hcl:"storage_class_name"`
-	InstanceType            *string           `mapstructure:"instance_type" required:"true" cty:"instance_type" hcl:"instance_type"`
-	InstanceTypeKind        *string           `mapstructure:"instance_type_kind" required:"false" cty:"instance_type_kind" hcl:"instance_type_kind"`
-	Preference              *string           `mapstructure:"preference" required:"true" cty:"preference" hcl:"preference"`
-	PreferenceKind          *string           `mapstructure:"preference_kind" required:"false" cty:"preference_kind" hcl:"preference_kind"`
-	OperatingSystemType     *string           `mapstructure:"os_type" required:"false" cty:"os_type" hcl:"os_type"`
-	Networks                []FlatNetwork     `mapstructure:"networks" required:"false" cty:"networks" hcl:"networks"`
-	MediaFiles              []string          `mapstructure:"media_files" required:"false" cty:"media_files" hcl:"media_files"`
-	BootCommand             []string          `mapstructure:"boot_command" required:"false" cty:"boot_command" hcl:"boot_command"`
-	BootWait                *string           `mapstructure:"boot_wait" required:"false" cty:"boot_wait" hcl:"boot_wait"`
-	InstallationWaitTimeout *string           `mapstructure:"installation_wait_timeout" required:"true" cty:"installation_wait_timeout" hcl:"installation_wait_timeout"`
-	Communicator            *string           `mapstructure:"communicator" required:"false" cty:"communicator" hcl:"communicator"`
-	SSHHost                 *string           `mapstructure:"ssh_host" required:"false" cty:"ssh_host" hcl:"ssh_host"`
-	SSHLocalPort            *int              `mapstructure:"ssh_local_port" required:"false" cty:"ssh_local_port" hcl:"ssh_local_port"`
-	SSHRemotePort           *int              `mapstructure:"ssh_remote_port" required:"false" cty:"ssh_remote_port" hcl:"ssh_remote_port"`
-	SSHUsername             *string           `mapstructure:"ssh_username" required:"false" cty:"ssh_username" hcl:"ssh_username"`
-	SSHPassword             *string           `mapstructure:"ssh_password" required:"false" cty:"ssh_password" hcl:"ssh_password"`
-	SSHWaitTimeout          *string           `mapstructure:"ssh_wait_timeout" required:"false" cty:"ssh_wait_timeout" hcl:"ssh_wait_timeout"`
-	WinRMHost               *string           `mapstructure:"winrm_host" required:"false" cty:"winrm_host" hcl:"winrm_host"`
-	WinRMLocalPort          *int              `mapstructure:"winrm_local_port" required:"false" cty:"winrm_local_port" hcl:"winrm_local_port"`
-	WinRMRemotePort         *int              `mapstructure:"winrm_remote_port" required:"false" cty:"winrm_remote_port" hcl:"winrm_remote_port"`
-	WinRMUsername           *string           `mapstructure:"winrm_username" required:"false" cty:"winrm_username" hcl:"winrm_username"`
-	WinRMPassword           *string           `mapstructure:"winrm_password" required:"false" cty:"winrm_password" hcl:"winrm_password"`
-	WinRMWaitTimeout        *string           `mapstructure:"winrm_wait_timeout" required:"false" cty:"winrm_wait_timeout" hcl:"winrm_wait_timeout"`
-	KeepVM                  *bool             `mapstructure:"keep_vm" required:"false" cty:"keep_vm" hcl:"keep_vm"`
+	PackerBuildName           *string           `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
+	PackerBuilderType         *string           `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
+	PackerCoreVersion         *string           `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
+	PackerDebug               *bool             `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
+	PackerForce               *bool             `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
+	PackerOnError             *string           `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
+	PackerUserVars            map[string]string `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
+	PackerSensitiveVars       []string          `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
+	ShutdownCommand           *string           `mapstructure:"shutdown_command" required:"false" cty:"shutdown_command" hcl:"shutdown_command"`
+	ShutdownTimeout           *string           `mapstructure:"shutdown_timeout" required:"false" cty:"shutdown_timeout" hcl:"shutdown_timeout"`
+	Type                      *string           `mapstructure:"communicator" cty:"communicator" hcl:"communicator"`
+	PauseBeforeConnect        *string           `mapstructure:"pause_before_connecting" cty:"pause_before_connecting" hcl:"pause_before_connecting"`
+	SSHHost                   *string           `mapstructure:"ssh_host" cty:"ssh_host" hcl:"ssh_host"`
+	SSHPort                   *int              `mapstructure:"ssh_port" cty:"ssh_port" hcl:"ssh_port"`
+	SSHUsername               *string           `mapstructure:"ssh_username" cty:"ssh_username" hcl:"ssh_username"`
+	SSHPassword               *string           `mapstructure:"ssh_password" cty:"ssh_password" hcl:"ssh_password"`
+	SSHKeyPairName            *string           `mapstructure:"ssh_keypair_name" undocumented:"true" cty:"ssh_keypair_name" hcl:"ssh_keypair_name"`
+	SSHTemporaryKeyPairName   *string           `mapstructure:"temporary_key_pair_name" undocumented:"true" cty:"temporary_key_pair_name" hcl:"temporary_key_pair_name"`
+	SSHTemporaryKeyPairType   *string           `mapstructure:"temporary_key_pair_type" cty:"temporary_key_pair_type" hcl:"temporary_key_pair_type"`
+	SSHTemporaryKeyPairBits   *int              `mapstructure:"temporary_key_pair_bits" cty:"temporary_key_pair_bits" hcl:"temporary_key_pair_bits"`
+	SSHCiphers                []string          `mapstructure:"ssh_ciphers" cty:"ssh_ciphers" hcl:"ssh_ciphers"`
+	SSHClearAuthorizedKeys    *bool             `mapstructure:"ssh_clear_authorized_keys" cty:"ssh_clear_authorized_keys" hcl:"ssh_clear_authorized_keys"`
+	SSHKEXAlgos               []string          `mapstructure:"ssh_key_exchange_algorithms" cty:"ssh_key_exchange_algorithms" hcl:"ssh_key_exchange_algorithms"`
+	SSHPrivateKeyFile         *string           `mapstructure:"ssh_private_key_file" undocumented:"true" cty:"ssh_private_key_file" hcl:"ssh_private_key_file"`
+	SSHCertificateFile        *string           `mapstructure:"ssh_certificate_file" cty:"ssh_certificate_file" hcl:"ssh_certificate_file"`
+	SSHPty                    *bool             `mapstructure:"ssh_pty" cty:"ssh_pty" hcl:"ssh_pty"`
+	SSHTimeout                *string           `mapstructure:"ssh_timeout" cty:"ssh_timeout" hcl:"ssh_timeout"`
+	SSHWaitTimeout            *string           `mapstructure:"ssh_wait_timeout" undocumented:"true" cty:"ssh_wait_timeout" hcl:"ssh_wait_timeout"`
+	SSHAgentAuth              *bool             `mapstructure:"ssh_agent_auth" undocumented:"true" cty:"ssh_agent_auth" hcl:"ssh_agent_auth"`
+	SSHDisableAgentForwarding *bool             `mapstructure:"ssh_disable_agent_forwarding" cty:"ssh_disable_agent_forwarding" hcl:"ssh_disable_agent_forwarding"`
+	SSHHandshakeAttempts      *int              `mapstructure:"ssh_handshake_attempts" cty:"ssh_handshake_attempts" hcl:"ssh_handshake_attempts"`
+	SSHBastionHost            *string           `mapstructure:"ssh_bastion_host" cty:"ssh_bastion_host" hcl:"ssh_bastion_host"`
+	SSHBastionPort            *int              `mapstructure:"ssh_bastion_port" cty:"ssh_bastion_port" hcl:"ssh_bastion_port"`
+	SSHBastionAgentAuth       *bool             `mapstructure:"ssh_bastion_agent_auth" cty:"ssh_bastion_agent_auth" hcl:"ssh_bastion_agent_auth"`
+	SSHBastionUsername        *string           `mapstructure:"ssh_bastion_username" cty:"ssh_bastion_username" hcl:"ssh_bastion_username"`
+	SSHBastionPassword        *string           `mapstructure:"ssh_bastion_password" cty:"ssh_bastion_password" hcl:"ssh_bastion_password"`
+	SSHBastionInteractive     *bool             `mapstructure:"ssh_bastion_interactive" cty:"ssh_bastion_interactive" hcl:"ssh_bastion_interactive"`
+	SSHBastionPrivateKeyFile  *string           `mapstructure:"ssh_bastion_private_key_file" cty:"ssh_bastion_private_key_file" hcl:"ssh_bastion_private_key_file"`
+	SSHBastionCertificateFile *string           `mapstructure:"ssh_bastion_certificate_file" cty:"ssh_bastion_certificate_file" hcl:"ssh_bastion_certificate_file"`
+	SSHFileTransferMethod     *string           `mapstructure:"ssh_file_transfer_method" cty:"ssh_file_transfer_method" hcl:"ssh_file_transfer_method"`
+	SSHProxyHost              *string           `mapstructure:"ssh_proxy_host" cty:"ssh_proxy_host" hcl:"ssh_proxy_host"`
+	SSHProxyPort              *int              `mapstructure:"ssh_proxy_port" cty:"ssh_proxy_port" hcl:"ssh_proxy_port"`
+	SSHProxyUsername          *string           `mapstructure:"ssh_proxy_username" cty:"ssh_proxy_username" hcl:"ssh_proxy_username"`
+	SSHProxyPassword          *string           `mapstructure:"ssh_proxy_password" cty:"ssh_proxy_password" hcl:"ssh_proxy_password"`
+	SSHKeepAliveInterval      *string           `mapstructure:"ssh_keep_alive_interval" cty:"ssh_keep_alive_interval" hcl:"ssh_keep_alive_interval"`
+	SSHReadWriteTimeout       *string           `mapstructure:"ssh_read_write_timeout" cty:"ssh_read_write_timeout" hcl:"ssh_read_write_timeout"`
+	SSHRemoteTunnels          []string          `mapstructure:"ssh_remote_tunnels" cty:"ssh_remote_tunnels" hcl:"ssh_remote_tunnels"`
+	SSHLocalTunnels           []string          `mapstructure:"ssh_local_tunnels" cty:"ssh_local_tunnels" hcl:"ssh_local_tunnels"`
+	SSHPublicKey              []byte            `mapstructure:"ssh_public_key" undocumented:"true" cty:"ssh_public_key" hcl:"ssh_public_key"`
+	SSHPrivateKey             []byte            `mapstructure:"ssh_private_key" undocumented:"true" cty:"ssh_private_key" hcl:"ssh_private_key"`
+	WinRMUser                 *string           `mapstructure:"winrm_username" cty:"winrm_username" hcl:"winrm_username"`
+	WinRMPassword             *string           `mapstructure:"winrm_password" cty:"winrm_password" hcl:"winrm_password"`
+	WinRMHost                 *string           `mapstructure:"winrm_host" cty:"winrm_host" hcl:"winrm_host"`
+	WinRMNoProxy              *bool             `mapstructure:"winrm_no_proxy" cty:"winrm_no_proxy" hcl:"winrm_no_proxy"`
+	WinRMPort                 *int              `mapstructure:"winrm_port" cty:"winrm_port" hcl:"winrm_port"`
+	WinRMTimeout              *string           `mapstructure:"winrm_timeout" cty:"winrm_timeout" hcl:"winrm_timeout"`
+	WinRMUseSSL               *bool             `mapstructure:"winrm_use_ssl" cty:"winrm_use_ssl" hcl:"winrm_use_ssl"`
+	WinRMInsecure             *bool             `mapstructure:"winrm_insecure" cty:"winrm_insecure" hcl:"winrm_insecure"`
+	WinRMUseNTLM              *bool             `mapstructure:"winrm_use_ntlm" cty:"winrm_use_ntlm" hcl:"winrm_use_ntlm"`
+	KubeConfig                *string           `mapstructure:"kube_config" required:"false" cty:"kube_config" hcl:"kube_config"`
+	KubeContext               *string           `mapstructure:"kube_context" required:"false" cty:"kube_context" hcl:"kube_context"`
+	KubeImpersonateUser       *string           `mapstructure:"kube_impersonate_user" required:"false" cty:"kube_impersonate_user" hcl:"kube_impersonate_user"`
+	KubeImpersonateGroups     []string          `mapstructure:"kube_impersonate_groups" required:"false" cty:"kube_impersonate_groups" hcl:"kube_impersonate_groups"`
+	Name                      *string           `mapstructure:"name" required:"true" cty:"name" hcl:"name"`
+	Namespace                 *string           `mapstructure:"namespace" required:"true" cty:"namespace" hcl:"namespace"`
+	IsoVolumeName             *string           `mapstructure:"iso_volume_name" required:"true" cty:"iso_volume_name" hcl:"iso_volume_name"`
+	DiskSize                  *string           `mapstructure:"disk_size" required:"true" cty:"disk_size" hcl:"disk_size"`
+	StorageClassName          *string           `mapstructure:"storage_class_name" required:"false" cty:"storage_class_name" hcl:"storage_class_name"`
+	OutputNamespace           *string           `mapstructure:"output_namespace" required:"false" cty:"output_namespace" hcl:"output_namespace"`
+	OutputType                *string           `mapstructure:"output_type" required:"false" cty:"output_type" hcl:"output_type"`
+	VolumeSnapshotClass       *string           `mapstructure:"volume_snapshot_class" required:"false" cty:"volume_snapshot_class" hcl:"volume_snapshot_class"`
+	InstanceType              *string           `mapstructure:"instance_type" required:"true" cty:"instance_type" hcl:"instance_type"`
+	InstanceTypeKind          *string           `mapstructure:"instance_type_kind" required:"false" cty:"instance_type_kind" hcl:"instance_type_kind"`
+	Preference                *string           `mapstructure:"preference" required:"true" cty:"preference" hcl:"preference"`
+	PreferenceKind            *string           `mapstructure:"preference_kind" required:"false" cty:"preference_kind" hcl:"preference_kind"`
+	OperatingSystemType       *string           `mapstructure:"os_type" required:"false" cty:"os_type" hcl:"os_type"`
+	Networks                  []FlatNetwork     `mapstructure:"networks" required:"false" cty:"networks" hcl:"networks"`
+	MediaFiles                []string          `mapstructure:"media_files" required:"false" cty:"media_files" hcl:"media_files"`
+	BootCommand               []string          `mapstructure:"boot_command" required:"false" cty:"boot_command" hcl:"boot_command"`
+	BootWait                  *string           `mapstructure:"boot_wait" required:"false" cty:"boot_wait" hcl:"boot_wait"`
+	InstallationWaitTimeout   *string           `mapstructure:"installation_wait_timeout" required:"true" cty:"installation_wait_timeout" hcl:"installation_wait_timeout"`
+	InstallationCompleteOn    *string           `mapstructure:"installation_complete_on" required:"false" cty:"installation_complete_on" hcl:"installation_complete_on"`
+	ConnectionMode            *string           `mapstructure:"connection_mode" required:"false" cty:"connection_mode" hcl:"connection_mode"`
+	ServiceType               *string           `mapstructure:"service_type" required:"false" cty:"service_type" hcl:"service_type"`
+	ServicePorts              []int             `mapstructure:"service_ports" required:"false" cty:"service_ports" hcl:"service_ports"`
+	SSHLocalPort              *int              `mapstructure:"ssh_local_port" required:"false" cty:"ssh_local_port" hcl:"ssh_local_port"`
+	SSHRemotePort             *int              `mapstructure:"ssh_remote_port" required:"false" cty:"ssh_remote_port" hcl:"ssh_remote_port"`
+	SSHTemporaryKeyPair       *bool             `mapstructure:"ssh_temporary_key_pair" required:"false" cty:"ssh_temporary_key_pair" hcl:"ssh_temporary_key_pair"`
+	SSHPublicKeyInjection     *string           `mapstructure:"ssh_public_key_injection" required:"false" cty:"ssh_public_key_injection" hcl:"ssh_public_key_injection"`
+	SSHHostKeyVerification    *string           `mapstructure:"ssh_host_key_verification" required:"false" cty:"ssh_host_key_verification" hcl:"ssh_host_key_verification"`
+	SSHKnownHostsFile         *string           `mapstructure:"ssh_known_hosts_file" required:"false" cty:"ssh_known_hosts_file" hcl:"ssh_known_hosts_file"`
+	WinRMLocalPort            *int              `mapstructure:"winrm_local_port" required:"false" cty:"winrm_local_port" hcl:"winrm_local_port"`
+	WinRMRemotePort           *int              `mapstructure:"winrm_remote_port" required:"false" cty:"winrm_remote_port" hcl:"winrm_remote_port"`
+	WinRMWaitTimeout          *string           `mapstructure:"winrm_wait_timeout" undocumented:"true" cty:"winrm_wait_timeout" hcl:"winrm_wait_timeout"`
+	StopTimeout               *string           `mapstructure:"stop_timeout" required:"false" cty:"stop_timeout" hcl:"stop_timeout"`
+	KeepVM                    *bool             `mapstructure:"keep_vm" required:"false" cty:"keep_vm" hcl:"keep_vm"`
+	ExportPath                *string           `mapstructure:"export_path" required:"false" cty:"export_path" hcl:"export_path"`
+	ExportFormat              *string           `mapstructure:"export_format" required:"false" cty:"export_format" hcl:"export_format"`
+	ExportTimeout             *string           `mapstructure:"export_timeout" required:"false" cty:"export_timeout" hcl:"export_timeout"`
+	ExportConvertFormats      []string          `mapstructure:"export_convert_formats" required:"false" cty:"export_convert_formats" hcl:"export_convert_formats"`
+	ContainerDiskImage        *string           `mapstructure:"container_disk_image" required:"false" cty:"container_disk_image" hcl:"container_disk_image"`
+	ContainerDiskUsername     *string           `mapstructure:"container_disk_username" required:"false" cty:"container_disk_username" hcl:"container_disk_username"`
+	ContainerDiskPassword     *string           `mapstructure:"container_disk_password" required:"false" cty:"container_disk_password" hcl:"container_disk_password"`
+	ContainerDiskInsecure     *bool             `mapstructure:"container_disk_insecure" required:"false" cty:"container_disk_insecure" hcl:"container_disk_insecure"`
+	ContainerDiskArchitecture *string           `mapstructure:"container_disk_architecture" required:"false" cty:"container_disk_architecture" hcl:"container_disk_architecture"`
 }
 
 // FlatMapstructure returns a new FlatConfig.
@@ -62,44 +129,111 @@ func (*Config) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec }
 // The decoded values from this spec will then be applied to a FlatConfig.
 func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 	s := map[string]hcldec.Spec{
-		"packer_build_name":          &hcldec.AttrSpec{Name: "packer_build_name", Type: cty.String, Required: false},
-		"packer_builder_type":        &hcldec.AttrSpec{Name: "packer_builder_type", Type: cty.String, Required: false},
-		"packer_core_version":        &hcldec.AttrSpec{Name: "packer_core_version", Type: cty.String, Required: false},
-		"packer_debug":               &hcldec.AttrSpec{Name: "packer_debug", Type: cty.Bool, Required: false},
-		"packer_force":               &hcldec.AttrSpec{Name: "packer_force", Type: cty.Bool, Required: false},
-		"packer_on_error":            &hcldec.AttrSpec{Name: "packer_on_error", Type: cty.String, Required: false},
-		"packer_user_variables":      &hcldec.AttrSpec{Name: "packer_user_variables", Type: cty.Map(cty.String), Required: false},
-		"packer_sensitive_variables": &hcldec.AttrSpec{Name: "packer_sensitive_variables", Type: cty.List(cty.String), Required: false},
-		"kube_config":                &hcldec.AttrSpec{Name: "kube_config", Type: cty.String, Required: false},
-		"name":                       &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
-		"namespace":                  &hcldec.AttrSpec{Name: "namespace", Type: cty.String, Required: false},
-		"iso_volume_name":            &hcldec.AttrSpec{Name: "iso_volume_name", Type: cty.String, Required: false},
-		"disk_size":                  &hcldec.AttrSpec{Name: "disk_size", Type: cty.String, Required: false},
-		"storage_class_name":         &hcldec.AttrSpec{Name: "storage_class_name", Type: cty.String, Required: false},
-		"instance_type":              &hcldec.AttrSpec{Name: "instance_type", Type: cty.String, Required: false},
-		"instance_type_kind":         &hcldec.AttrSpec{Name: "instance_type_kind", Type: cty.String, Required: false},
-		"preference":                 &hcldec.AttrSpec{Name: "preference", Type: cty.String, Required: false},
-		"preference_kind":            &hcldec.AttrSpec{Name: "preference_kind", Type: cty.String, Required: false},
-		"os_type":                    &hcldec.AttrSpec{Name: "os_type", Type: cty.String, Required: false},
-		"networks":                   &hcldec.BlockListSpec{TypeName: "networks", Nested: hcldec.ObjectSpec((*FlatNetwork)(nil).HCL2Spec())},
-		"media_files":                &hcldec.AttrSpec{Name: "media_files", Type: cty.List(cty.String), Required: false},
-		"boot_command":               &hcldec.AttrSpec{Name: "boot_command", Type: cty.List(cty.String), Required: false},
-		"boot_wait":                  &hcldec.AttrSpec{Name: "boot_wait", Type: cty.String, Required: false},
-		"installation_wait_timeout":  &hcldec.AttrSpec{Name: "installation_wait_timeout", Type: cty.String, Required: false},
-		"communicator":               &hcldec.AttrSpec{Name: "communicator", Type: cty.String, Required: false},
-		"ssh_host":                   &hcldec.AttrSpec{Name: "ssh_host", Type: cty.String, Required: false},
-		"ssh_local_port":             &hcldec.AttrSpec{Name: "ssh_local_port", Type: cty.Number, Required: false},
-		"ssh_remote_port":            &hcldec.AttrSpec{Name: "ssh_remote_port", Type: cty.Number, Required: false},
-		"ssh_username":               &hcldec.AttrSpec{Name: "ssh_username", Type: cty.String, Required: false},
-		"ssh_password":               &hcldec.AttrSpec{Name: "ssh_password", Type: cty.String, Required: false},
-		"ssh_wait_timeout":           &hcldec.AttrSpec{Name: "ssh_wait_timeout", Type: cty.String, Required: false},
-		"winrm_host":                 &hcldec.AttrSpec{Name: "winrm_host", Type: cty.String, Required: false},
-		"winrm_local_port":           &hcldec.AttrSpec{Name: "winrm_local_port", Type: cty.Number, Required: false},
-		"winrm_remote_port":          &hcldec.AttrSpec{Name: "winrm_remote_port", Type: cty.Number, Required: false},
-		"winrm_username":             &hcldec.AttrSpec{Name: "winrm_username", Type: cty.String, Required: false},
-		"winrm_password":             &hcldec.AttrSpec{Name: "winrm_password", Type: cty.String, Required: false},
-		"winrm_wait_timeout":         &hcldec.AttrSpec{Name: "winrm_wait_timeout", Type: cty.String, Required: false},
-		"keep_vm":                    &hcldec.AttrSpec{Name: "keep_vm", Type: cty.Bool, Required: false},
+		"packer_build_name":            &hcldec.AttrSpec{Name: "packer_build_name", Type: cty.String, Required: false},
+		"packer_builder_type":          &hcldec.AttrSpec{Name: "packer_builder_type", Type: cty.String, Required: false},
+		"packer_core_version":          &hcldec.AttrSpec{Name: "packer_core_version", Type: cty.String, Required: false},
+		"packer_debug":                 &hcldec.AttrSpec{Name: "packer_debug", Type: cty.Bool, Required: false},
+		"packer_force":                 &hcldec.AttrSpec{Name: "packer_force", Type: cty.Bool, Required: false},
+		"packer_on_error":              &hcldec.AttrSpec{Name: "packer_on_error", Type: cty.String, Required: false},
+		"packer_user_variables":        &hcldec.AttrSpec{Name: "packer_user_variables", Type: cty.Map(cty.String), Required: false},
+		"packer_sensitive_variables":   &hcldec.AttrSpec{Name: "packer_sensitive_variables", Type: cty.List(cty.String), Required: false},
+		"shutdown_command":             &hcldec.AttrSpec{Name: "shutdown_command", Type: cty.String, Required: false},
+		"shutdown_timeout":             &hcldec.AttrSpec{Name: "shutdown_timeout", Type: cty.String, Required: false},
+		"communicator":                 &hcldec.AttrSpec{Name: "communicator", Type: cty.String, Required: false},
+		"pause_before_connecting":      &hcldec.AttrSpec{Name: "pause_before_connecting", Type: cty.String, Required: false},
+		"ssh_host":                     &hcldec.AttrSpec{Name: "ssh_host", Type: cty.String, Required: false},
+		"ssh_port":                     &hcldec.AttrSpec{Name: "ssh_port", Type: cty.Number, Required: false},
+		"ssh_username":                 &hcldec.AttrSpec{Name: "ssh_username", Type: cty.String, Required: false},
+		"ssh_password":                 &hcldec.AttrSpec{Name: "ssh_password", Type: cty.String, Required: false},
+		"ssh_keypair_name":             &hcldec.AttrSpec{Name: "ssh_keypair_name", Type: cty.String, Required: false},
+		"temporary_key_pair_name":      &hcldec.AttrSpec{Name: "temporary_key_pair_name", Type: cty.String, Required: false},
+		"temporary_key_pair_type":      &hcldec.AttrSpec{Name: "temporary_key_pair_type", Type: cty.String, Required: false},
+		"temporary_key_pair_bits":      &hcldec.AttrSpec{Name: "temporary_key_pair_bits", Type: cty.Number, Required: false},
+		"ssh_ciphers":                  &hcldec.AttrSpec{Name: "ssh_ciphers", Type: cty.List(cty.String), Required: false},
+		"ssh_clear_authorized_keys":    &hcldec.AttrSpec{Name: "ssh_clear_authorized_keys", Type: cty.Bool, Required: false},
+		"ssh_key_exchange_algorithms":  &hcldec.AttrSpec{Name: "ssh_key_exchange_algorithms", Type: cty.List(cty.String), Required: false},
+		"ssh_private_key_file":         &hcldec.AttrSpec{Name: "ssh_private_key_file", Type: cty.String, Required: false},
+		"ssh_certificate_file":         &hcldec.AttrSpec{Name: "ssh_certificate_file", Type: cty.String, Required: false},
+		"ssh_pty":                      &hcldec.AttrSpec{Name: "ssh_pty", Type: cty.Bool, Required: false},
+		"ssh_timeout":                  &hcldec.AttrSpec{Name: "ssh_timeout", Type: cty.String, Required: false},
+		"ssh_wait_timeout":             &hcldec.AttrSpec{Name: "ssh_wait_timeout", Type: cty.String, Required: false},
+		"ssh_agent_auth":               &hcldec.AttrSpec{Name: "ssh_agent_auth", Type: cty.Bool, Required: false},
+		"ssh_disable_agent_forwarding": &hcldec.AttrSpec{Name: "ssh_disable_agent_forwarding", Type: cty.Bool, Required: false},
+		"ssh_handshake_attempts":       &hcldec.AttrSpec{Name: "ssh_handshake_attempts", Type: cty.Number, Required: false},
+		"ssh_bastion_host":             &hcldec.AttrSpec{Name: "ssh_bastion_host", Type: cty.String, Required: false},
+		"ssh_bastion_port":             &hcldec.AttrSpec{Name: "ssh_bastion_port", Type: cty.Number, Required: false},
+		"ssh_bastion_agent_auth":       &hcldec.AttrSpec{Name: "ssh_bastion_agent_auth", Type: cty.Bool, Required: false},
+		"ssh_bastion_username":         &hcldec.AttrSpec{Name: "ssh_bastion_username", Type: cty.String, Required: false},
+		"ssh_bastion_password":         &hcldec.AttrSpec{Name: "ssh_bastion_password", Type: cty.String, Required: false},
+		"ssh_bastion_interactive":      &hcldec.AttrSpec{Name: "ssh_bastion_interactive", Type: cty.Bool, Required: false},
+		"ssh_bastion_private_key_file": &hcldec.AttrSpec{Name: "ssh_bastion_private_key_file", Type: cty.String, Required: false},
+		"ssh_bastion_certificate_file": &hcldec.AttrSpec{Name: "ssh_bastion_certificate_file", Type: cty.String, Required: false},
+		"ssh_file_transfer_method":     &hcldec.AttrSpec{Name: "ssh_file_transfer_method", Type: cty.String, Required: false},
+		"ssh_proxy_host":               &hcldec.AttrSpec{Name: "ssh_proxy_host", Type: cty.String, Required: false},
+		"ssh_proxy_port":               &hcldec.AttrSpec{Name: "ssh_proxy_port", Type: cty.Number, Required: false},
+		"ssh_proxy_username":           &hcldec.AttrSpec{Name: "ssh_proxy_username", Type: cty.String, Required: false},
+		"ssh_proxy_password":           &hcldec.AttrSpec{Name: "ssh_proxy_password", Type: cty.String, Required: false},
+		"ssh_keep_alive_interval":      &hcldec.AttrSpec{Name: "ssh_keep_alive_interval", Type: cty.String, Required: false},
+		"ssh_read_write_timeout":       &hcldec.AttrSpec{Name: "ssh_read_write_timeout", Type: cty.String, Required: false},
+		"ssh_remote_tunnels":           &hcldec.AttrSpec{Name: "ssh_remote_tunnels", Type: cty.List(cty.String), Required: false},
+		"ssh_local_tunnels":            &hcldec.AttrSpec{Name: "ssh_local_tunnels", Type: cty.List(cty.String), Required: false},
+		"ssh_public_key":               &hcldec.AttrSpec{Name: "ssh_public_key", Type: cty.List(cty.Number), Required: false},
+		"ssh_private_key":              &hcldec.AttrSpec{Name: "ssh_private_key", Type: cty.List(cty.Number), Required: false},
+		"winrm_username":               &hcldec.AttrSpec{Name: "winrm_username", Type: cty.String, Required: false},
+		"winrm_password":               &hcldec.AttrSpec{Name: "winrm_password", Type: cty.String, Required: false},
+		"winrm_host":                   &hcldec.AttrSpec{Name: "winrm_host", Type: cty.String, Required: false},
+		"winrm_no_proxy":               &hcldec.AttrSpec{Name: "winrm_no_proxy", Type: cty.Bool, Required: false},
+		"winrm_port":                   &hcldec.AttrSpec{Name: "winrm_port", Type: cty.Number, Required: false},
+		"winrm_timeout":                &hcldec.AttrSpec{Name: "winrm_timeout", Type: cty.String, Required: false},
+		"winrm_use_ssl":                &hcldec.AttrSpec{Name: "winrm_use_ssl", Type: cty.Bool, Required: false},
+		"winrm_insecure":               &hcldec.AttrSpec{Name: "winrm_insecure", Type: cty.Bool, Required: false},
+		"winrm_use_ntlm":               &hcldec.AttrSpec{Name: "winrm_use_ntlm", Type: cty.Bool, Required: false},
+		"kube_config":                  &hcldec.AttrSpec{Name: "kube_config", Type: cty.String, Required: false},
+		"kube_context":                 &hcldec.AttrSpec{Name: "kube_context", Type: cty.String, Required: false},
+		"kube_impersonate_user":        &hcldec.AttrSpec{Name: "kube_impersonate_user", Type: cty.String, Required: false},
+		"kube_impersonate_groups":      &hcldec.AttrSpec{Name: "kube_impersonate_groups", Type: cty.List(cty.String), Required: false},
+		"name":                         &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
+		"namespace":                    &hcldec.AttrSpec{Name: "namespace", Type: cty.String, Required: false},
+		"iso_volume_name":              &hcldec.AttrSpec{Name: "iso_volume_name", Type: cty.String, Required: false},
+		"disk_size":                    &hcldec.AttrSpec{Name: "disk_size", Type: cty.String, Required: false},
+		"storage_class_name":           &hcldec.AttrSpec{Name: "storage_class_name", Type: cty.String, Required: false},
+		"output_namespace":             &hcldec.AttrSpec{Name: "output_namespace", Type: cty.String, Required: false},
+		"output_type":                  &hcldec.AttrSpec{Name: "output_type", Type: cty.String, Required: false},
+		"volume_snapshot_class":        &hcldec.AttrSpec{Name: "volume_snapshot_class", Type: cty.String, Required: false},
+		"instance_type":                &hcldec.AttrSpec{Name: "instance_type", Type: cty.String, Required: false},
+		"instance_type_kind":           &hcldec.AttrSpec{Name: "instance_type_kind", Type: cty.String, Required: false},
+		"preference":                   &hcldec.AttrSpec{Name: "preference", Type: cty.String, Required: false},
+		"preference_kind":              &hcldec.AttrSpec{Name: "preference_kind", Type: cty.String, Required: false},
+		"os_type":                      &hcldec.AttrSpec{Name: "os_type", Type: cty.String, Required: false},
+		"networks":                     &hcldec.BlockListSpec{TypeName: "networks", Nested: hcldec.ObjectSpec((*FlatNetwork)(nil).HCL2Spec())},
+		"media_files":                  &hcldec.AttrSpec{Name: "media_files", Type: cty.List(cty.String), Required: false},
+		"boot_command":                 &hcldec.AttrSpec{Name: "boot_command", Type: cty.List(cty.String), Required: false},
+		"boot_wait":                    &hcldec.AttrSpec{Name: "boot_wait", Type: cty.String, Required: false},
+		"installation_wait_timeout":    &hcldec.AttrSpec{Name: "installation_wait_timeout", Type: cty.String, Required: false},
+		"installation_complete_on":     &hcldec.AttrSpec{Name: "installation_complete_on", Type: cty.String, Required: false},
+		"connection_mode":              &hcldec.AttrSpec{Name: "connection_mode", Type: cty.String, Required: false},
+		"service_type":                 &hcldec.AttrSpec{Name: "service_type", Type: cty.String, Required: false},
+		"service_ports":                &hcldec.AttrSpec{Name: "service_ports", Type: cty.List(cty.Number), Required: false},
+		"ssh_local_port":               &hcldec.AttrSpec{Name: "ssh_local_port", Type: cty.Number, Required: false},
+		"ssh_remote_port":              &hcldec.AttrSpec{Name: "ssh_remote_port", Type: cty.Number, Required: false},
+		"ssh_temporary_key_pair":       &hcldec.AttrSpec{Name: "ssh_temporary_key_pair", Type: cty.Bool, Required: false},
+		"ssh_public_key_injection":     &hcldec.AttrSpec{Name: "ssh_public_key_injection", Type: cty.String, Required: false},
+		"ssh_host_key_verification":    &hcldec.AttrSpec{Name: "ssh_host_key_verification", Type: cty.String, Required: false},
+		"ssh_known_hosts_file":         &hcldec.AttrSpec{Name: "ssh_known_hosts_file", Type: cty.String, Required: false},
+		"winrm_local_port":             &hcldec.AttrSpec{Name: "winrm_local_port", Type: cty.Number, Required: false},
+		"winrm_remote_port":            &hcldec.AttrSpec{Name: "winrm_remote_port", Type: cty.Number, Required: false},
+		"winrm_wait_timeout":           &hcldec.AttrSpec{Name: "winrm_wait_timeout", Type: cty.String, Required: false},
+		"stop_timeout":                 &hcldec.AttrSpec{Name: "stop_timeout", Type: cty.String, Required: false},
+		"keep_vm":                      &hcldec.AttrSpec{Name: "keep_vm", Type: cty.Bool, Required: false},
+		"export_path":                  &hcldec.AttrSpec{Name: "export_path", Type: cty.String, Required: false},
+		"export_format":                &hcldec.AttrSpec{Name: "export_format", Type: cty.String, Required: false},
+		"export_timeout":               &hcldec.AttrSpec{Name: "export_timeout", Type: cty.String, Required: false},
+		"export_convert_formats":       &hcldec.AttrSpec{Name: "export_convert_formats", Type: cty.List(cty.String), Required: false},
+		"container_disk_image":         &hcldec.AttrSpec{Name: "container_disk_image", Type: cty.String, Required: false},
+		"container_disk_username":      &hcldec.AttrSpec{Name: "container_disk_username", Type: cty.String, Required: false},
+		"container_disk_password":      &hcldec.AttrSpec{Name: "container_disk_password", Type: cty.String, Required: false},
+		"container_disk_insecure":      &hcldec.AttrSpec{Name: "container_disk_insecure", Type: cty.Bool, Required: false},
+		"container_disk_architecture":  &hcldec.AttrSpec{Name: "container_disk_architecture", Type: cty.String, Required: false},
 	}
 	return s
 }
@@ -0,0 +1,619 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso_test
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+)
+
+var _ = Describe("Config", func() {
+	var raw map[string]interface{}
+
+	BeforeEach(func() {
+		raw = map[string]interface{}{
+			"kube_config":               "/tmp/kubeconfig",
+			"name":                      "fedora",
+			"namespace":                 "images",
+			"iso_volume_name":           "fedora-iso",
+			"disk_size":                 "10Gi",
+			"instance_type":             "o1.medium",
+			"preference":                "fedora",
+			"installation_wait_timeout": "15m",
+		}
+	})
+
+	prepareErrors := func() []error {
+		var c iso.Config
+		_, err := c.Prepare(raw)
+		Expect(err).To(HaveOccurred())
+		multiErr, ok := err.(*packer.MultiError)
+		Expect(ok).To(BeTrue())
+		return multiErr.Errors
+	}
+
+	Context("Prepare", func() {
+		It("sets the defaults for a minimal configuration", func() {
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.OperatingSystemType).To(Equal("linux"))
+			Expect(c.InstallationCompleteOn).To(Equal("timer"))
+			Expect(c.StopTimeout).To(Equal(5 * time.Minute))
+			Expect(c.Comm.Type).To(Equal("none"))
+		})
+
+		It("interpolates the user variables in the shutdown command", func() {
+			raw["packer_user_variables"] = map[string]string{"password": "secret"}
+			raw["communicator"] = "ssh"
+			raw["ssh_username"] = "user"
+			raw["ssh_password"] = "{{user `password`}}"
+			raw["shutdown_command"] = "echo '{{user `password`}}' | sudo -S shutdown -P now"
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.ShutdownCommand).To(Equal("echo 'secret' | sudo -S shutdown -P now"))
+			Expect(c.Comm.SSHPassword).To(Equal("secret"))
+		})
+
+		It("sets the SSH defaults", func() {
+			raw["communicator"] = "ssh"
+			raw["ssh_username"] = "user"
+			raw["ssh_password"] = "password"
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.Comm.SSHHost).To(Equal("127.0.0.1"))
+			Expect(c.SSHRemotePort).To(Equal(22))
+			Expect(c.Comm.SSHTimeout).To(Equal(5 * time.Minute))
+		})
+
+		It("accepts the standard SSH communicator options", func() {
+			raw["communicator"] = "ssh"
+			raw["ssh_username"] = "user"
+			raw["ssh_agent_auth"] = true
+			raw["ssh_port"] = 2222
+			raw["ssh_pty"] = true
+			raw["ssh_handshake_attempts"] = 20
+			raw["ssh_keep_alive_interval"] = "10s"
+			raw["ssh_file_transfer_method"] = "sftp"
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.SSHRemotePort).To(Equal(2222))
+			Expect(c.Comm.SSHPty).To(BeTrue())
+			Expect(c.Comm.SSHHandshakeAttempts).To(Equal(20))
+			Expect(c.Comm.SSHKeepAliveInterval).To(Equal(10 * time.Second))
+			Expect(c.Comm.SSHFileTransferMethod).To(Equal("sftp"))
+		})
+
+		It("validates the standard SSH communicator options", func() {
+			raw["communicator"] = "ssh"
+			raw["ssh_username"] = "user"
+			raw["ssh_password"] = "password"
+			raw["ssh_file_transfer_method"] = "rsync"
+			errs := prepareErrors()
+			Expect(errs).To(ConsistOf(
+				MatchError(ContainSubstring("ssh_file_transfer_method ('rsync') is invalid")),
+			))
+		})
+
+		It("keeps ssh_wait_timeout as an alias of ssh_timeout", func() {
+			raw["communicator"] = "ssh"
+			raw["ssh_username"] = "user"
+			raw["ssh_password"] = "password"
+			raw["ssh_wait_timeout"] = "20m"
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.Comm.SSHTimeout).To(Equal(20 * time.Minute))
+		})
+
+		It("sets the WinRM defaults", func() {
+			raw["communicator"] = "winrm"
+			raw["os_type"] = "windows"
+			raw["winrm_username"] = "Administrator"
+			raw["winrm_password"] = "password"
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.Comm.WinRMHost).To(Equal("127.0.0.1"))
+			Expect(c.WinRMRemotePort).To(Equal(5985))
+			Expect(c.Comm.WinRMTimeout).To(Equal(30 * time.Minute))
+		})
+
+		It("accepts the standard WinRM communicator options", func() {
+			raw["communicator"] = "winrm"
+			raw["os_type"] = "windows"
+			raw["winrm_username"] = "Administrator"
+			raw["winrm_password"] = "password"
+			raw["winrm_use_ssl"] = true
+			raw["winrm_insecure"] = true
+			raw["winrm_use_ntlm"] = true
+			raw["winrm_wait_timeout"] = "1h"
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.WinRMRemotePort).To(Equal(5986))
+			Expect(c.Comm.WinRMInsecure).To(BeTrue())
+			Expect(c.Comm.WinRMTransportDecorator).NotTo(BeNil())
+			Expect(c.Comm.WinRMTimeout).To(Equal(time.Hour))
+		})
+
+		It("rejects the communicators of other builders", func() {
+			raw["communicator"] = "docker"
+			errs := prepareErrors()
+			Expect(errs).To(ConsistOf(
+				MatchError(`communicator "docker" is not supported, set 'none', 'ssh' or 'winrm'`),
+			))
+		})
+
+		It("aggregates all missing required fields", func() {
+			raw = map[string]interface{}{}
+			errs := prepareErrors()
+			Expect(errs).To(ContainElements(
+				MatchError("name must be specified"),
+				MatchError("namespace must be specified"),
+				MatchError("iso_volume_name must be specified"),
+				MatchError("disk_size must be specified"),
+				MatchError("instance_type must be specified"),
+				MatchError("preference must be specified"),
+				MatchError(ContainSubstring("installation_wait_timeout must be specified")),
+			))
+		})
+
+		It("does not require kube_config", func() {
+			delete(raw, "kube_config")
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("requires kube_impersonate_user with kube_impersonate_groups", func() {
+			raw["kube_impersonate_groups"] = []string{"system:masters"}
+			errs := prepareErrors()
+			Expect(errs).To(ContainElement(MatchError("kube_impersonate_groups requires kube_impersonate_user")))
+		})
+
+		It("does not require installation_wait_timeout without the timer", func() {
+			delete(raw, "installation_wait_timeout")
+			raw["installation_complete_on"] = "shutdown"
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("rejects names that are not valid DNS-1123 names", func() {
+			raw["name"] = "Fedora_42"
+			raw["namespace"] = "images.prod"
+			raw["storage_class_name"] = "-ssd"
+			errs := prepareErrors()
+			Expect(errs).To(ContainElements(
+				MatchError(ContainSubstring(`name "Fedora_42" is invalid`)),
+				MatchError(ContainSubstring(`namespace "images.prod" is invalid`)),
+				MatchError(ContainSubstring(`storage_class_name "-ssd" is invalid`)),
+			))
+		})
+
+		It("rejects names that cannot name the temporary resources of the build", func() {
+			for _, name := range []string{"fedora.42", "42-fedora", strings.Repeat("f", 51)} {
+				raw["name"] = name
+				errs := prepareErrors()
+				Expect(errs).To(ContainElement(MatchError(ContainSubstring(fmt.Sprintf("name %q is invalid", name)))), name)
+			}
+		})
+
+		It("accepts a name of 50 characters", func() {
+			raw["name"] = strings.Repeat("f", 50)
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("rejects an invalid disk_size", func() {
+			raw["disk_size"] = "ten gigs"
+			errs := prepareErrors()
+			Expect(errs).To(ContainElement(MatchError(ContainSubstring(`disk_size "ten gigs" is not a valid quantity`))))
+		})
+
+		It("rejects a zero disk_size", func() {
+			raw["disk_size"] = "0"
+			errs := prepareErrors()
+			Expect(errs).To(ContainElement(MatchError(ContainSubstring("must be greater than zero"))))
+		})
+
+		It("rejects unsupported enum values", func() {
+			raw["os_type"] = "bsd"
+			raw["instance_type_kind"] = "instancetype"
+			raw["preference_kind"] = "preference"
+			raw["installation_complete_on"] = "reboot"
+			raw["communicator"] = "telnet"
+			raw["connection_mode"] = "tunnel"
+			errs := prepareErrors()
+			Expect(errs).To(ContainElements(
+				MatchError(ContainSubstring(`os_type "bsd" is not supported`)),
+				MatchError(ContainSubstring(`instance_type_kind "instancetype" is not supported`)),
+				MatchError(ContainSubstring(`preference_kind "preference" is not supported`)),
+				MatchError(ContainSubstring(`installation_complete_on "reboot" is not supported`)),
+				MatchError(ContainSubstring(`communicator "telnet" is not supported`)),
+				MatchError(ContainSubstring(`connection_mode "tunnel" is not supported`)),
+			))
+		})
+
+		It("accepts namespaced instance type and preference kinds", func() {
+			raw["instance_type_kind"] = "virtualmachineinstancetype"
+			raw["preference_kind"] = "virtualmachinepreference"
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("requires the SSH credentials and ports", func() {
+			raw["communicator"] = "ssh"
+			raw["ssh_local_port"] = -1
+			raw["ssh_remote_port"] = 70000
+			errs := prepareErrors()
+			Expect(errs).To(ContainElements(
+				MatchError(ContainSubstring("ssh_username must be specified")),
+				MatchError("one of ssh_password, ssh_private_key_file, ssh_temporary_key_pair or ssh_agent_auth must be specified"),
+				MatchError(ContainSubstring("ssh_local_port must be between 1 and 65535")),
+				MatchError(ContainSubstring("ssh_remote_port must be between 1 and 65535")),
+			))
+		})
+
+		It("accepts a temporary key pair injected through the access credentials", func() {
+			raw["communicator"] = "ssh"
+			raw["ssh_username"] = "user"
+			raw["ssh_temporary_key_pair"] = true
+			raw["ssh_public_key_injection"] = "access_credentials"
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("rejects invalid SSH key options", func() {
+			raw["communicator"] = "ssh"
+			raw["ssh_username"] = "user"
+			raw["ssh_private_key_file"] = "/nonexistent/id_ed25519"
+			raw["ssh_temporary_key_pair"] = true
+			raw["temporary_key_pair_type"] = "ed448"
+			raw["ssh_public_key_injection"] = "cloud_init"
+			errs := prepareErrors()
+			Expect(errs).To(ConsistOf(
+				MatchError("only one of ssh_private_key_file or ssh_temporary_key_pair can be specified"),
+				MatchError(ContainSubstring("ssh_private_key_file is invalid")),
+				MatchError(ContainSubstring(`temporary_key_pair_type "ed448" is not supported`)),
+				MatchError(ContainSubstring(`ssh_public_key_injection "cloud_init" is not supported`)),
+			))
+		})
+
+		It("requires a key pair to inject the public key", func() {
+			raw["communicator"] = "ssh"
+			raw["ssh_username"] = "user"
+			raw["ssh_password"] = "password"
+			raw["ssh_public_key_injection"] = "media_files"
+			errs := prepareErrors()
+			Expect(errs).To(ConsistOf(
+				MatchError("ssh_public_key_injection 'media_files' requires ssh_private_key_file or ssh_temporary_key_pair"),
+			))
+		})
+
+		It("defaults ssh_host_key_verification to none", func() {
+			raw["communicator"] = "ssh"
+			raw["ssh_username"] = "user"
+			raw["ssh_password"] = "password"
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.SSHHostKeyVerification).To(Equal("none"))
+		})
+
+		It("rejects invalid SSH host key options", func() {
+			raw["communicator"] = "ssh"
+			raw["ssh_username"] = "user"
+			raw["ssh_password"] = "password"
+			raw["ssh_host_key_verification"] = "tofu"
+			raw["ssh_known_hosts_file"] = "/nonexistent/known_hosts"
+			errs := prepareErrors()
+			Expect(errs).To(ConsistOf(
+				MatchError(ContainSubstring(`ssh_host_key_verification "tofu" is not supported`)),
+				MatchError("ssh_known_hosts_file requires ssh_host_key_verification 'known_hosts'"),
+			))
+		})
+
+		It("requires a valid known_hosts file", func() {
+			raw["communicator"] = "ssh"
+			raw["ssh_username"] = "user"
+			raw["ssh_password"] = "password"
+			raw["ssh_host_key_verification"] = "known_hosts"
+			Expect(prepareErrors()).To(ConsistOf(
+				MatchError("ssh_known_hosts_file must be specified when ssh_host_key_verification is 'known_hosts'"),
+			))
+
+			raw["ssh_known_hosts_file"] = "/nonexistent/known_hosts"
+			Expect(prepareErrors()).To(ConsistOf(
+				MatchError(ContainSubstring("ssh_known_hosts_file is invalid")),
+			))
+		})
+
+		It("requires the SSH communicator for host key verification", func() {
+			raw["ssh_host_key_verification"] = "console"
+			errs := prepareErrors()
+			Expect(errs).To(ConsistOf(
+				MatchError("ssh_host_key_verification is set but communicator is not 'ssh'"),
+			))
+		})
+
+		It("requires the WinRM credentials and ports", func() {
+			raw["communicator"] = "winrm"
+			errs := prepareErrors()
+			Expect(errs).To(ContainElements(
+				MatchError(ContainSubstring("winrm_username must be specified")),
+				MatchError("winrm_password must be specified"),
+			))
+		})
+
+		It("defaults connection_mode to port_forward", func() {
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.ConnectionMode).To(Equal("port_forward"))
+		})
+
+		It("requires the SSH communicator for the stream connection mode", func() {
+			raw["connection_mode"] = "stream"
+			errs := prepareErrors()
+			Expect(errs).To(ContainElement(MatchError("connection_mode 'stream' requires the 'ssh' communicator")))
+		})
+
+		It("rejects a bastion or a proxy with the stream connection mode", func() {
+			raw["communicator"] = "ssh"
+			raw["ssh_username"] = "user"
+			raw["ssh_password"] = "password"
+			raw["connection_mode"] = "stream"
+			raw["ssh_bastion_host"] = "bastion.example.com"
+			raw["ssh_proxy_host"] = "proxy.example.com"
+			errs := prepareErrors()
+			Expect(errs).To(ContainElements(
+				MatchError("connection_mode 'stream' does not support ssh_bastion_host, the stream connects to the VM directly"),
+				MatchError("connection_mode 'stream' does not support ssh_proxy_host, the stream connects to the VM directly"),
+			))
+		})
+
+		It("accepts the pod_ip connection mode without networks", func() {
+			raw["communicator"] = "ssh"
+			raw["ssh_username"] = "user"
+			raw["ssh_password"] = "password"
+			raw["connection_mode"] = "pod_ip"
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("requires a pod network for the pod_ip connection mode", func() {
+			raw["connection_mode"] = "pod_ip"
+			raw["networks"] = []map[string]interface{}{
+				{"name": "net1", "multus": map[string]interface{}{"networkName": "multus-01"}},
+			}
+			errs := prepareErrors()
+			Expect(errs).To(ContainElements(
+				MatchError("connection_mode 'pod_ip' requires the 'ssh' or 'winrm' communicator"),
+				MatchError("connection_mode 'pod_ip' requires a pod network"),
+			))
+		})
+
+		It("requires an existing network for the interface connection mode", func() {
+			raw["communicator"] = "winrm"
+			raw["winrm_username"] = "Administrator"
+			raw["winrm_password"] = "password"
+			raw["connection_mode"] = "interface:net2"
+			raw["networks"] = []map[string]interface{}{
+				{"name": "net1", "multus": map[string]interface{}{"networkName": "multus-01"}},
+			}
+			errs := prepareErrors()
+			Expect(errs).To(ConsistOf(
+				MatchError(`connection_mode "interface:net2" does not match any network, known networks are: net1`),
+			))
+		})
+
+		It("defaults service_type to ClusterIP", func() {
+			raw["communicator"] = "ssh"
+			raw["ssh_username"] = "user"
+			raw["ssh_password"] = "password"
+			raw["connection_mode"] = "service"
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.ServiceType).To(Equal("ClusterIP"))
+		})
+
+		It("rejects invalid service options", func() {
+			raw["communicator"] = "ssh"
+			raw["ssh_username"] = "user"
+			raw["ssh_password"] = "password"
+			raw["connection_mode"] = "service"
+			raw["service_type"] = "ExternalName"
+			raw["service_ports"] = []int{0}
+			errs := prepareErrors()
+			Expect(errs).To(ConsistOf(
+				MatchError(ContainSubstring(`service_type "ExternalName" is not supported`)),
+				MatchError(ContainSubstring("service_ports must be between 1 and 65535")),
+			))
+		})
+
+		It("requires the service connection mode for service options", func() {
+			raw["service_type"] = "NodePort"
+			errs := prepareErrors()
+			Expect(errs).To(ContainElement(MatchError("service_type and service_ports require connection_mode 'service'")))
+		})
+
+		It("rejects credentials of another communicator", func() {
+			raw["ssh_username"] = "user"
+			errs := prepareErrors()
+			Expect(errs).To(ContainElement(MatchError("ssh_username is set but communicator is not 'ssh'")))
+		})
+
+		It("rejects a shutdown_command without a communicator", func() {
+			raw["shutdown_command"] = "sudo poweroff"
+			errs := prepareErrors()
+			Expect(errs).To(ContainElement(MatchError(ContainSubstring("shutdown_command requires"))))
+		})
+
+		It("rejects invalid networks", func() {
+			raw["networks"] = []map[string]interface{}{
+				{"name": "default", "pod": map[string]interface{}{}},
+				{"name": "default", "pod": map[string]interface{}{}},
+				{"name": "net1", "pod": map[string]interface{}{}, "multus": map[string]interface{}{"networkName": "multus-01"}},
+				{"name": "net2", "multus": map[string]interface{}{}},
+			}
+			errs := prepareErrors()
+			Expect(errs).To(ContainElements(
+				MatchError(`network "default" is defined more than once`),
+				MatchError(`network "net1": only one of pod or multus can be defined`),
+				MatchError(`network "net2": multus networkName must be specified`),
+			))
+		})
+
+		It("sets the output defaults", func() {
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.OutputType).To(Equal("pvc"))
+			Expect(c.OutputNamespace).To(Equal(c.Namespace))
+		})
+
+		It("accepts an output namespace", func() {
+			raw["output_namespace"] = "golden-images"
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.OutputNamespace).To(Equal("golden-images"))
+		})
+
+		It("rejects an output namespace for a snapshot output", func() {
+			raw["output_namespace"] = "Golden_Images"
+			raw["output_type"] = "snapshot"
+			errs := prepareErrors()
+			Expect(errs).To(ConsistOf(
+				MatchError(ContainSubstring(`output_namespace "Golden_Images" is invalid`)),
+				MatchError("output_namespace requires output_type 'pvc', the snapshot is taken in the build namespace"),
+			))
+		})
+
+		It("accepts a snapshot output with a VolumeSnapshotClass", func() {
+			raw["output_type"] = "snapshot"
+			raw["volume_snapshot_class"] = "csi-snapclass"
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("rejects invalid output options", func() {
+			raw["output_type"] = "image"
+			errs := prepareErrors()
+			Expect(errs).To(ConsistOf(
+				MatchError(`output_type "image" is not supported, set 'pvc' or 'snapshot'`),
+			))
+		})
+
+		It("requires a snapshot output for volume_snapshot_class", func() {
+			raw["volume_snapshot_class"] = "csi-snapclass"
+			errs := prepareErrors()
+			Expect(errs).To(ConsistOf(
+				MatchError("volume_snapshot_class requires output_type 'snapshot'"),
+			))
+		})
+
+		It("sets the export defaults", func() {
+			raw["export_path"] = "output/fedora.img"
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.ExportFormat).To(Equal("raw"))
+			Expect(c.ExportTimeout).To(Equal(10 * time.Minute))
+		})
+
+		It("rejects invalid export options", func() {
+			raw["export_path"] = GinkgoT().TempDir()
+			raw["export_format"] = "qcow2"
+			errs := prepareErrors()
+			Expect(errs).To(ConsistOf(
+				MatchError(`export_format "qcow2" is not supported, set 'raw' or 'gzip'`),
+				MatchError(ContainSubstring("is a directory")),
+			))
+		})
+
+		It("sets the containerDisk defaults", func() {
+			raw["export_path"] = "output/fedora.img"
+			raw["container_disk_image"] = "registry.example.com/images/fedora:42"
+
+			var c iso.Config
+			_, err := c.Prepare(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.ContainerDiskArchitecture).To(Equal("amd64"))
+		})
+
+		It("rejects invalid containerDisk options", func() {
+			raw["container_disk_image"] = "Registry.example.com/images/fedora:latest:42"
+			raw["container_disk_username"] = "builder"
+			errs := prepareErrors()
+			Expect(errs).To(ConsistOf(
+				MatchError(ContainSubstring("is not a valid image reference")),
+				MatchError("container_disk_image requires export_path"),
+				MatchError("container_disk_username and container_disk_password must be specified together"),
+			))
+		})
+
+		It("requires container_disk_image for the containerDisk options", func() {
+			raw["container_disk_insecure"] = true
+			errs := prepareErrors()
+			Expect(errs).To(ConsistOf(
+				MatchError("container_disk_username, container_disk_password, container_disk_insecure and container_disk_architecture require container_disk_image"),
+			))
+		})
+
+		It("requires export_path for the export options", func() {
+			raw["export_format"] = "gzip"
+			errs := prepareErrors()
+			Expect(errs).To(ConsistOf(
+				MatchError("export_format, export_timeout and export_convert_formats require export_path"),
+			))
+		})
+
+		It("rejects invalid conversion formats", func() {
+			raw["export_path"] = "output/fedora.qcow2"
+			raw["export_convert_formats"] = []string{"vmdk", "vdi", "vmdk", "qcow2"}
+			errs := prepareErrors()
+			Expect(errs).To(ConsistOf(
+				MatchError(`export_convert_formats: format "vdi" is not supported, set one of qcow2, vmdk, vhdx`),
+				MatchError(`export_convert_formats: format "vmdk" is set more than once`),
+				MatchError(`export_convert_formats: the qcow2 image would overwrite export_path "output/fedora.qcow2"`),
+			))
+		})
+	})
+})
@@ -0,0 +1,91 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ReadKnownHostsKeys returns the host keys of a known_hosts file. The host names of the
+// entries are ignored, since the address of the guest is only known during the build.
+func ReadKnownHostsKeys(path string) ([]ssh.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(data)) > 0 {
+		var key ssh.PublicKey
+		_, _, key, _, data, err = ssh.ParseKnownHosts(data)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no host keys found in %s", path)
+	}
+	return keys, nil
+}
+
+// ParseConsoleHostKeys returns the host keys printed by cloud-init to the serial console,
+// between the "BEGIN SSH HOST KEY KEYS" and "END SSH HOST KEY KEYS" markers.
+func ParseConsoleHostKeys(log string) []ssh.PublicKey {
+	var keys []ssh.PublicKey
+	inBlock := false
+
+	scanner := bufio.NewScanner(strings.NewReader(log))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.Contains(line, "-----BEGIN SSH HOST KEY KEYS-----"):
+			inBlock = true
+		case strings.Contains(line, "-----END SSH HOST KEY KEYS-----"):
+			inBlock = false
+		case inBlock:
+			// Console lines can carry a prefix, such as a timestamp, before the key type.
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line[keyTypeIndex(line):]))
+			if err == nil {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// keyTypeIndex returns the index of the SSH key type in a console line, or 0 when there is none.
+func keyTypeIndex(line string) int {
+	for _, prefix := range []string{"ssh-", "ecdsa-", "sk-"} {
+		if i := strings.Index(line, prefix); i >= 0 {
+			return i
+		}
+	}
+	return 0
+}
+
+// PinnedHostKeyCallback returns an SSH host key callback that only accepts the given keys.
+func PinnedHostKeyCallback(keys []ssh.PublicKey) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		for _, pinned := range keys {
+			if bytes.Equal(pinned.Marshal(), key.Marshal()) {
+				return nil
+			}
+		}
+		return fmt.Errorf("host key %s of %s does not match the pinned host keys", ssh.FingerprintSHA256(key), hostname)
+	}
+}
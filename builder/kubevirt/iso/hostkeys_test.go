@@ -0,0 +1,92 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/packer-plugin-sdk/communicator/sshkey"
+	"golang.org/x/crypto/ssh"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+)
+
+var _ = Describe("SSH host keys", func() {
+	var (
+		hostKey      ssh.PublicKey
+		hostKeyLine  string
+		otherHostKey ssh.PublicKey
+	)
+
+	newHostKey := func() (ssh.PublicKey, string) {
+		pair, err := sshkey.GeneratePair(sshkey.ED25519, nil, 0)
+		Expect(err).NotTo(HaveOccurred())
+		key, _, _, _, err := ssh.ParseAuthorizedKey(pair.Public)
+		Expect(err).NotTo(HaveOccurred())
+		return key, string(ssh.MarshalAuthorizedKey(key))
+	}
+
+	BeforeEach(func() {
+		hostKey, hostKeyLine = newHostKey()
+		otherHostKey, _ = newHostKey()
+	})
+
+	Context("ReadKnownHostsKeys", func() {
+		It("returns the keys of all entries, whatever the host names", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "known_hosts")
+			content := fmt.Sprintf("# build hosts\nfedora.example.com %s[10.0.0.1]:2222 %s",
+				hostKeyLine, ssh.MarshalAuthorizedKey(otherHostKey))
+			Expect(os.WriteFile(path, []byte(content), 0600)).To(Succeed())
+
+			keys, err := iso.ReadKnownHostsKeys(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(HaveLen(2))
+			Expect(keys[0].Marshal()).To(Equal(hostKey.Marshal()))
+			Expect(keys[1].Marshal()).To(Equal(otherHostKey.Marshal()))
+		})
+
+		It("fails when the file has no host keys", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "known_hosts")
+			Expect(os.WriteFile(path, []byte("# empty\n"), 0600)).To(Succeed())
+
+			_, err := iso.ReadKnownHostsKeys(path)
+			Expect(err).To(MatchError(ContainSubstring("no host keys found")))
+		})
+	})
+
+	Context("ParseConsoleHostKeys", func() {
+		It("returns the keys between the cloud-init markers", func() {
+			log := "[   12.345678] cloud-init[812]: Generating public/private ed25519 key pair.\n" +
+				"<14>Oct 18 10:00:00 cloud-init: #############################################################\n" +
+				"-----BEGIN SSH HOST KEY KEYS-----\n" +
+				hostKeyLine +
+				"-----END SSH HOST KEY KEYS-----\n" +
+				string(ssh.MarshalAuthorizedKey(otherHostKey))
+
+			keys := iso.ParseConsoleHostKeys(log)
+			Expect(keys).To(HaveLen(1))
+			Expect(keys[0].Marshal()).To(Equal(hostKey.Marshal()))
+		})
+
+		It("returns no keys without the markers", func() {
+			Expect(iso.ParseConsoleHostKeys(hostKeyLine)).To(BeEmpty())
+		})
+	})
+
+	Context("PinnedHostKeyCallback", func() {
+		It("accepts only the pinned keys", func() {
+			callback := iso.PinnedHostKeyCallback([]ssh.PublicKey{hostKey})
+			remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+			Expect(callback("10.0.0.1:22", remote, hostKey)).To(Succeed())
+			Expect(callback("10.0.0.1:22", remote, otherHostKey)).To(MatchError(ContainSubstring("does not match the pinned host keys")))
+		})
+	})
+})
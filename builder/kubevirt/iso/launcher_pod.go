@@ -0,0 +1,62 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso
+
+import (
+	"context"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	v1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+)
+
+// RunningLauncherPod returns the running virt-launcher pod of the VirtualMachineInstance,
+// or nil when there is none.
+func RunningLauncherPod(ctx context.Context, client kubecli.KubevirtClient, namespace, name string) (*corev1.Pod, error) {
+	labelSelector := labels.SelectorFromSet(labels.Set{
+		v1.AppLabel:                "virt-launcher",
+		v1.VirtualMachineNameLabel: name,
+	})
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labelSelector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range pods.Items {
+		if pods.Items[i].Status.Phase == corev1.PodRunning {
+			return &pods.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// putLauncherPodData adds the IP address and the node of the running virt-launcher pod of
+// the VM to the generated data. Nothing is added while no launcher pod is running. Since the
+// data is optional, a failure to list the pods is only a warning and leaves it empty.
+func putLauncherPodData(ctx context.Context, ui packer.Ui, client kubecli.KubevirtClient, state multistep.StateBag, namespace, name string) {
+	generatedData := &packerbuilderdata.GeneratedData{State: state}
+
+	pod, err := RunningLauncherPod(ctx, client, namespace, name)
+	if err != nil {
+		ui.Sayf("Warning: could not find the virt-launcher pod of the VM, PodIP and NodeName are left empty: %s", err)
+		generatedData.Put("PodIP", "")
+		generatedData.Put("NodeName", "")
+		return
+	}
+	if pod == nil {
+		return
+	}
+
+	generatedData.Put("PodIP", pod.Status.PodIP)
+	generatedData.Put("NodeName", pod.Spec.NodeName)
+}
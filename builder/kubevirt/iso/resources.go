@@ -4,19 +4,33 @@
 package iso
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	ptr "k8s.io/utils/ptr"
 
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+
 	v1 "kubevirt.io/api/core/v1"
+	exportv1 "kubevirt.io/api/export/v1beta1"
 	instancetypeapi "kubevirt.io/api/instancetype"
 	cdiv1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
 )
 
+// temporaryResourceLabels returns the labels set on the temporary resources created for a build,
+// so that leftovers of interrupted builds can be found and deleted.
+func temporaryResourceLabels(name string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/managed-by": "packer",
+		"packer.kubevirt.io/build":     name,
+	}
+}
+
 func configMap(name string, mediaFiles []string) (*corev1.ConfigMap, error) {
 	data := make(map[string]string)
 
@@ -32,7 +46,8 @@ func configMap(name string, mediaFiles []string) (*corev1.ConfigMap, error) {
 
 	return &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: name,
+			Name:   name,
+			Labels: temporaryResourceLabels(name),
 		},
 		Data: data,
 	}, nil
@@ -76,7 +91,8 @@ func virtualMachine(
 	preferenceKind,
 	osType,
 	storageClassName string,
-	networks []Network) *v1.VirtualMachine {
+	networks []Network,
+	runStrategy v1.VirtualMachineRunStrategy) *v1.VirtualMachine {
 	var disks []v1.Disk
 	var volumes []v1.Volume
 
@@ -111,10 +127,11 @@ func virtualMachine(
 			Kind:       "VirtualMachine",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name: name,
+			Name:   name,
+			Labels: temporaryResourceLabels(name),
 		},
 		Spec: v1.VirtualMachineSpec{
-			RunStrategy: ptr.To(v1.RunStrategyAlways),
+			RunStrategy: ptr.To(runStrategy),
 			Instancetype: &v1.InstancetypeMatcher{
 				Kind: instanceTypeKind,
 				Name: instanceType,
@@ -142,7 +159,9 @@ func virtualMachine(
 	}
 }
 
-func cloneVolume(name, namespace, diskSize, storageClassName string) *cdiv1.DataVolume {
+// cloneVolume returns a DataVolume cloning the root disk of the VM, which is in
+// sourceNamespace.
+func cloneVolume(name, sourceNamespace, diskSize, storageClassName string) *cdiv1.DataVolume {
 	dv := &cdiv1.DataVolume{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: cdiv1.CDIGroupVersionKind.GroupVersion().String(),
@@ -155,7 +174,7 @@ func cloneVolume(name, namespace, diskSize, storageClassName string) *cdiv1.Data
 			Source: &cdiv1.DataVolumeSource{
 				PVC: &cdiv1.DataVolumeSourcePVC{
 					Name:      name + "-rootdisk",
-					Namespace: namespace,
+					Namespace: sourceNamespace,
 				},
 			},
 			PVC: &corev1.PersistentVolumeClaimSpec{
@@ -177,7 +196,32 @@ func cloneVolume(name, namespace, diskSize, storageClassName string) *cdiv1.Data
 	return dv
 }
 
-func sourceVolume(name, namespace, instanceType, preferenceName string) *cdiv1.DataSource {
+// volumeSnapshot returns a VolumeSnapshot of the PersistentVolumeClaim, of the default
+// class of its CSI driver when snapshotClass is empty.
+func volumeSnapshot(name, pvcName, snapshotClass string) *snapshotv1.VolumeSnapshot {
+	snapshot := &snapshotv1.VolumeSnapshot{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: snapshotv1.SchemeGroupVersion.String(),
+			Kind:       "VolumeSnapshot",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: snapshotv1.VolumeSnapshotSpec{
+			Source: snapshotv1.VolumeSnapshotSource{
+				PersistentVolumeClaimName: ptr.To(pvcName),
+			},
+		},
+	}
+
+	if snapshotClass != "" {
+		snapshot.Spec.VolumeSnapshotClassName = ptr.To(snapshotClass)
+	}
+
+	return snapshot
+}
+
+func sourceVolume(name, instanceType, preferenceName string, source cdiv1.DataSourceSource) *cdiv1.DataSource {
 	return &cdiv1.DataSource{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: cdiv1.CDIGroupVersionKind.GroupVersion().String(),
@@ -191,10 +235,110 @@ func sourceVolume(name, namespace, instanceType, preferenceName string) *cdiv1.D
 			},
 		},
 		Spec: cdiv1.DataSourceSpec{
-			Source: cdiv1.DataSourceSource{
-				PVC: &cdiv1.DataVolumeSourcePVC{
-					Name:      name,
-					Namespace: namespace,
+			Source: source,
+		},
+	}
+}
+
+func service(name string, serviceType corev1.ServiceType, ports []int) *corev1.Service {
+	servicePorts := make([]corev1.ServicePort, len(ports))
+	for i, port := range ports {
+		servicePorts[i] = corev1.ServicePort{
+			Name:       fmt.Sprintf("port-%d", port),
+			Protocol:   corev1.ProtocolTCP,
+			Port:       int32(port),
+			TargetPort: intstr.FromInt32(int32(port)),
+		}
+	}
+
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: temporaryResourceLabels(name),
+		},
+		Spec: corev1.ServiceSpec{
+			Type: serviceType,
+			// The virt-launcher pod of the VMI carries the name of the VM.
+			Selector: map[string]string{
+				v1.VirtualMachineNameLabel: name,
+			},
+			Ports: servicePorts,
+		},
+	}
+}
+
+// sshKeySecretName returns the name of the Secret holding the SSH public key of the VM.
+func sshKeySecretName(name string) string {
+	return name + "-ssh-key"
+}
+
+func sshKeySecret(name string, publicKey []byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   sshKeySecretName(name),
+			Labels: temporaryResourceLabels(name),
+		},
+		Data: map[string][]byte{
+			"authorized_keys": publicKey,
+		},
+	}
+}
+
+// sshHostKeySecretName returns the name of the Secret holding the generated SSH host key of the VM.
+func sshHostKeySecretName(name string) string {
+	return name + "-ssh-host-key"
+}
+
+func sshHostKeySecret(name string, privateKey, publicKey string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   sshHostKeySecretName(name),
+			Labels: temporaryResourceLabels(name),
+		},
+		StringData: map[string]string{
+			"ssh_host_ed25519_key":     privateKey,
+			"ssh_host_ed25519_key.pub": publicKey,
+		},
+	}
+}
+
+// sshHostKeyVolume returns the disk and the volume exposing the Secret of the generated
+// SSH host key to the installer, as a CD-ROM labelled SSHHOSTKEY.
+func sshHostKeyVolume(name string) (v1.Disk, v1.Volume) {
+	disk := v1.Disk{
+		Name: "sshhostkey",
+		DiskDevice: v1.DiskDevice{
+			CDRom: &v1.CDRomTarget{
+				Bus:  "sata",
+				Tray: "closed",
+			},
+		},
+	}
+	volume := v1.Volume{
+		Name: "sshhostkey",
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName:  sshHostKeySecretName(name),
+				VolumeLabel: "SSHHOSTKEY",
+			},
+		},
+	}
+	return disk, volume
+}
+
+// sshAccessCredential propagates the SSH public key of the Secret to the authorized keys of
+// the user through the QEMU guest agent.
+func sshAccessCredential(name, user string) v1.AccessCredential {
+	return v1.AccessCredential{
+		SSHPublicKey: &v1.SSHPublicKeyAccessCredential{
+			Source: v1.SSHPublicKeyAccessCredentialSource{
+				Secret: &v1.AccessCredentialSecretSource{
+					SecretName: sshKeySecretName(name),
+				},
+			},
+			PropagationMethod: v1.SSHPublicKeyAccessCredentialPropagationMethod{
+				QemuGuestAgent: &v1.QemuGuestAgentSSHPublicKeyAccessCredentialPropagation{
+					Users: []string{user},
 				},
 			},
 		},
@@ -368,3 +512,42 @@ func convertToNetwork(n Network) (v1.Network, v1.Interface) {
 	}
 	return vmNetwork, vmInterface
 }
+
+// exportName returns the name of the VirtualMachineExport of the disk of the image.
+func exportName(name string) string {
+	return name + "-export"
+}
+
+// exportTokenSecretName returns the name of the Secret holding the token of the export server.
+func exportTokenSecretName(name string) string {
+	return name + "-export-token"
+}
+
+func exportTokenSecret(name string, token string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   exportTokenSecretName(name),
+			Labels: temporaryResourceLabels(name),
+		},
+		StringData: map[string]string{
+			"token": token,
+		},
+	}
+}
+
+func virtualMachineExport(name, pvcName string) *exportv1.VirtualMachineExport {
+	return &exportv1.VirtualMachineExport{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   exportName(name),
+			Labels: temporaryResourceLabels(name),
+		},
+		Spec: exportv1.VirtualMachineExportSpec{
+			Source: corev1.TypedLocalObjectReference{
+				APIGroup: ptr.To(""),
+				Kind:     "PersistentVolumeClaim",
+				Name:     pvcName,
+			},
+			TokenSecretRef: ptr.To(exportTokenSecretName(name)),
+		},
+	}
+}
@@ -42,12 +42,14 @@ func (s *StepBootCommand) Run(ctx context.Context, state multistep.StateBag) mul
 
 	streamInterface, err := s.client.VirtualMachineInstance(namespace).VNC(name)
 	if err != nil {
+		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
 
 	connection, err := vnc.Client(streamInterface.AsConn(), &vnc.ClientConfig{})
 	if err != nil {
+		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
@@ -56,18 +58,21 @@ func (s *StepBootCommand) Run(ctx context.Context, state multistep.StateBag) mul
 
 	command, err := interpolate.Render(bootCommand, &interpolate.Context{})
 	if err != nil {
+		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
 
 	sequence, err := bootcommand.GenerateExpressionSequence(command)
 	if err != nil {
+		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
 
 	driver := bootcommand.NewVNCDriver(connection, time.Duration(0))
 	if err := sequence.Do(ctx, driver); err != nil {
+		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
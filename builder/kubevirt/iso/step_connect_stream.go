@@ -0,0 +1,114 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	packerssh "github.com/hashicorp/packer-plugin-sdk/sdk-internals/communicator/ssh"
+	"golang.org/x/crypto/ssh"
+
+	"kubevirt.io/client-go/kubecli"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/common"
+)
+
+// StepConnectStream connects the SSH communicator through KubeVirt port-forward streams,
+// the way "virtctl ssh" does, so that no local port is used.
+type StepConnectStream struct {
+	Config    Config
+	Client    kubecli.KubevirtClient
+	SSHConfig func(multistep.StateBag) (*ssh.ClientConfig, error)
+}
+
+func (s *StepConnectStream) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+	name := s.Config.Name
+	namespace := s.Config.Namespace
+	pollInterval := 5 * time.Second
+
+	timeout := s.Config.Comm.SSHTimeout
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+
+	sshConfig, err := s.SSHConfig(state)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Sayf("Waiting for SSH to become available through the port-forward stream of the VirtualMachine (%s/%s)...", namespace, name)
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	vm := s.Client.VirtualMachine(namespace)
+	connection := func() (net.Conn, error) {
+		stream, err := vm.PortForward(name, s.Config.SSHRemotePort, common.ProtocolTCP)
+		if err != nil {
+			return nil, err
+		}
+		return stream.AsConn(), nil
+	}
+
+	address := fmt.Sprintf("%s.%s:%d", name, namespace, s.Config.SSHRemotePort)
+	config := &packerssh.Config{
+		SSHConfig:              sshConfig,
+		Connection:             connection,
+		Pty:                    s.Config.Comm.SSHPty,
+		DisableAgentForwarding: s.Config.Comm.SSHDisableAgentForwarding,
+		UseSftp:                s.Config.Comm.SSHFileTransferMethod == "sftp",
+		KeepAliveInterval:      s.Config.Comm.SSHKeepAliveInterval,
+		Timeout:                s.Config.Comm.SSHReadWriteTimeout,
+		HandshakeTimeout:       pollInterval * 2,
+	}
+
+	handshakeAttempts := 0
+	for {
+		comm, err := packerssh.New(address, config)
+		if err == nil {
+			ui.Say("Connected to SSH!")
+			state.Put("communicator", comm)
+			return multistep.ActionContinue
+		}
+
+		// Only the attempts that reached the authentication count as handshake attempts,
+		// as in the SSH communicator of the SDK.
+		if strings.Contains(err.Error(), "authenticate") {
+			handshakeAttempts++
+			attempts := s.Config.Comm.SSHHandshakeAttempts
+			if attempts > 0 && handshakeAttempts >= attempts {
+				err := fmt.Errorf("failed to authenticate to SSH after %d attempts: %w", handshakeAttempts, err)
+				state.Put("error", err)
+				ui.Error(err.Error())
+				return multistep.ActionHalt
+			}
+		}
+
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				ui.Say("Context cancelled, stopping SSH connection attempts...")
+				return multistep.ActionHalt
+			}
+			err := fmt.Errorf("timed out after %s waiting for SSH: %w", timeout, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (s *StepConnectStream) Cleanup(state multistep.StateBag) {
+	// Left blank intentionally
+}
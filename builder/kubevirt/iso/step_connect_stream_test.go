@@ -0,0 +1,253 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	kubecli "kubevirt.io/client-go/kubecli"
+	kubevirtfake "kubevirt.io/client-go/kubevirt/fake"
+	kvcorev1 "kubevirt.io/client-go/kubevirt/typed/core/v1"
+)
+
+// sshVirtualMachines serves SSH on the port-forward streams of the VMs.
+type sshVirtualMachines struct {
+	kvcorev1.VirtualMachineInterface
+
+	mu       sync.Mutex
+	config   *ssh.ServerConfig
+	failures int
+	calls    int
+	ports    []int
+}
+
+func (v *sshVirtualMachines) PortForward(name string, port int, protocol string) (kvcorev1.StreamInterface, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.calls++
+	v.ports = append(v.ports, port)
+	if v.calls <= v.failures {
+		return nil, fmt.Errorf("virtual machine %s is not running", name)
+	}
+
+	// SSH writes its version from both ends at once, which deadlocks on a synchronous net.Pipe.
+	local, remote, err := tcpPipe()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer remote.Close()
+		conn, chans, reqs, err := ssh.NewServerConn(remote, v.config)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		go ssh.DiscardRequests(reqs)
+		for ch := range chans {
+			_ = ch.Reject(ssh.Prohibited, "not supported")
+		}
+	}()
+	return &pipeStream{conn: local}, nil
+}
+
+// pipeStream is a port-forward stream over a connection.
+type pipeStream struct {
+	conn net.Conn
+}
+
+func (s *pipeStream) Stream(options kvcorev1.StreamOptions) error {
+	return nil
+}
+
+func (s *pipeStream) AsConn() net.Conn {
+	return s.conn
+}
+
+// tcpPipe returns both ends of a loopback TCP connection.
+func tcpPipe() (net.Conn, net.Conn, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer listener.Close()
+
+	local, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return nil, nil, err
+	}
+	remote, err := listener.Accept()
+	if err != nil {
+		local.Close()
+		return nil, nil, err
+	}
+	return local, remote, nil
+}
+
+var _ = Describe("StepConnectStream", func() {
+	const (
+		namespace = "test-ns"
+		name      = "test-vm"
+	)
+
+	var (
+		mockCtrl *gomock.Controller
+		vms      *sshVirtualMachines
+		state    *multistep.BasicStateBag
+		uiErr    *strings.Builder
+		step     *iso.StepConnectStream
+	)
+
+	BeforeEach(func() {
+		uiErr = &strings.Builder{}
+		ui := &packer.BasicUi{
+			Reader:      strings.NewReader(""),
+			Writer:      io.Discard,
+			ErrorWriter: uiErr,
+		}
+		state = new(multistep.BasicStateBag)
+		state.Put("ui", ui)
+
+		_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		signer, err := ssh.NewSignerFromKey(hostKey)
+		Expect(err).NotTo(HaveOccurred())
+
+		serverConfig := &ssh.ServerConfig{
+			PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+				if conn.User() == "user" && string(password) == "secret" {
+					return nil, nil
+				}
+				return nil, errors.New("access denied")
+			},
+		}
+		serverConfig.AddHostKey(signer)
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		vmClient := kubevirtfake.NewSimpleClientset()
+		vms = &sshVirtualMachines{
+			VirtualMachineInterface: vmClient.KubevirtV1().VirtualMachines(namespace),
+			config:                  serverConfig,
+		}
+
+		kubecli.GetKubevirtClientFromClientConfig = kubecli.GetMockKubevirtClientFromClientConfig
+		mockVirt := kubecli.NewMockKubevirtClient(mockCtrl)
+		kubecli.MockKubevirtClientInstance = mockVirt
+		mockVirt.EXPECT().VirtualMachine(namespace).Return(vms).AnyTimes()
+		virtClient, _ := kubecli.GetKubevirtClientFromClientConfig(nil)
+
+		step = &iso.StepConnectStream{
+			Config: iso.Config{
+				Name:      name,
+				Namespace: namespace,
+				Comm: communicator.Config{
+					Type: "ssh",
+					SSH:  communicator.SSH{SSHTimeout: 30 * time.Second},
+				},
+				SSHRemotePort: 22,
+			},
+			Client: virtClient,
+			SSHConfig: func(state multistep.StateBag) (*ssh.ClientConfig, error) {
+				return &ssh.ClientConfig{
+					User:            "user",
+					Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+					HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				}, nil
+			},
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	Context("Run", func() {
+		It("continues when SSH connects through the stream", func() {
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(state.Get("communicator")).NotTo(BeNil())
+			Expect(vms.ports).To(ConsistOf(22))
+		})
+
+		It("retries until the stream can be opened", func() {
+			vms.failures = 1
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(vms.calls).To(Equal(2))
+		})
+
+		It("halts when SSH does not become available before the timeout", func() {
+			vms.failures = 100
+			step.Config.Comm.SSHTimeout = time.Second
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(HaveOccurred())
+			Expect(uiErr.String()).To(ContainSubstring("timed out after 1s waiting for SSH"))
+		})
+
+		It("halts when the credentials are rejected", func() {
+			step.SSHConfig = func(state multistep.StateBag) (*ssh.ClientConfig, error) {
+				return &ssh.ClientConfig{
+					User:            "user",
+					Auth:            []ssh.AuthMethod{ssh.Password("wrong")},
+					HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				}, nil
+			}
+			step.Config.Comm.SSHTimeout = time.Second
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(uiErr.String()).To(ContainSubstring("unable to authenticate"))
+		})
+
+		It("halts once the handshake attempts are exhausted", func() {
+			step.SSHConfig = func(state multistep.StateBag) (*ssh.ClientConfig, error) {
+				return &ssh.ClientConfig{
+					User:            "user",
+					Auth:            []ssh.AuthMethod{ssh.Password("wrong")},
+					HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				}, nil
+			}
+			step.Config.Comm.SSHHandshakeAttempts = 2
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(ContainSubstring("failed to authenticate to SSH after 2 attempts")))
+			Expect(vms.calls).To(Equal(2))
+		})
+
+		It("halts without error when the context is cancelled", func() {
+			vms.failures = 100
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			action := step.Run(ctx, state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			_, hasError := state.GetOk("error")
+			Expect(hasError).To(BeFalse())
+		})
+	})
+})
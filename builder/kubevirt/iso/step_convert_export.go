@@ -0,0 +1,149 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/diskimage"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// StepConvertExport converts the downloaded disk to the formats of export_convert_formats.
+// Each image is written next to export_path, along with its checksum file.
+type StepConvertExport struct {
+	Config Config
+}
+
+func (s *StepConvertExport) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+
+	path, ok := state.Get("export_path").(string)
+	if !ok || path == "" {
+		err := errors.New("export path not found in state")
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	exportFormat, _ := state.Get("export_format").(string)
+
+	size, err := diskImageSize(path, exportFormat)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	images := map[string]string{}
+	checksums := map[string]string{}
+	for _, format := range s.Config.ExportConvertFormats {
+		imagePath := convertedPath(path, exportFormat, format)
+		ui.Sayf("Converting the disk image to %s (%s)...", format, imagePath)
+
+		checksum, err := convertDiskImage(ctx, ui, path, exportFormat, size, imagePath, format)
+		if err != nil {
+			if ctx.Err() != nil {
+				ui.Say("Context cancelled, stopping the conversion of the disk image...")
+				return multistep.ActionHalt
+			}
+			err = fmt.Errorf("failed to convert the disk image to %s: %w", format, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+
+		ui.Sayf("Converted the disk image to %s (sha256 %s).", imagePath, checksum)
+		images[format] = imagePath
+		checksums[format] = checksum
+	}
+
+	state.Put("converted_images", images)
+	state.Put("converted_images_sha256", checksums)
+	return multistep.ActionContinue
+}
+
+func (s *StepConvertExport) Cleanup(state multistep.StateBag) {
+	// Left blank intentionally
+}
+
+// convertedPath returns the path of the image converted to format from the disk image at
+// path: the extension of path is replaced by the one of the format.
+func convertedPath(path, exportFormat, format string) string {
+	if exportFormat == "gzip" {
+		path = strings.TrimSuffix(path, ".gz")
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "." + format
+}
+
+// convertDiskImage converts the disk image at path, of size bytes once decompressed, to
+// imagePath in format, and returns the SHA-256 checksum of the converted image.
+func convertDiskImage(ctx context.Context, ui packer.Ui, path, exportFormat string, size int64, imagePath, format string) (string, error) {
+	partPath := imagePath + ".part"
+	f, err := os.Create(partPath)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(partPath)
+
+	err = writeDiskImage(ctx, ui, path, exportFormat, size, f, filepath.Base(imagePath), format)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	// The images are written out of order, their checksum is computed once complete.
+	hash := sha256.New()
+	if err == nil {
+		_, err = io.Copy(hash, f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(partPath, imagePath); err != nil {
+		return "", err
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if err := writeChecksumFile(imagePath, checksum); err != nil {
+		return "", err
+	}
+	return checksum, nil
+}
+
+// writeDiskImage converts the disk image at path, of size bytes once decompressed, to w in
+// format, reporting the progress under the name.
+func writeDiskImage(ctx context.Context, ui packer.Ui, path, exportFormat string, size int64, w io.WriteSeeker, name, format string) error {
+	disk, err := openDiskImage(path, exportFormat)
+	if err != nil {
+		return err
+	}
+	defer disk.Close()
+
+	body := ui.TrackProgress(name, 0, size, io.NopCloser(&contextReader{ctx: ctx, r: disk}))
+	defer body.Close()
+	return diskimage.Convert(w, body, size, format)
+}
+
+// contextReader stops reading once the context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
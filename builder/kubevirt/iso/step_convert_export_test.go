@@ -0,0 +1,175 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso_test
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+var _ = Describe("StepConvertExport", func() {
+	var (
+		state *multistep.BasicStateBag
+		uiErr *strings.Builder
+		step  *iso.StepConvertExport
+		dir   string
+		disk  []byte
+	)
+
+	// fileChecksum returns the SHA-256 checksum of the file.
+	fileChecksum := func(path string) string {
+		content, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		sum := sha256.Sum256(content)
+		return hex.EncodeToString(sum[:])
+	}
+
+	BeforeEach(func() {
+		uiErr = &strings.Builder{}
+		ui := &packer.BasicUi{
+			Reader:      strings.NewReader(""),
+			Writer:      io.Discard,
+			ErrorWriter: uiErr,
+			PB:          &packer.NoopProgressTracker{},
+		}
+		state = new(multistep.BasicStateBag)
+		state.Put("ui", ui)
+
+		dir = GinkgoT().TempDir()
+		disk = make([]byte, 4*1024*1024)
+		copy(disk, "bootable disk content")
+		copy(disk[3*1024*1024:], "root filesystem content")
+		exportPath := filepath.Join(dir, "test-vm.img")
+		Expect(os.WriteFile(exportPath, disk, 0644)).To(Succeed())
+		state.Put("export_path", exportPath)
+		state.Put("export_format", "raw")
+
+		step = &iso.StepConvertExport{
+			Config: iso.Config{
+				ExportConvertFormats: []string{"qcow2", "vmdk", "vhdx"},
+			},
+		}
+	})
+
+	Context("Run", func() {
+		It("converts the disk image to every format", func() {
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			images := state.Get("converted_images").(map[string]string)
+			Expect(images).To(Equal(map[string]string{
+				"qcow2": filepath.Join(dir, "test-vm.qcow2"),
+				"vmdk":  filepath.Join(dir, "test-vm.vmdk"),
+				"vhdx":  filepath.Join(dir, "test-vm.vhdx"),
+			}))
+
+			magics := map[string]string{"qcow2": "QFI\xfb", "vmdk": "KDMV", "vhdx": "vhdx"}
+			for format, path := range images {
+				content, err := os.ReadFile(path)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content[:4])).To(Equal(magics[format]))
+				Expect(path + ".part").NotTo(BeAnExistingFile())
+			}
+		})
+
+		It("writes the checksums of the converted images", func() {
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			qcow2Path := filepath.Join(dir, "test-vm.qcow2")
+			checksum := fileChecksum(qcow2Path)
+			Expect(state.Get("converted_images_sha256")).To(HaveKeyWithValue("qcow2", checksum))
+
+			content, err := os.ReadFile(qcow2Path + ".sha256")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(checksum + "  test-vm.qcow2\n"))
+		})
+
+		It("leaves the zero clusters out of the converted images", func() {
+			step.Config.ExportConvertFormats = []string{"qcow2"}
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			info, err := os.Stat(filepath.Join(dir, "test-vm.qcow2"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Size()).To(BeNumerically("<", len(disk)/4))
+		})
+
+		It("decompresses a gzip disk image", func() {
+			exportPath := filepath.Join(dir, "test-vm.img.gz")
+			f, err := os.Create(exportPath)
+			Expect(err).NotTo(HaveOccurred())
+			gz := gzip.NewWriter(f)
+			_, err = gz.Write(disk)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gz.Close()).To(Succeed())
+			Expect(f.Close()).To(Succeed())
+			state.Put("export_path", exportPath)
+			state.Put("export_format", "gzip")
+			step.Config.ExportConvertFormats = []string{"vhdx"}
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			vhdxPath := filepath.Join(dir, "test-vm.vhdx")
+			Expect(state.Get("converted_images")).To(HaveKeyWithValue("vhdx", vhdxPath))
+			content, err := os.ReadFile(vhdxPath)
+			Expect(err).NotTo(HaveOccurred())
+			// The first payload block follows the 1 MiB block allocation table at 3 MiB.
+			Expect(string(content[4*1024*1024:][:21])).To(Equal("bootable disk content"))
+		})
+
+		It("halts when the disk image cannot be converted", func() {
+			Expect(os.WriteFile(state.Get("export_path").(string), disk[:1000], 0644)).To(Succeed())
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(ContainSubstring("failed to convert the disk image to qcow2")))
+			Expect(uiErr.String()).To(ContainSubstring("failed to convert"))
+			Expect(filepath.Join(dir, "test-vm.qcow2.part")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(dir, "test-vm.qcow2")).NotTo(BeAnExistingFile())
+		})
+
+		It("halts without an error when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			action := step.Run(ctx, state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(BeNil())
+		})
+
+		It("halts without an error when the deadline of the context is exceeded", func() {
+			ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+			defer cancel()
+
+			action := step.Run(ctx, state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(BeNil())
+		})
+
+		It("halts without the downloaded disk image", func() {
+			state.Remove("export_path")
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError("export path not found in state"))
+		})
+	})
+})
@@ -28,15 +28,34 @@ func (s *StepCopyMediaFiles) Run(ctx context.Context, state multistep.StateBag)
 
 	configMap, err := configMap(name, mediaFiles)
 	if err != nil {
+		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
 
+	if s.Config.SSHPublicKeyInjection == "media_files" {
+		configMap.Data["authorized_keys"] = state.Get("ssh_public_key").(string)
+	}
+
 	_, err = s.Client.CoreV1().ConfigMaps(namespace).Create(ctx, configMap, metav1.CreateOptions{})
 	if err != nil {
+		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
+
+	// The private host key is kept out of the ConfigMap, readable by more users than Secrets.
+	if s.Config.SSHHostKeyVerification == "generated" {
+		secretName := sshHostKeySecretName(name)
+		ui.Sayf("Creating a new Secret to store the SSH host key (%s/%s)...", namespace, secretName)
+
+		secret := sshHostKeySecret(name, state.Get("ssh_host_private_key").(string), state.Get("ssh_host_public_key").(string))
+		if _, err := s.Client.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
 	return multistep.ActionContinue
 }
 
@@ -48,4 +67,11 @@ func (s *StepCopyMediaFiles) Cleanup(state multistep.StateBag) {
 	ui.Sayf("Deleting ConfigMap (%s/%s)...", namespace, name)
 
 	_ = s.Client.CoreV1().ConfigMaps(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+
+	if s.Config.SSHHostKeyVerification == "generated" {
+		secretName := sshHostKeySecretName(name)
+		ui.Sayf("Deleting Secret (%s/%s)...", namespace, secretName)
+
+		_ = s.Client.CoreV1().Secrets(namespace).Delete(context.Background(), secretName, metav1.DeleteOptions{})
+	}
 }
@@ -12,46 +12,155 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"kubevirt.io/client-go/kubecli"
+	cdiv1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
 )
 
+// StepCreateBootableVolume publishes the root disk of the VM as the DataSource of the
+// image, backed by a clone of the disk or by a VolumeSnapshot of it, as set by output_type.
+// The clone and the DataSource are created in output_namespace.
 type StepCreateBootableVolume struct {
 	Config Config
 	Client kubecli.KubevirtClient
+
+	// The resources created by the step, deleted when the build does not complete.
+	dataVolume string
+	snapshot   string
+	dataSource string
 }
 
 func (s *StepCreateBootableVolume) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	ui := state.Get("ui").(packer.Ui)
 	name := s.Config.Name
-	namespace := s.Config.Namespace
-	diskSize := s.Config.DiskSize
+	namespace := s.outputNamespace()
 	instanceType := s.Config.InstanceType
 	preferenceName := s.Config.Preference
-	cloneVolume := cloneVolume(name, namespace, diskSize, s.Config.StorageClassName)
-	sourceVolume := sourceVolume(name, namespace, instanceType, preferenceName)
-
-	ui.Sayf("Creating a new bootable volume (%s/%s)...", namespace, name)
 
-	dv, err := s.Client.CdiClient().CdiV1beta1().DataVolumes(namespace).Create(ctx, cloneVolume, metav1.CreateOptions{})
-	if err != nil {
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+	var source cdiv1.DataSourceSource
+	var err error
+	if s.Config.OutputType == "snapshot" {
+		source, err = s.createSnapshot(ctx, ui, state)
+	} else {
+		source, err = s.createClone(ctx, ui, state)
 	}
-
-	if err = WaitUntilDataVolumeSucceeded(ctx, s.Client, dv.Namespace, dv.Name); err != nil {
+	if err != nil {
+		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
 
-	ds, err := s.Client.CdiClient().CdiV1beta1().DataSources(namespace).Create(ctx, sourceVolume, metav1.CreateOptions{})
+	ds, err := s.Client.CdiClient().CdiV1beta1().DataSources(namespace).Create(ctx, sourceVolume(name, instanceType, preferenceName, source), metav1.CreateOptions{})
 	if err != nil {
+		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
+	s.dataSource = ds.Name
 
 	state.Put("bootable_volume_name", ds.Name)
+	state.Put("bootable_volume_namespace", namespace)
 	return multistep.ActionContinue
 }
 
+// createClone clones the root disk to a DataVolume in the output namespace, and returns
+// the source of the DataSource referencing its PersistentVolumeClaim.
+func (s *StepCreateBootableVolume) createClone(ctx context.Context, ui packer.Ui, state multistep.StateBag) (cdiv1.DataSourceSource, error) {
+	name := s.Config.Name
+	namespace := s.Config.Namespace
+	outputNamespace := s.outputNamespace()
+
+	ui.Sayf("Creating a new bootable volume (%s/%s)...", outputNamespace, name)
+
+	cloneVolume := cloneVolume(name, namespace, s.Config.DiskSize, s.Config.StorageClassName)
+	dv, err := s.Client.CdiClient().CdiV1beta1().DataVolumes(outputNamespace).Create(ctx, cloneVolume, metav1.CreateOptions{})
+	if err != nil {
+		return cdiv1.DataSourceSource{}, err
+	}
+	s.dataVolume = dv.Name
+
+	if err = WaitUntilDataVolumeSucceeded(ctx, s.Client, dv.Namespace, dv.Name); err != nil {
+		return cdiv1.DataSourceSource{}, err
+	}
+
+	state.Put("bootable_volume_data_volume", dv.Name)
+	// The export runs in the build namespace, where the root disk holds the same content
+	// as the clone.
+	if outputNamespace == namespace {
+		state.Put("bootable_volume_pvc", dv.Name)
+	} else {
+		state.Put("bootable_volume_pvc", name+"-rootdisk")
+	}
+	return cdiv1.DataSourceSource{
+		PVC: &cdiv1.DataVolumeSourcePVC{
+			Name:      dv.Name,
+			Namespace: outputNamespace,
+		},
+	}, nil
+}
+
+// createSnapshot takes a VolumeSnapshot of the root disk, and returns the source of the
+// DataSource referencing it once it is ready to use.
+func (s *StepCreateBootableVolume) createSnapshot(ctx context.Context, ui packer.Ui, state multistep.StateBag) (cdiv1.DataSourceSource, error) {
+	name := s.Config.Name
+	namespace := s.Config.Namespace
+	rootDiskName := name + "-rootdisk"
+
+	ui.Sayf("Creating a VolumeSnapshot of the root disk (%s/%s)...", namespace, name)
+
+	snapshot := volumeSnapshot(name, rootDiskName, s.Config.VolumeSnapshotClass)
+	snapshot, err := s.Client.KubernetesSnapshotClient().SnapshotV1().VolumeSnapshots(namespace).Create(ctx, snapshot, metav1.CreateOptions{})
+	if err != nil {
+		return cdiv1.DataSourceSource{}, err
+	}
+	s.snapshot = snapshot.Name
+
+	if err = WaitUntilVolumeSnapshotReady(ctx, s.Client, namespace, snapshot.Name); err != nil {
+		return cdiv1.DataSourceSource{}, err
+	}
+
+	// The root disk holds the content of the snapshot until the VM is deleted.
+	state.Put("bootable_volume_snapshot", snapshot.Name)
+	state.Put("bootable_volume_pvc", rootDiskName)
+	return cdiv1.DataSourceSource{
+		Snapshot: &cdiv1.DataVolumeSourceSnapshot{
+			Name:      snapshot.Name,
+			Namespace: namespace,
+		},
+	}, nil
+}
+
+// outputNamespace returns the namespace the image is published to.
+func (s *StepCreateBootableVolume) outputNamespace() string {
+	if s.Config.OutputNamespace != "" {
+		return s.Config.OutputNamespace
+	}
+	return s.Config.Namespace
+}
+
 func (s *StepCreateBootableVolume) Cleanup(state multistep.StateBag) {
-	// Left blank intentionally
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	_, failed := state.GetOk("error")
+	if !cancelled && !halted && !failed {
+		return
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	namespace := s.Config.Namespace
+	outputNamespace := s.outputNamespace()
+	ctx := context.Background()
+
+	if s.dataSource != "" {
+		ui.Sayf("Deleting DataSource (%s/%s)...", outputNamespace, s.dataSource)
+		_ = s.Client.CdiClient().CdiV1beta1().DataSources(outputNamespace).Delete(ctx, s.dataSource, metav1.DeleteOptions{})
+	}
+
+	if s.dataVolume != "" {
+		ui.Sayf("Deleting DataVolume (%s/%s)...", outputNamespace, s.dataVolume)
+		_ = s.Client.CdiClient().CdiV1beta1().DataVolumes(outputNamespace).Delete(ctx, s.dataVolume, metav1.DeleteOptions{})
+	}
+
+	if s.snapshot != "" {
+		ui.Sayf("Deleting VolumeSnapshot (%s/%s)...", namespace, s.snapshot)
+		_ = s.Client.KubernetesSnapshotClient().SnapshotV1().VolumeSnapshots(namespace).Delete(ctx, s.snapshot, metav1.DeleteOptions{})
+	}
 }
@@ -18,13 +18,17 @@ import (
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
 
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+
 	fakecdiclient "kubevirt.io/client-go/containerizeddataimporter/fake"
+	fakesnapshotclient "kubevirt.io/client-go/externalsnapshotter/fake"
 	"kubevirt.io/client-go/kubecli"
 	cdiv1beta1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/testing"
+	ptr "k8s.io/utils/ptr"
 )
 
 var _ = Describe("StepCreateBootableVolume", func() {
@@ -34,11 +38,12 @@ var _ = Describe("StepCreateBootableVolume", func() {
 	)
 
 	var (
-		ctrl       *gomock.Controller
-		state      *multistep.BasicStateBag
-		step       *iso.StepCreateBootableVolume
-		cdiClient  *fakecdiclient.Clientset
-		virtClient kubecli.KubevirtClient
+		ctrl           *gomock.Controller
+		state          *multistep.BasicStateBag
+		step           *iso.StepCreateBootableVolume
+		cdiClient      *fakecdiclient.Clientset
+		snapshotClient *fakesnapshotclient.Clientset
+		virtClient     kubecli.KubevirtClient
 	)
 
 	BeforeEach(func() {
@@ -56,6 +61,8 @@ var _ = Describe("StepCreateBootableVolume", func() {
 		kubecli.GetKubevirtClientFromClientConfig = kubecli.GetMockKubevirtClientFromClientConfig
 		kubecli.MockKubevirtClientInstance = kubecli.NewMockKubevirtClient(ctrl)
 		kubecli.MockKubevirtClientInstance.EXPECT().CdiClient().Return(cdiClient).AnyTimes()
+		snapshotClient = fakesnapshotclient.NewSimpleClientset()
+		kubecli.MockKubevirtClientInstance.EXPECT().KubernetesSnapshotClient().Return(snapshotClient).AnyTimes()
 		virtClient, _ = kubecli.GetKubevirtClientFromClientConfig(nil)
 
 		step = &iso.StepCreateBootableVolume{
@@ -87,9 +94,10 @@ var _ = Describe("StepCreateBootableVolume", func() {
 				return true, dv, nil
 			})
 
+			var ds *cdiv1beta1.DataSource
 			cdiClient.PrependReactor("create", "datasources", func(action testing.Action) (bool, runtime.Object, error) {
 				create := action.(testing.CreateAction)
-				ds := create.GetObject().(*cdiv1beta1.DataSource)
+				ds = create.GetObject().(*cdiv1beta1.DataSource)
 
 				// Also store DS in the fake client so state.Put sees it
 				_ = cdiClient.Tracker().Add(ds)
@@ -100,6 +108,11 @@ var _ = Describe("StepCreateBootableVolume", func() {
 			action := step.Run(context.Background(), state)
 			Expect(action).To(Equal(multistep.ActionContinue))
 			Expect(state.Get("bootable_volume_name")).To(Equal("boot-dv"))
+			Expect(state.Get("bootable_volume_namespace")).To(Equal(namespace))
+			Expect(state.Get("bootable_volume_data_volume")).To(Equal("boot-dv"))
+			Expect(state.Get("bootable_volume_pvc")).To(Equal("boot-dv"))
+			Expect(ds.Spec.Source.PVC).To(Equal(&cdiv1beta1.DataVolumeSourcePVC{Name: name, Namespace: namespace}))
+			Expect(ds.Spec.Source.Snapshot).To(BeNil())
 		})
 
 		It("halts when DataVolume creation fails", func() {
@@ -109,6 +122,7 @@ var _ = Describe("StepCreateBootableVolume", func() {
 
 			action := step.Run(context.Background(), state)
 			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(HaveOccurred())
 		})
 
 		It("halts when DataVolume does not succeed", func() {
@@ -149,6 +163,218 @@ var _ = Describe("StepCreateBootableVolume", func() {
 
 			action := step.Run(context.Background(), state)
 			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(HaveOccurred())
+		})
+
+		It("clones the root disk to the output namespace", func() {
+			const outputNamespace = "golden-images"
+			step.Config.OutputNamespace = outputNamespace
+			var dvNamespace string
+			cdiClient.PrependReactor("create", "datavolumes", func(action testing.Action) (bool, runtime.Object, error) {
+				dvNamespace = action.GetNamespace()
+				dv := action.(testing.CreateAction).GetObject().(*cdiv1beta1.DataVolume)
+				dv.Namespace = outputNamespace
+				dv.Status.Phase = cdiv1beta1.Succeeded
+				return false, dv, nil
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(dvNamespace).To(Equal(outputNamespace))
+			Expect(state.Get("bootable_volume_namespace")).To(Equal(outputNamespace))
+			Expect(state.Get("bootable_volume_data_volume")).To(Equal(name))
+			Expect(state.Get("bootable_volume_pvc")).To(Equal(name + "-rootdisk"))
+
+			dv, err := cdiClient.CdiV1beta1().DataVolumes(outputNamespace).Get(context.Background(), name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dv.Spec.Source.PVC).To(Equal(&cdiv1beta1.DataVolumeSourcePVC{Name: name + "-rootdisk", Namespace: namespace}))
+
+			ds, err := cdiClient.CdiV1beta1().DataSources(outputNamespace).Get(context.Background(), name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ds.Spec.Source.PVC).To(Equal(&cdiv1beta1.DataVolumeSourcePVC{Name: name, Namespace: outputNamespace}))
+		})
+
+		Context("with output_type snapshot", func() {
+			BeforeEach(func() {
+				step.Config.OutputType = "snapshot"
+				step.Config.VolumeSnapshotClass = "csi-snapclass"
+
+				snapshotClient.PrependReactor("create", "volumesnapshots", func(action testing.Action) (bool, runtime.Object, error) {
+					snapshot := action.(testing.CreateAction).GetObject().(*snapshotv1.VolumeSnapshot)
+					if snapshot.Status == nil {
+						snapshot.Status = &snapshotv1.VolumeSnapshotStatus{ReadyToUse: ptr.To(true)}
+					}
+					return false, snapshot, nil
+				})
+			})
+
+			It("publishes a VolumeSnapshot of the root disk", func() {
+				action := step.Run(context.Background(), state)
+				Expect(action).To(Equal(multistep.ActionContinue))
+				Expect(state.Get("bootable_volume_name")).To(Equal(name))
+				Expect(state.Get("bootable_volume_snapshot")).To(Equal(name))
+				Expect(state.Get("bootable_volume_pvc")).To(Equal(name + "-rootdisk"))
+				Expect(state.Get("bootable_volume_data_volume")).To(BeNil())
+
+				snapshot, err := snapshotClient.SnapshotV1().VolumeSnapshots(namespace).Get(context.Background(), name, metav1.GetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(snapshot.Spec.Source.PersistentVolumeClaimName).To(Equal(ptr.To(name + "-rootdisk")))
+				Expect(snapshot.Spec.VolumeSnapshotClassName).To(Equal(ptr.To("csi-snapclass")))
+
+				ds, err := cdiClient.CdiV1beta1().DataSources(namespace).Get(context.Background(), name, metav1.GetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ds.Spec.Source.Snapshot).To(Equal(&cdiv1beta1.DataVolumeSourceSnapshot{Name: name, Namespace: namespace}))
+				Expect(ds.Spec.Source.PVC).To(BeNil())
+
+				dvs, err := cdiClient.CdiV1beta1().DataVolumes(namespace).List(context.Background(), metav1.ListOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dvs.Items).To(BeEmpty())
+			})
+
+			It("uses the default class without volume_snapshot_class", func() {
+				step.Config.VolumeSnapshotClass = ""
+
+				action := step.Run(context.Background(), state)
+				Expect(action).To(Equal(multistep.ActionContinue))
+
+				snapshot, err := snapshotClient.SnapshotV1().VolumeSnapshots(namespace).Get(context.Background(), name, metav1.GetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(snapshot.Spec.VolumeSnapshotClassName).To(BeNil())
+			})
+
+			It("halts when the VolumeSnapshot fails", func() {
+				snapshotClient.PrependReactor("create", "volumesnapshots", func(action testing.Action) (bool, runtime.Object, error) {
+					snapshot := action.(testing.CreateAction).GetObject().(*snapshotv1.VolumeSnapshot)
+					snapshot.Status = &snapshotv1.VolumeSnapshotStatus{
+						ReadyToUse: ptr.To(false),
+						Error:      &snapshotv1.VolumeSnapshotError{Message: ptr.To("snapshot controller failed")},
+					}
+					return false, snapshot, nil
+				})
+
+				action := step.Run(context.Background(), state)
+				Expect(action).To(Equal(multistep.ActionHalt))
+				Expect(state.Get("error")).To(MatchError(fmt.Sprintf("VolumeSnapshot (%s/%s) failed: snapshot controller failed", namespace, name)))
+			})
+
+			It("halts when the VolumeSnapshot is not ready", func() {
+				snapshotClient.PrependReactor("create", "volumesnapshots", func(action testing.Action) (bool, runtime.Object, error) {
+					snapshot := action.(testing.CreateAction).GetObject().(*snapshotv1.VolumeSnapshot)
+					snapshot.Status = &snapshotv1.VolumeSnapshotStatus{ReadyToUse: ptr.To(false)}
+					return false, snapshot, nil
+				})
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				action := step.Run(ctx, state)
+				Expect(action).To(Equal(multistep.ActionHalt))
+				Expect(state.Get("bootable_volume_name")).To(BeNil())
+			})
+		})
+	})
+
+	Context("Cleanup", func() {
+		const outputNamespace = "golden-images"
+
+		succeedDataVolumes := func() {
+			cdiClient.PrependReactor("create", "datavolumes", func(action testing.Action) (bool, runtime.Object, error) {
+				dv := action.(testing.CreateAction).GetObject().(*cdiv1beta1.DataVolume)
+				dv.Namespace = action.GetNamespace()
+				dv.Status.Phase = cdiv1beta1.Succeeded
+				return false, dv, nil
+			})
+		}
+
+		It("deletes the VolumeSnapshot when the build is cancelled", func() {
+			step.Config.OutputType = "snapshot"
+			snapshotClient.PrependReactor("create", "volumesnapshots", func(action testing.Action) (bool, runtime.Object, error) {
+				snapshot := action.(testing.CreateAction).GetObject().(*snapshotv1.VolumeSnapshot)
+				snapshot.Status = &snapshotv1.VolumeSnapshotStatus{ReadyToUse: ptr.To(false)}
+				return false, snapshot, nil
+			})
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			action := step.Run(ctx, state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			state.Put(multistep.StateCancelled, true)
+
+			step.Cleanup(state)
+
+			snapshots, err := snapshotClient.SnapshotV1().VolumeSnapshots(namespace).List(context.Background(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(snapshots.Items).To(BeEmpty())
+		})
+
+		It("deletes the DataSource when a later step fails", func() {
+			step.Config.OutputType = "snapshot"
+			snapshotClient.PrependReactor("create", "volumesnapshots", func(action testing.Action) (bool, runtime.Object, error) {
+				snapshot := action.(testing.CreateAction).GetObject().(*snapshotv1.VolumeSnapshot)
+				snapshot.Status = &snapshotv1.VolumeSnapshotStatus{ReadyToUse: ptr.To(true)}
+				return false, snapshot, nil
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			state.Put("error", fmt.Errorf("export failed"))
+
+			step.Cleanup(state)
+
+			dss, err := cdiClient.CdiV1beta1().DataSources(namespace).List(context.Background(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dss.Items).To(BeEmpty())
+			snapshots, err := snapshotClient.SnapshotV1().VolumeSnapshots(namespace).List(context.Background(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(snapshots.Items).To(BeEmpty())
+		})
+
+		It("deletes the clone in the output namespace when the DataSource cannot be created", func() {
+			step.Config.OutputNamespace = outputNamespace
+			succeedDataVolumes()
+			cdiClient.PrependReactor("create", "datasources", func(action testing.Action) (bool, runtime.Object, error) {
+				return true, nil, fmt.Errorf("boom: DS create failed")
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+
+			step.Cleanup(state)
+
+			dvs, err := cdiClient.CdiV1beta1().DataVolumes(outputNamespace).List(context.Background(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dvs.Items).To(BeEmpty())
+		})
+
+		It("deletes the DataSource and the clone in the output namespace when a later step fails", func() {
+			step.Config.OutputNamespace = outputNamespace
+			succeedDataVolumes()
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			state.Put("error", fmt.Errorf("export failed"))
+
+			step.Cleanup(state)
+
+			dss, err := cdiClient.CdiV1beta1().DataSources(outputNamespace).List(context.Background(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dss.Items).To(BeEmpty())
+			dvs, err := cdiClient.CdiV1beta1().DataVolumes(outputNamespace).List(context.Background(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dvs.Items).To(BeEmpty())
+		})
+
+		It("keeps the image when the build completes", func() {
+			succeedDataVolumes()
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			step.Cleanup(state)
+
+			_, err := cdiClient.CdiV1beta1().DataSources(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = cdiClient.CdiV1beta1().DataVolumes(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
 		})
 	})
 })
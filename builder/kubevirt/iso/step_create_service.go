@@ -0,0 +1,159 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"kubevirt.io/client-go/kubecli"
+)
+
+// StepCreateService exposes the VM through a temporary Service that the communicator connects to.
+type StepCreateService struct {
+	Config Config
+	Client kubecli.KubevirtClient
+
+	created bool
+}
+
+func (s *StepCreateService) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+	name := s.Config.Name
+	namespace := s.Config.Namespace
+	serviceType := corev1.ServiceType(s.Config.ServiceType)
+
+	port := s.Config.SSHRemotePort
+	timeout := s.Config.Comm.SSHTimeout
+	if s.Config.Comm.Type == "winrm" {
+		port = s.Config.WinRMRemotePort
+		timeout = s.Config.Comm.WinRMTimeout
+	}
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+
+	ports := []int{port}
+	for _, p := range s.Config.ServicePorts {
+		if p != port {
+			ports = append(ports, p)
+		}
+	}
+
+	ui.Sayf("Creating a new temporary %s Service (%s/%s)...", serviceType, namespace, name)
+
+	svc, err := s.Client.CoreV1().Services(namespace).Create(ctx, service(name, serviceType, ports), metav1.CreateOptions{})
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	s.created = true
+
+	ui.Sayf("Waiting for the Service (%s/%s) to get an address...", namespace, name)
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var host string
+	var hostPort int
+	poller := func(ctx context.Context) (bool, error) {
+		host, hostPort, err = s.serviceAddress(ctx, svc.Name, port)
+		if err != nil {
+			return false, err
+		}
+		return host != "", nil
+	}
+
+	if err := wait.PollUntilContextCancel(waitCtx, 5*time.Second, true, poller); err != nil {
+		if ctx.Err() != nil {
+			ui.Say("Context cancelled, stopping waiting for the Service address...")
+			return multistep.ActionHalt
+		}
+		if errors.Is(waitCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s waiting for an address of the Service (%s/%s)", timeout, namespace, name)
+		}
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Sayf("Connecting to %s:%d...", host, hostPort)
+	state.Put("communicator_host", host)
+	state.Put("communicator_port", hostPort)
+	return multistep.ActionContinue
+}
+
+// serviceAddress returns the host and port the communicator connects to, or an empty host
+// while the address is not known yet.
+func (s *StepCreateService) serviceAddress(ctx context.Context, name string, port int) (string, int, error) {
+	namespace := s.Config.Namespace
+
+	svc, err := s.Client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch svc.Spec.Type {
+	case corev1.ServiceTypeNodePort:
+		nodePort := 0
+		for _, p := range svc.Spec.Ports {
+			if int(p.Port) == port {
+				nodePort = int(p.NodePort)
+			}
+		}
+		if nodePort == 0 {
+			return "", 0, nil
+		}
+		host, err := s.launcherHostIP(ctx)
+		return host, nodePort, err
+	case corev1.ServiceTypeLoadBalancer:
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				return ingress.IP, port, nil
+			}
+			if ingress.Hostname != "" {
+				return ingress.Hostname, port, nil
+			}
+		}
+		return "", 0, nil
+	default:
+		if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone {
+			return "", 0, nil
+		}
+		return svc.Spec.ClusterIP, port, nil
+	}
+}
+
+// launcherHostIP returns the IP address of the node running the virt-launcher pod of the VM.
+func (s *StepCreateService) launcherHostIP(ctx context.Context) (string, error) {
+	pod, err := RunningLauncherPod(ctx, s.Client, s.Config.Namespace, s.Config.Name)
+	if err != nil || pod == nil {
+		return "", err
+	}
+	return pod.Status.HostIP, nil
+}
+
+func (s *StepCreateService) Cleanup(state multistep.StateBag) {
+	if !s.created {
+		return
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	name := s.Config.Name
+	namespace := s.Config.Namespace
+
+	ui.Sayf("Deleting Service (%s/%s)...", namespace, name)
+
+	_ = s.Client.CoreV1().Services(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+}
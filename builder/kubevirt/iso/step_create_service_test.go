@@ -0,0 +1,204 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	v1 "kubevirt.io/api/core/v1"
+	kubecli "kubevirt.io/client-go/kubecli"
+)
+
+var _ = Describe("StepCreateService", func() {
+	const (
+		namespace = "test-ns"
+		name      = "test-vm"
+	)
+
+	var (
+		mockCtrl   *gomock.Controller
+		kubeClient *fakek8sclient.Clientset
+		state      *multistep.BasicStateBag
+		uiErr      *strings.Builder
+		step       *iso.StepCreateService
+	)
+
+	// assignAddresses simulates the Service controller allocating the cluster IP and node ports.
+	assignAddresses := func(action k8stesting.Action) (bool, runtime.Object, error) {
+		svc := action.(k8stesting.CreateAction).GetObject().(*corev1.Service)
+		svc.Spec.ClusterIP = "10.96.0.42"
+		if svc.Spec.Type == corev1.ServiceTypeNodePort {
+			for i := range svc.Spec.Ports {
+				svc.Spec.Ports[i].NodePort = 30000 + svc.Spec.Ports[i].Port
+			}
+		}
+		return false, nil, nil
+	}
+
+	getService := func() *corev1.Service {
+		svc, err := kubeClient.CoreV1().Services(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return svc
+	}
+
+	BeforeEach(func() {
+		uiErr = &strings.Builder{}
+		ui := &packer.BasicUi{
+			Reader:      strings.NewReader(""),
+			Writer:      io.Discard,
+			ErrorWriter: uiErr,
+		}
+		state = new(multistep.BasicStateBag)
+		state.Put("ui", ui)
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		kubeClient = fakek8sclient.NewSimpleClientset()
+		kubeClient.PrependReactor("create", "services", assignAddresses)
+
+		kubecli.GetKubevirtClientFromClientConfig = kubecli.GetMockKubevirtClientFromClientConfig
+		mockVirt := kubecli.NewMockKubevirtClient(mockCtrl)
+		kubecli.MockKubevirtClientInstance = mockVirt
+		mockVirt.EXPECT().CoreV1().Return(kubeClient.CoreV1()).AnyTimes()
+		virtClient, _ := kubecli.GetKubevirtClientFromClientConfig(nil)
+
+		step = &iso.StepCreateService{
+			Config: iso.Config{
+				Name:      name,
+				Namespace: namespace,
+				Comm: communicator.Config{
+					Type: "ssh",
+					SSH:  communicator.SSH{SSHTimeout: 2 * time.Second},
+				},
+				ConnectionMode: "service",
+				ServiceType:    "ClusterIP",
+				SSHRemotePort:  22,
+			},
+			Client: virtClient,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	Context("Run", func() {
+		It("creates a labelled Service selecting the VM and connects to its cluster IP", func() {
+			step.Config.ServicePorts = []int{22, 8080}
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(state.Get("communicator_host")).To(Equal("10.96.0.42"))
+			Expect(state.Get("communicator_port")).To(Equal(22))
+
+			svc := getService()
+			Expect(svc.Labels).To(HaveKeyWithValue("app.kubernetes.io/managed-by", "packer"))
+			Expect(svc.Spec.Selector).To(Equal(map[string]string{v1.VirtualMachineNameLabel: name}))
+			Expect(svc.Spec.Ports).To(HaveLen(2))
+			Expect(svc.Spec.Ports[0].Port).To(BeEquivalentTo(22))
+			Expect(svc.Spec.Ports[1].Port).To(BeEquivalentTo(8080))
+		})
+
+		It("connects to the node port on the node running the VM", func() {
+			step.Config.Comm.Type = "winrm"
+			step.Config.ServiceType = "NodePort"
+			step.Config.WinRMRemotePort = 5985
+			step.Config.Comm.WinRMTimeout = 2 * time.Second
+
+			_, err := kubeClient.CoreV1().Pods(namespace).Create(context.Background(), &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "virt-launcher-" + name,
+					Namespace: namespace,
+					Labels: map[string]string{
+						v1.AppLabel:                "virt-launcher",
+						v1.VirtualMachineNameLabel: name,
+					},
+				},
+				Status: corev1.PodStatus{Phase: corev1.PodRunning, HostIP: "192.168.1.10"},
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(state.Get("communicator_host")).To(Equal("192.168.1.10"))
+			Expect(state.Get("communicator_port")).To(Equal(35985))
+		})
+
+		It("connects to the load balancer address", func() {
+			step.Config.ServiceType = "LoadBalancer"
+
+			kubeClient.PrependReactor("get", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				svc, err := kubeClient.Tracker().Get(corev1.SchemeGroupVersion.WithResource("services"), namespace, name)
+				if err != nil {
+					return true, nil, err
+				}
+				svc.(*corev1.Service).Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{Hostname: "lb.example.com"}}
+				return true, svc, nil
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(state.Get("communicator_host")).To(Equal("lb.example.com"))
+			Expect(state.Get("communicator_port")).To(Equal(22))
+		})
+
+		It("halts when the load balancer gets no address before the timeout", func() {
+			step.Config.ServiceType = "LoadBalancer"
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(HaveOccurred())
+			Expect(uiErr.String()).To(ContainSubstring("timed out after 2s waiting for an address of the Service"))
+		})
+
+		It("halts when the Service cannot be created", func() {
+			kubeClient.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, k8serrors.NewForbidden(corev1.Resource("services"), name, nil)
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(HaveOccurred())
+		})
+	})
+
+	Context("Cleanup", func() {
+		It("deletes the Service", func() {
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			step.Cleanup(state)
+			_, err := kubeClient.CoreV1().Services(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("does not delete a Service it did not create", func() {
+			_, err := kubeClient.CoreV1().Services(namespace).Create(context.Background(), &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			step.Cleanup(state)
+			getService()
+		})
+	})
+})
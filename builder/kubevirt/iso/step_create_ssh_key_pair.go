@@ -0,0 +1,121 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/communicator/sshkey"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kubevirt.io/client-go/kubecli"
+)
+
+// StepCreateSSHKeyPair prepares the SSH key pair of the build, either read from
+// ssh_private_key_file or generated, and stores the public key in a Secret when it is
+// propagated through the VM access credentials.
+type StepCreateSSHKeyPair struct {
+	Config Config
+	Client kubecli.KubevirtClient
+
+	secretCreated bool
+}
+
+func (s *StepCreateSSHKeyPair) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+	name := s.Config.Name
+	namespace := s.Config.Namespace
+
+	var privateKey, publicKey []byte
+	if s.Config.Comm.SSHPrivateKeyFile != "" {
+		ui.Say("Using existing SSH private key...")
+
+		var err error
+		privateKey, err = os.ReadFile(expandHome(s.Config.Comm.SSHPrivateKeyFile))
+		if err != nil {
+			err := fmt.Errorf("failed to read SSH private key: %w", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+
+		publicKey, err = sshkey.PublicKeyFromPrivate(privateKey)
+		if err != nil {
+			err := fmt.Errorf("failed to read SSH public key: %w", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	} else {
+		algorithm := sshkey.ED25519
+		if s.Config.Comm.SSHTemporaryKeyPairType != "" {
+			// The type is validated when the config is prepared.
+			algorithm, _ = sshkey.AlgorithmString(s.Config.Comm.SSHTemporaryKeyPairType)
+		}
+
+		ui.Sayf("Creating a temporary %s SSH key pair...", strings.ToUpper(algorithm.String()))
+
+		pair, err := sshkey.GeneratePair(algorithm, nil, s.Config.Comm.SSHTemporaryKeyPairBits)
+		if err != nil {
+			err := fmt.Errorf("failed to create SSH key pair: %w", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		privateKey = pair.Private
+		publicKey = pair.Public
+
+		// The SDK SSH communicator authenticates with the private key found in the state.
+		state.Put("privateKey", string(privateKey))
+	}
+
+	state.Put("ssh_public_key", string(publicKey))
+
+	generatedData := &packerbuilderdata.GeneratedData{State: state}
+	generatedData.Put("SSHPublicKey", string(publicKey))
+	generatedData.Put("SSHPrivateKey", string(privateKey))
+
+	if s.Config.SSHPublicKeyInjection != "access_credentials" {
+		return multistep.ActionContinue
+	}
+
+	secretName := sshKeySecretName(name)
+	ui.Sayf("Creating a new Secret to store the SSH public key (%s/%s)...", namespace, secretName)
+
+	_, err := s.Client.CoreV1().Secrets(namespace).Create(ctx, sshKeySecret(name, publicKey), metav1.CreateOptions{})
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	s.secretCreated = true
+	return multistep.ActionContinue
+}
+
+func (s *StepCreateSSHKeyPair) Cleanup(state multistep.StateBag) {
+	if !s.secretCreated {
+		return
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	namespace := s.Config.Namespace
+	secretName := sshKeySecretName(s.Config.Name)
+
+	// A kept VM still references the Secret in its access credentials.
+	if s.Config.KeepVM {
+		ui.Sayf("Keeping Secret (%s/%s).", namespace, secretName)
+		return
+	}
+
+	ui.Sayf("Deleting Secret (%s/%s)...", namespace, secretName)
+
+	_ = s.Client.CoreV1().Secrets(namespace).Delete(context.Background(), secretName, metav1.DeleteOptions{})
+}
@@ -0,0 +1,174 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
+	"github.com/hashicorp/packer-plugin-sdk/communicator/sshkey"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+
+	kubecli "kubevirt.io/client-go/kubecli"
+)
+
+var _ = Describe("StepCreateSSHKeyPair", func() {
+	const (
+		namespace = "test-ns"
+		name      = "test-vm"
+	)
+
+	var (
+		mockCtrl   *gomock.Controller
+		kubeClient *fakek8sclient.Clientset
+		state      *multistep.BasicStateBag
+		step       *iso.StepCreateSSHKeyPair
+	)
+
+	generatedData := func(key string) string {
+		return state.Get("generated_data").(map[string]interface{})[key].(string)
+	}
+
+	BeforeEach(func() {
+		ui := &packer.BasicUi{
+			Reader:      strings.NewReader(""),
+			Writer:      io.Discard,
+			ErrorWriter: io.Discard,
+		}
+		state = new(multistep.BasicStateBag)
+		state.Put("ui", ui)
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		kubeClient = fakek8sclient.NewSimpleClientset()
+
+		kubecli.GetKubevirtClientFromClientConfig = kubecli.GetMockKubevirtClientFromClientConfig
+		mockVirt := kubecli.NewMockKubevirtClient(mockCtrl)
+		kubecli.MockKubevirtClientInstance = mockVirt
+		mockVirt.EXPECT().CoreV1().Return(kubeClient.CoreV1()).AnyTimes()
+		virtClient, _ := kubecli.GetKubevirtClientFromClientConfig(nil)
+
+		step = &iso.StepCreateSSHKeyPair{
+			Config: iso.Config{
+				Name:      name,
+				Namespace: namespace,
+				Comm: communicator.Config{
+					Type: "ssh",
+					SSH:  communicator.SSH{SSHUsername: "user"},
+				},
+				SSHTemporaryKeyPair:   true,
+				SSHPublicKeyInjection: "none",
+			},
+			Client: virtClient,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	Context("Run", func() {
+		It("generates a temporary key pair", func() {
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			privateKey := state.Get("privateKey").(string)
+			signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(signer.PublicKey().Type()).To(Equal(ssh.KeyAlgoED25519))
+
+			publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(state.Get("ssh_public_key").(string)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(publicKey.Marshal()).To(Equal(signer.PublicKey().Marshal()))
+
+			Expect(generatedData("SSHPrivateKey")).To(Equal(privateKey))
+			Expect(generatedData("SSHPublicKey")).To(Equal(state.Get("ssh_public_key")))
+		})
+
+		It("generates a temporary key pair of temporary_key_pair_type", func() {
+			step.Config.Comm.SSHTemporaryKeyPairType = "ecdsa"
+			step.Config.Comm.SSHTemporaryKeyPairBits = 384
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			signer, err := ssh.ParsePrivateKey([]byte(state.Get("privateKey").(string)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(signer.PublicKey().Type()).To(Equal(ssh.KeyAlgoECDSA384))
+		})
+
+		It("reads the public key of ssh_private_key_file", func() {
+			pair, err := sshkey.GeneratePair(sshkey.ED25519, nil, 0)
+			Expect(err).NotTo(HaveOccurred())
+			path := filepath.Join(GinkgoT().TempDir(), "id_ed25519")
+			Expect(os.WriteFile(path, pair.Private, 0600)).To(Succeed())
+
+			step.Config.SSHTemporaryKeyPair = false
+			step.Config.Comm.SSHPrivateKeyFile = path
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(state.Get("ssh_public_key")).To(Equal(string(pair.Public)))
+			_, hasPrivateKey := state.GetOk("privateKey")
+			Expect(hasPrivateKey).To(BeFalse())
+		})
+
+		It("halts when ssh_private_key_file cannot be read", func() {
+			step.Config.SSHTemporaryKeyPair = false
+			step.Config.Comm.SSHPrivateKeyFile = filepath.Join(GinkgoT().TempDir(), "missing")
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(HaveOccurred())
+		})
+
+		It("stores the public key in a Secret for the access credentials", func() {
+			step.Config.SSHPublicKeyInjection = "access_credentials"
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			secret, err := kubeClient.CoreV1().Secrets(namespace).Get(context.Background(), name+"-ssh-key", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(secret.Data["authorized_keys"])).To(Equal(state.Get("ssh_public_key")))
+			Expect(secret.Labels).To(HaveKeyWithValue("packer.kubevirt.io/build", name))
+		})
+	})
+
+	Context("Cleanup", func() {
+		BeforeEach(func() {
+			step.Config.SSHPublicKeyInjection = "access_credentials"
+			Expect(step.Run(context.Background(), state)).To(Equal(multistep.ActionContinue))
+		})
+
+		It("deletes the Secret", func() {
+			step.Cleanup(state)
+
+			_, err := kubeClient.CoreV1().Secrets(namespace).Get(context.Background(), name+"-ssh-key", metav1.GetOptions{})
+			Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("keeps the Secret of a kept VM", func() {
+			step.Config.KeepVM = true
+			step.Cleanup(state)
+
+			_, err := kubeClient.CoreV1().Secrets(namespace).Get(context.Background(), name+"-ssh-key", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})
@@ -5,18 +5,41 @@ package iso
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"time"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
 
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/util/wait"
 	ptr "k8s.io/utils/ptr"
 
+	v1 "kubevirt.io/api/core/v1"
 	"kubevirt.io/client-go/kubecli"
+	cdiv1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
 )
 
+// provisioningFailureTimeout is how long the provisioning of the root disk may keep failing
+// before the build gives up.
+const provisioningFailureTimeout = 5 * time.Minute
+
+// failedVirtualMachineStatuses are the statuses of a VM that is not going to become ready on its own.
+var failedVirtualMachineStatuses = map[v1.VirtualMachinePrintableStatus]bool{
+	v1.VirtualMachineStatusUnschedulable:    true,
+	v1.VirtualMachineStatusPvcNotFound:      true,
+	v1.VirtualMachineStatusDataVolumeError:  true,
+	v1.VirtualMachineStatusCrashLoopBackOff: true,
+	v1.VirtualMachineStatusImagePullBackOff: true,
+	// Reported by KubeVirt releases that predate the ErrorPvcNotFound status.
+	v1.VirtualMachinePrintableStatus("ErrorDataVolumeNotFound"): true,
+}
+
 type StepCreateVirtualMachine struct {
 	Config Config
 	Client kubecli.KubevirtClient
@@ -35,9 +58,10 @@ func (s *StepCreateVirtualMachine) Run(ctx context.Context, state multistep.Stat
 	osType := s.Config.OperatingSystemType
 	networks := s.Config.Networks
 
-	if osType == "" || (osType != "linux" && osType != "windows") {
-		ui.Errorf("OS type of '%s' is not supported, set 'linux' or 'windows'.", osType)
-		return multistep.ActionHalt
+	// A guest power off must not restart the VM when it signals the end of the installation.
+	runStrategy := v1.RunStrategyAlways
+	if s.Config.InstallationCompleteOn == "shutdown" {
+		runStrategy = v1.RunStrategyRerunOnFailure
 	}
 
 	virtualMachine := virtualMachine(
@@ -50,19 +74,53 @@ func (s *StepCreateVirtualMachine) Run(ctx context.Context, state multistep.Stat
 		preferenceKind,
 		osType,
 		s.Config.StorageClassName,
-		networks)
+		networks,
+		runStrategy)
+
+	// virt-launcher must give the guest stop_timeout to shut down before killing it,
+	// instead of the default grace period of KubeVirt.
+	if s.Config.StopTimeout > 0 {
+		gracePeriod := int64(math.Ceil(s.Config.StopTimeout.Seconds()))
+		virtualMachine.Spec.Template.Spec.TerminationGracePeriodSeconds = ptr.To(gracePeriod)
+	}
+
+	if s.Config.SSHHostKeyVerification == "generated" {
+		disk, volume := sshHostKeyVolume(name)
+		virtualMachine.Spec.Template.Spec.Domain.Devices.Disks = append(virtualMachine.Spec.Template.Spec.Domain.Devices.Disks, disk)
+		virtualMachine.Spec.Template.Spec.Volumes = append(virtualMachine.Spec.Template.Spec.Volumes, volume)
+	}
+
+	if s.Config.SSHPublicKeyInjection == "access_credentials" {
+		virtualMachine.Spec.Template.Spec.AccessCredentials = []v1.AccessCredential{
+			sshAccessCredential(name, s.Config.Comm.SSHUsername),
+		}
+	}
 
 	ui.Sayf("Creating a new temporary VirtualMachine (%s/%s)...", namespace, name)
 
 	_, err := s.Client.VirtualMachine(namespace).Create(ctx, virtualMachine, metav1.CreateOptions{})
 	if err != nil {
+		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
 
 	if err := s.waitUntilVirtualMachineReady(ctx); err != nil {
+		if ctx.Err() != nil {
+			return multistep.ActionHalt
+		}
+		state.Put("error", err)
+		ui.Error(err.Error())
+		s.printDiagnostics(ui)
 		return multistep.ActionHalt
 	}
+
+	state.Put("instance_id", name)
+	generatedData := &packerbuilderdata.GeneratedData{State: state}
+	generatedData.Put("Namespace", namespace)
+	generatedData.Put("VMName", name)
+
+	putLauncherPodData(ctx, ui, s.Client, state, namespace, name)
 	return multistep.ActionContinue
 }
 
@@ -89,8 +147,14 @@ func (s *StepCreateVirtualMachine) waitUntilVirtualMachineReady(ctx context.Cont
 	namespace := s.Config.Namespace
 	pollInterval := 5 * time.Second
 	pollTimeout := 3600 * time.Second
+	// A transient error is retried and only reported if the VM is not ready before the timeout.
+	var lastErr error
 	poller := func(ctx context.Context) (bool, error) {
 		vm, err := s.Client.VirtualMachine(namespace).Get(ctx, name, metav1.GetOptions{})
+		if isTransientError(err) {
+			lastErr = fmt.Errorf("failed to get VirtualMachine (%s/%s): %w", namespace, name, err)
+			return false, nil
+		}
 		if err != nil {
 			return false, err
 		}
@@ -98,8 +162,150 @@ func (s *StepCreateVirtualMachine) waitUntilVirtualMachineReady(ctx context.Cont
 		if vm.Status.Ready {
 			return true, nil
 		}
-		return false, nil
+
+		if failedVirtualMachineStatuses[vm.Status.PrintableStatus] {
+			return false, fmt.Errorf("VirtualMachine (%s/%s) failed to start: %s", namespace, name, vm.Status.PrintableStatus)
+		}
+		err = s.checkRootDiskBinding(ctx)
+		if isTransientError(err) {
+			lastErr = err
+			return false, nil
+		}
+		return false, err
+	}
+
+	err := wait.PollUntilContextTimeout(ctx, pollInterval, pollTimeout, true, poller)
+	if err != nil && ctx.Err() == nil && lastErr != nil {
+		return fmt.Errorf("%w, last error: %w", err, lastErr)
+	}
+	return err
+}
+
+// checkRootDiskBinding returns an error if the root disk of the VM cannot be provisioned.
+// The provisioner retries after a failure, so that only failures that persist for
+// provisioningFailureTimeout are reported.
+func (s *StepCreateVirtualMachine) checkRootDiskBinding(ctx context.Context) error {
+	namespace := s.Config.Namespace
+	rootDiskName := s.Config.Name + "-rootdisk"
+
+	dv, err := s.Client.CdiClient().CdiV1beta1().DataVolumes(namespace).Get(ctx, rootDiskName, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get DataVolume (%s/%s): %w", namespace, rootDiskName, err)
+	}
+	if err == nil && dv.Status.Phase == cdiv1.Failed {
+		return fmt.Errorf("DataVolume (%s/%s) failed", namespace, rootDiskName)
+	}
+
+	pvc, err := s.Client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, rootDiskName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		// The PVC is not created yet.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get PersistentVolumeClaim (%s/%s): %w", namespace, rootDiskName, err)
+	}
+
+	switch pvc.Status.Phase {
+	case corev1.ClaimLost:
+		return fmt.Errorf("PersistentVolumeClaim (%s/%s) lost its PersistentVolume", namespace, rootDiskName)
+	case corev1.ClaimPending:
+		events, err := s.events(ctx, rootDiskName)
+		if err != nil {
+			return fmt.Errorf("failed to list the events of PersistentVolumeClaim (%s/%s): %w", namespace, rootDiskName, err)
+		}
+
+		var failingSince, lastFailure time.Time
+		var message string
+		for _, event := range events {
+			if event.Reason != "ProvisioningFailed" {
+				continue
+			}
+			first, last := eventTimes(event)
+			if failingSince.IsZero() || first.Before(failingSince) {
+				failingSince = first
+			}
+			if !last.Before(lastFailure) {
+				lastFailure = last
+				message = event.Message
+			}
+		}
+		if !failingSince.IsZero() && time.Since(failingSince) >= provisioningFailureTimeout {
+			return fmt.Errorf("PersistentVolumeClaim (%s/%s) cannot be provisioned: %s", namespace, rootDiskName, message)
+		}
+	}
+	return nil
+}
+
+// eventTimes returns when the event, or the series of the event, was first and last seen.
+func eventTimes(event corev1.Event) (time.Time, time.Time) {
+	first := event.FirstTimestamp.Time
+	if first.IsZero() {
+		first = event.EventTime.Time
+	}
+	if first.IsZero() {
+		first = event.CreationTimestamp.Time
 	}
 
-	return wait.PollUntilContextTimeout(ctx, pollInterval, pollTimeout, true, poller)
+	last := event.LastTimestamp.Time
+	if event.Series != nil && last.IsZero() {
+		last = event.Series.LastObservedTime.Time
+	}
+	if last.IsZero() {
+		last = first
+	}
+	return first, last
+}
+
+// printDiagnostics shows the VM conditions and the warning events of the VM,
+// its launcher pod and its root disk to help understand why the VM is not ready.
+// They are collected with a short timeout, so that an unresponsive API server does
+// not keep the build from failing.
+func (s *StepCreateVirtualMachine) printDiagnostics(ui packer.Ui) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	name := s.Config.Name
+	namespace := s.Config.Namespace
+
+	vm, err := s.Client.VirtualMachine(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		for _, condition := range vm.Status.Conditions {
+			if condition.Message != "" {
+				ui.Errorf("VirtualMachine condition %s (%s): %s", condition.Type, condition.Reason, condition.Message)
+			}
+		}
+	}
+
+	objectNames := []string{name, name + "-rootdisk"}
+	pods, err := s.Client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: v1.VirtualMachineNameLabel + "=" + name,
+	})
+	if err == nil {
+		for _, pod := range pods.Items {
+			objectNames = append(objectNames, pod.Name)
+		}
+	}
+
+	for _, objectName := range objectNames {
+		events, err := s.events(ctx, objectName)
+		if err != nil {
+			continue
+		}
+		for _, event := range events {
+			if event.Type == corev1.EventTypeWarning {
+				ui.Errorf("%s %s (%s): %s", event.InvolvedObject.Kind, event.InvolvedObject.Name, event.Reason, event.Message)
+			}
+		}
+	}
+}
+
+// events returns the events of the object with the given name in the build namespace.
+func (s *StepCreateVirtualMachine) events(ctx context.Context, objectName string) ([]corev1.Event, error) {
+	events, err := s.Client.CoreV1().Events(s.Config.Namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("involvedObject.name", objectName).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events.Items, nil
 }
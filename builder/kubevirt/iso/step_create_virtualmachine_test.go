@@ -5,9 +5,11 @@ package iso_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
@@ -17,8 +19,11 @@ import (
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
 
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	fakek8sclient "k8s.io/client-go/kubernetes/fake"
 	k8stesting "k8s.io/client-go/testing"
 	v1 "kubevirt.io/api/core/v1"
@@ -40,13 +45,14 @@ var _ = Describe("StepCreateVirtualMachine", func() {
 		virtClient kubecli.KubevirtClient
 		vmClient   *kubevirtfake.Clientset
 		state      *multistep.BasicStateBag
+		uiErr      *strings.Builder
 		step       *iso.StepCreateVirtualMachine
 	)
 
 	BeforeEach(func() {
 		ctrl = gomock.NewController(GinkgoT())
 
-		uiErr := &strings.Builder{}
+		uiErr = &strings.Builder{}
 		ui := &packer.BasicUi{
 			Reader:      strings.NewReader(""),
 			Writer:      io.Discard,
@@ -93,12 +99,6 @@ var _ = Describe("StepCreateVirtualMachine", func() {
 	})
 
 	Context("Run", func() {
-		It("halts when OS type is unsupported", func() {
-			step.Config.OperatingSystemType = "bsd"
-			action := step.Run(context.Background(), state)
-			Expect(action).To(Equal(multistep.ActionHalt))
-		})
-
 		It("continues when VM is created and becomes Ready", func() {
 			// Let Run create the VM, then mark it Ready
 			ctx, cancel := context.WithCancel(context.Background())
@@ -117,6 +117,161 @@ var _ = Describe("StepCreateVirtualMachine", func() {
 			Expect(action).To(Equal(multistep.ActionContinue))
 		})
 
+		It("keeps waiting when the VM cannot be read for a moment", func() {
+			vmClient.Fake.PrependReactor("create", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				obj := action.(k8stesting.CreateAction).GetObject().(*v1.VirtualMachine)
+				obj.Status.Ready = true
+				return false, obj, nil
+			})
+			failures := 0
+			vmClient.Fake.PrependReactor("get", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if failures == 0 {
+					failures++
+					return true, nil, k8serrors.NewServerTimeout(schema.GroupResource{Resource: "virtualmachines"}, "get", 1)
+				}
+				return false, nil, nil
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(failures).To(Equal(1))
+		})
+
+		It("adds the VM and its launcher pod to the generated data", func() {
+			vmClient.Fake.PrependReactor("create", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				create := action.(k8stesting.CreateAction)
+				obj := create.GetObject().(*v1.VirtualMachine)
+				obj.Status.Ready = true
+				return false, obj, nil
+			})
+
+			_, err := kubeClient.CoreV1().Pods(namespace).Create(context.Background(), &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "virt-launcher-" + name,
+					Namespace: namespace,
+					Labels: map[string]string{
+						v1.AppLabel:                "virt-launcher",
+						v1.VirtualMachineNameLabel: name,
+					},
+				},
+				Spec:   corev1.PodSpec{NodeName: "worker-1"},
+				Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.244.1.7"},
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(state.Get("instance_id")).To(Equal(name))
+			Expect(state.Get("generated_data")).To(Equal(map[string]interface{}{
+				"Namespace": namespace,
+				"VMName":    name,
+				"PodIP":     "10.244.1.7",
+				"NodeName":  "worker-1",
+			}))
+		})
+
+		It("continues with an empty launcher pod data when the pods cannot be listed", func() {
+			vmClient.Fake.PrependReactor("create", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				create := action.(k8stesting.CreateAction)
+				obj := create.GetObject().(*v1.VirtualMachine)
+				obj.Status.Ready = true
+				return false, obj, nil
+			})
+			kubeClient.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, k8serrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", errors.New("RBAC"))
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(state.Get("generated_data")).To(Equal(map[string]interface{}{
+				"Namespace": namespace,
+				"VMName":    name,
+				"PodIP":     "",
+				"NodeName":  "",
+			}))
+		})
+
+		It("creates the VM with the RerunOnFailure run strategy when installation completes on shutdown", func() {
+			step.Config.InstallationCompleteOn = "shutdown"
+
+			vmClient.Fake.PrependReactor("create", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				create := action.(k8stesting.CreateAction)
+				obj := create.GetObject().(*v1.VirtualMachine)
+				obj.Status.Ready = true
+				return false, obj, nil
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			vm, err := vmClient.KubevirtV1().VirtualMachines(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*vm.Spec.RunStrategy).To(Equal(v1.RunStrategyRerunOnFailure))
+		})
+
+		It("propagates the SSH public key through the access credentials", func() {
+			step.Config.Comm.SSHUsername = "cloud-user"
+			step.Config.SSHPublicKeyInjection = "access_credentials"
+
+			vmClient.Fake.PrependReactor("create", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				create := action.(k8stesting.CreateAction)
+				obj := create.GetObject().(*v1.VirtualMachine)
+				obj.Status.Ready = true
+				return false, obj, nil
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			vm, err := vmClient.KubevirtV1().VirtualMachines(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vm.Labels).To(HaveKeyWithValue("app.kubernetes.io/managed-by", "packer"))
+			Expect(vm.Spec.Template.Spec.AccessCredentials).To(HaveLen(1))
+			credential := vm.Spec.Template.Spec.AccessCredentials[0].SSHPublicKey
+			Expect(credential.Source.Secret.SecretName).To(Equal(name + "-ssh-key"))
+			Expect(credential.PropagationMethod.QemuGuestAgent.Users).To(ConsistOf("cloud-user"))
+		})
+
+		It("attaches the Secret of the generated SSH host key", func() {
+			step.Config.SSHHostKeyVerification = "generated"
+
+			vmClient.Fake.PrependReactor("create", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				create := action.(k8stesting.CreateAction)
+				obj := create.GetObject().(*v1.VirtualMachine)
+				obj.Status.Ready = true
+				return false, obj, nil
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			vm, err := vmClient.KubevirtV1().VirtualMachines(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vm.Spec.Template.Spec.Domain.Devices.Disks).To(ContainElement(HaveField("Name", "sshhostkey")))
+			Expect(vm.Spec.Template.Spec.Volumes).To(ContainElement(HaveField("Secret", &v1.SecretVolumeSource{
+				SecretName:  name + "-ssh-host-key",
+				VolumeLabel: "SSHHOSTKEY",
+			})))
+		})
+
+		It("gives the guest stop_timeout to shut down", func() {
+			step.Config.StopTimeout = 10*time.Minute + 500*time.Millisecond
+
+			vmClient.Fake.PrependReactor("create", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				create := action.(k8stesting.CreateAction)
+				obj := create.GetObject().(*v1.VirtualMachine)
+				obj.Status.Ready = true
+				return false, obj, nil
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			vm, err := vmClient.KubevirtV1().VirtualMachines(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vm.Spec.Template.Spec.TerminationGracePeriodSeconds).To(HaveValue(Equal(int64(601))))
+		})
+
 		It("halts when VM creation fails", func() {
 			// Inject error into fake client
 			vmClient.Fake.PrependReactor("create", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
@@ -125,6 +280,136 @@ var _ = Describe("StepCreateVirtualMachine", func() {
 
 			action := step.Run(context.Background(), state)
 			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(HaveOccurred())
+		})
+
+		It("halts with diagnostics when VM cannot be scheduled", func() {
+			vmClient.Fake.PrependReactor("create", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				create := action.(k8stesting.CreateAction)
+				obj := create.GetObject().(*v1.VirtualMachine)
+				obj.Status.PrintableStatus = v1.VirtualMachineStatusUnschedulable
+				obj.Status.Conditions = []v1.VirtualMachineCondition{
+					{
+						Type:    v1.VirtualMachineConditionType(corev1.PodScheduled),
+						Status:  corev1.ConditionFalse,
+						Reason:  "Unschedulable",
+						Message: "0/3 nodes are available: 3 Insufficient memory.",
+					},
+				}
+				return false, obj, nil
+			})
+
+			_, err := kubeClient.CoreV1().Events(namespace).Create(context.Background(), &corev1.Event{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name + ".event",
+					Namespace: namespace,
+				},
+				InvolvedObject: corev1.ObjectReference{
+					Kind: "VirtualMachineInstance",
+					Name: name,
+				},
+				Type:    corev1.EventTypeWarning,
+				Reason:  "FailedScheduling",
+				Message: "no nodes available to schedule pods",
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(HaveOccurred())
+			Expect(uiErr.String()).To(ContainSubstring("failed to start: ErrorUnschedulable"))
+			Expect(uiErr.String()).To(ContainSubstring("3 Insufficient memory"))
+			Expect(uiErr.String()).To(ContainSubstring("no nodes available to schedule pods"))
+		})
+
+		It("halts when the root disk cannot be provisioned", func() {
+			_, err := kubeClient.CoreV1().PersistentVolumeClaims(namespace).Create(context.Background(), &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name + "-rootdisk",
+					Namespace: namespace,
+				},
+				Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending},
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = kubeClient.CoreV1().Events(namespace).Create(context.Background(), &corev1.Event{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name + "-rootdisk.event",
+					Namespace: namespace,
+				},
+				InvolvedObject: corev1.ObjectReference{
+					Kind: "PersistentVolumeClaim",
+					Name: name + "-rootdisk",
+				},
+				Type:           corev1.EventTypeWarning,
+				Reason:         "ProvisioningFailed",
+				Message:        `storageclass.storage.k8s.io "missing" not found`,
+				FirstTimestamp: metav1.NewTime(time.Now().Add(-10 * time.Minute)),
+				LastTimestamp:  metav1.NewTime(time.Now().Add(-time.Minute)),
+				Count:          12,
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(uiErr.String()).To(ContainSubstring("cannot be provisioned"))
+		})
+
+		It("keeps waiting while the provisioning of the root disk is retried", func() {
+			_, err := kubeClient.CoreV1().PersistentVolumeClaims(namespace).Create(context.Background(), &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name + "-rootdisk",
+					Namespace: namespace,
+				},
+				Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending},
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = kubeClient.CoreV1().Events(namespace).Create(context.Background(), &corev1.Event{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name + "-rootdisk.event",
+					Namespace: namespace,
+				},
+				InvolvedObject: corev1.ObjectReference{
+					Kind: "PersistentVolumeClaim",
+					Name: name + "-rootdisk",
+				},
+				Type:           corev1.EventTypeWarning,
+				Reason:         "ProvisioningFailed",
+				Message:        "rpc error: code = DeadlineExceeded desc = context deadline exceeded",
+				FirstTimestamp: metav1.NewTime(time.Now().Add(-30 * time.Second)),
+				LastTimestamp:  metav1.NewTime(time.Now().Add(-30 * time.Second)),
+				Count:          1,
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			action := step.Run(ctx, state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(uiErr.String()).NotTo(ContainSubstring("cannot be provisioned"))
+		})
+
+		It("halts when the root disk cannot be inspected", func() {
+			kubeClient.PrependReactor("get", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, k8serrors.NewForbidden(schema.GroupResource{Resource: "persistentvolumeclaims"}, name+"-rootdisk", errors.New("access denied"))
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(ContainSubstring("failed to get PersistentVolumeClaim (test-ns/test-vm-rootdisk)")))
+			Expect(k8serrors.IsForbidden(errors.Unwrap(state.Get("error").(error)))).To(BeTrue())
+		})
+
+		It("halts quietly when the build is cancelled while waiting for the VM", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(200*time.Millisecond, cancel)
+
+			action := step.Run(ctx, state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(BeNil())
+			Expect(uiErr.String()).To(BeEmpty())
 		})
 
 		It("halts when VM never becomes Ready", func() {
@@ -0,0 +1,390 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/common"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	exportv1 "kubevirt.io/api/export/v1beta1"
+	"kubevirt.io/client-go/kubecli"
+)
+
+// exportTokenHeader is the header the export server reads the token from.
+const exportTokenHeader = "x-kubevirt-export-token"
+
+// StepExportVolume downloads the disk of the image to export_path, through a
+// VirtualMachineExport of its PersistentVolumeClaim. The export server is reached
+// through a port forward to its pod.
+type StepExportVolume struct {
+	Config        Config
+	Client        kubecli.KubevirtClient
+	ForwarderFunc ExportPortForwarderFactory
+
+	forwarder PortForwarder
+	created   bool
+}
+
+// ExportPortForwarderFactory returns a port forwarder to the export server pod.
+type ExportPortForwarderFactory func(client kubecli.KubevirtClient, namespace, pod string) PortForwarder
+
+func (s *StepExportVolume) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+	name := s.Config.Name
+	namespace := s.Config.Namespace
+	path := expandHome(s.Config.ExportPath)
+
+	pvcName, ok := state.Get("bootable_volume_pvc").(string)
+	if !ok || pvcName == "" {
+		err := errors.New("bootable volume PVC not found in state")
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Sayf("Creating a VirtualMachineExport of the PersistentVolumeClaim (%s/%s)...", namespace, pvcName)
+
+	_, err = s.Client.CoreV1().Secrets(namespace).Create(ctx, exportTokenSecret(name, token), metav1.CreateOptions{})
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	s.created = true
+
+	_, err = s.Client.VirtualMachineExport(namespace).Create(ctx, virtualMachineExport(name, pvcName), metav1.CreateOptions{})
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	link, err := s.waitUntilExportReady(ctx, pvcName)
+	if err != nil {
+		if ctx.Err() != nil {
+			ui.Say("Context cancelled, stopping waiting for the export server...")
+			return multistep.ActionHalt
+		}
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	addr, err := s.startForwarding(ctx, link.serviceName)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Sayf("Downloading the %s disk image to %s...", s.Config.ExportFormat, path)
+	checksum, err := downloadExport(ctx, ui, link.url, link.cert, token, addr, path, s.Config.ExportFormat)
+	if err != nil {
+		if ctx.Err() != nil {
+			ui.Say("Context cancelled, stopping the download of the disk image...")
+			return multistep.ActionHalt
+		}
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Sayf("Downloaded the disk image to %s (sha256 %s).", path, checksum)
+	state.Put("export_path", path)
+	state.Put("export_format", s.Config.ExportFormat)
+	state.Put("export_sha256", checksum)
+	return multistep.ActionContinue
+}
+
+func (s *StepExportVolume) Cleanup(state multistep.StateBag) {
+	ui := state.Get("ui").(packer.Ui)
+	name := s.Config.Name
+	namespace := s.Config.Namespace
+
+	if s.forwarder != nil {
+		s.forwarder.Stop()
+		s.forwarder = nil
+	}
+
+	if !s.created {
+		return
+	}
+
+	ui.Sayf("Deleting the VirtualMachineExport (%s/%s)...", namespace, exportName(name))
+
+	err := s.Client.VirtualMachineExport(namespace).Delete(context.Background(), exportName(name), metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		ui.Error(err.Error())
+	}
+
+	err = s.Client.CoreV1().Secrets(namespace).Delete(context.Background(), exportTokenSecretName(name), metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		ui.Error(err.Error())
+	}
+	s.created = false
+}
+
+// exportLink is the internal URL of the exported volume, and how to reach it.
+type exportLink struct {
+	url         string
+	cert        string
+	serviceName string
+}
+
+// waitUntilExportReady waits until the export server is ready, and returns the internal
+// link of the volume in export_format.
+func (s *StepExportVolume) waitUntilExportReady(ctx context.Context, pvcName string) (*exportLink, error) {
+	namespace := s.Config.Namespace
+	name := exportName(s.Config.Name)
+	pollInterval := 5 * time.Second
+
+	waitCtx, cancel := context.WithTimeout(ctx, s.Config.ExportTimeout)
+	defer cancel()
+
+	var link *exportLink
+	poller := func(ctx context.Context) (bool, error) {
+		export, err := s.Client.VirtualMachineExport(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		status := export.Status
+		if status == nil || status.Phase != exportv1.Ready || status.Links == nil || status.Links.Internal == nil {
+			return false, nil
+		}
+		for _, volume := range status.Links.Internal.Volumes {
+			if volume.Name != pvcName {
+				continue
+			}
+			for _, format := range volume.Formats {
+				if string(format.Format) == s.Config.ExportFormat {
+					link = &exportLink{
+						url:         format.Url,
+						cert:        status.Links.Internal.Cert,
+						serviceName: status.ServiceName,
+					}
+					return true, nil
+				}
+			}
+			return false, fmt.Errorf("VirtualMachineExport %s/%s does not serve the volume in the %s format", namespace, name, s.Config.ExportFormat)
+		}
+		return false, nil
+	}
+
+	if err := wait.PollUntilContextCancel(waitCtx, pollInterval, true, poller); err != nil {
+		if errors.Is(waitCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			return nil, fmt.Errorf("timed out after %s waiting for the VirtualMachineExport %s/%s to be ready", s.Config.ExportTimeout, namespace, name)
+		}
+		return nil, err
+	}
+	return link, nil
+}
+
+// startForwarding forwards a local port to the export server pod behind the service, and
+// returns the local address.
+func (s *StepExportVolume) startForwarding(ctx context.Context, serviceName string) (*net.TCPAddr, error) {
+	namespace := s.Config.Namespace
+
+	svc, err := s.Client.CoreV1().Services(namespace).Get(ctx, serviceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(svc.Spec.Ports) == 0 {
+		return nil, fmt.Errorf("service %s/%s of the export server has no port", namespace, serviceName)
+	}
+	remotePort := svc.Spec.Ports[0].TargetPort.IntValue()
+	if remotePort == 0 {
+		remotePort = int(svc.Spec.Ports[0].Port)
+	}
+
+	pods, err := s.Client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(svc.Spec.Selector).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var pod *corev1.Pod
+	for i := range pods.Items {
+		if pods.Items[i].Status.Phase == corev1.PodRunning {
+			pod = &pods.Items[i]
+			break
+		}
+	}
+	if pod == nil {
+		return nil, fmt.Errorf("no running export server pod found for service %s/%s", namespace, serviceName)
+	}
+
+	factory := s.ForwarderFunc
+	if factory == nil {
+		factory = DefaultExportPortForwarder
+	}
+	forwarder := factory(s.Client, namespace, pod.Name)
+
+	address, _ := net.ResolveIPAddr("", "127.0.0.1")
+	addr, err := forwarder.StartForwarding(context.WithoutCancel(ctx), address, common.ForwardedPort{
+		Local:    0,
+		Remote:   remotePort,
+		Protocol: common.ProtocolTCP,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.forwarder = forwarder
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("unexpected port forwarding address %v", addr)
+	}
+	return tcpAddr, nil
+}
+
+// DefaultExportPortForwarder forwards to the pod through the API server of the client.
+func DefaultExportPortForwarder(client kubecli.KubevirtClient, namespace, pod string) PortForwarder {
+	return &common.PodPortForwarder{
+		Config:    client.Config(),
+		Namespace: namespace,
+		Name:      pod,
+	}
+}
+
+// downloadExport downloads the exported volume from the internal URL through the forwarded
+// address, and writes it to path with a ".sha256" checksum file. It verifies the size of the
+// download and the integrity of gzip images, and returns the SHA-256 of the file.
+func downloadExport(ctx context.Context, ui packer.Ui, url, cert, token string, addr *net.TCPAddr, path, format string) (string, error) {
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM([]byte(cert)) {
+		return "", errors.New("failed to parse the certificate of the export server")
+	}
+
+	// The URL keeps the service host name, which the certificate is issued for.
+	dialer := &net.Dialer{}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr.String())
+			},
+			TLSClientConfig: &tls.Config{
+				RootCAs:    rootCAs,
+				MinVersion: tls.VersionTLS12,
+			},
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(exportTokenHeader, token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download the disk image: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download the disk image: %s", resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	partPath := path + ".part"
+	f, err := os.Create(partPath)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(partPath)
+
+	body := ui.TrackProgress(filepath.Base(path), 0, resp.ContentLength, resp.Body)
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hash), body)
+	body.Close()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to download the disk image: %w", err)
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return "", fmt.Errorf("downloaded %d of %d bytes of the disk image", n, resp.ContentLength)
+	}
+	if format == string(exportv1.KubeVirtGz) {
+		if err := verifyGzip(partPath); err != nil {
+			return "", fmt.Errorf("downloaded disk image is corrupted: %w", err)
+		}
+	}
+
+	if err := os.Rename(partPath, path); err != nil {
+		return "", err
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if err := writeChecksumFile(path, checksum); err != nil {
+		return "", err
+	}
+	return checksum, nil
+}
+
+// verifyGzip reads the gzip file to the end, which checks the CRC-32 and size of its content.
+func verifyGzip(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	return r.Close()
+}
+
+// writeChecksumFile writes the checksum of the file to path + ".sha256", in the format of
+// sha256sum so that 'sha256sum -c' verifies it.
+func writeChecksumFile(path, checksum string) error {
+	content := fmt.Sprintf("%s  %s\n", checksum, filepath.Base(path))
+	return os.WriteFile(path+".sha256", []byte(content), 0644)
+}
+
+// randomToken returns a random token for the export server.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
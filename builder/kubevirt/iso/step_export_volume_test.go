@@ -0,0 +1,309 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso_test
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/common"
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	exportv1 "kubevirt.io/api/export/v1beta1"
+	kubecli "kubevirt.io/client-go/kubecli"
+	kubevirtfake "kubevirt.io/client-go/kubevirt/fake"
+)
+
+// exportServerForwarder forwards to a local test server instead of the export server pod.
+type exportServerForwarder struct {
+	addr    net.Addr
+	pod     string
+	remote  int
+	stopped bool
+}
+
+func (f *exportServerForwarder) StartForwarding(ctx context.Context, address *net.IPAddr, port common.ForwardedPort) (net.Addr, error) {
+	f.remote = port.Remote
+	return f.addr, nil
+}
+
+func (f *exportServerForwarder) Stop() {
+	f.stopped = true
+}
+
+var _ = Describe("StepExportVolume", func() {
+	const (
+		namespace = "test-ns"
+		name      = "test-vm"
+		disk      = "bootable disk content"
+	)
+
+	var (
+		mockCtrl   *gomock.Controller
+		kubeClient *fakek8sclient.Clientset
+		vmClient   *kubevirtfake.Clientset
+		state      *multistep.BasicStateBag
+		uiErr      *strings.Builder
+		step       *iso.StepExportVolume
+		forwarder  *exportServerForwarder
+		server     *httptest.Server
+		exportPath string
+		formats    []exportv1.VirtualMachineExportVolumeFormat
+	)
+
+	BeforeEach(func() {
+		uiErr = &strings.Builder{}
+		ui := &packer.BasicUi{
+			Reader:      strings.NewReader(""),
+			Writer:      io.Discard,
+			ErrorWriter: uiErr,
+			PB:          &packer.NoopProgressTracker{},
+		}
+		state = new(multistep.BasicStateBag)
+		state.Put("ui", ui)
+		state.Put("bootable_volume_pvc", name)
+
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secret, err := kubeClient.CoreV1().Secrets(namespace).Get(r.Context(), name+"-export-token", metav1.GetOptions{})
+			if err != nil || r.Header.Get("x-kubevirt-export-token") != secret.StringData["token"] {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			switch r.URL.Path {
+			case "/volumes/test-vm/disk.img":
+				_, _ = io.WriteString(w, disk)
+			case "/volumes/test-vm/disk.img.gz":
+				gz := gzip.NewWriter(w)
+				_, _ = io.WriteString(gz, disk)
+				_ = gz.Close()
+			case "/volumes/test-vm/corrupted.img.gz":
+				_, _ = io.WriteString(w, "not gzip")
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		DeferCleanup(server.Close)
+		cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+		// The certificate of the test server is issued for example.com.
+		formats = []exportv1.VirtualMachineExportVolumeFormat{
+			{Format: exportv1.KubeVirtRaw, Url: "https://example.com/volumes/test-vm/disk.img"},
+			{Format: exportv1.KubeVirtGz, Url: "https://example.com/volumes/test-vm/disk.img.gz"},
+		}
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		kubeClient = fakek8sclient.NewSimpleClientset(
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "virt-export-" + name + "-export", Namespace: namespace},
+				Spec: corev1.ServiceSpec{
+					Selector: map[string]string{"kubevirt.io.virt-export-service": "virt-export-" + name + "-export"},
+					Ports:    []corev1.ServicePort{{Port: 443, TargetPort: intstr.FromInt32(8443)}},
+				},
+			},
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "virt-export-" + name + "-export",
+					Namespace: namespace,
+					Labels:    map[string]string{"kubevirt.io.virt-export-service": "virt-export-" + name + "-export"},
+				},
+				Status: corev1.PodStatus{Phase: corev1.PodRunning},
+			},
+		)
+
+		// Stands in for the export controller, which makes the export ready.
+		vmClient = kubevirtfake.NewSimpleClientset()
+		vmClient.PrependReactor("create", "virtualmachineexports", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			export := action.(k8stesting.CreateAction).GetObject().(*exportv1.VirtualMachineExport)
+			export.Status = &exportv1.VirtualMachineExportStatus{
+				Phase:       exportv1.Ready,
+				ServiceName: "virt-export-" + export.Name,
+				Links: &exportv1.VirtualMachineExportLinks{
+					Internal: &exportv1.VirtualMachineExportLink{
+						Cert:    string(cert),
+						Volumes: []exportv1.VirtualMachineExportVolume{{Name: name, Formats: formats}},
+					},
+				},
+			}
+			return false, nil, nil
+		})
+
+		kubecli.GetKubevirtClientFromClientConfig = kubecli.GetMockKubevirtClientFromClientConfig
+		mockVirt := kubecli.NewMockKubevirtClient(mockCtrl)
+		kubecli.MockKubevirtClientInstance = mockVirt
+		mockVirt.EXPECT().CoreV1().Return(kubeClient.CoreV1()).AnyTimes()
+		mockVirt.EXPECT().VirtualMachineExport(namespace).Return(vmClient.ExportV1beta1().VirtualMachineExports(namespace)).AnyTimes()
+		virtClient, _ := kubecli.GetKubevirtClientFromClientConfig(nil)
+
+		exportPath = filepath.Join(GinkgoT().TempDir(), "output", "test-vm.img")
+		forwarder = &exportServerForwarder{addr: server.Listener.Addr()}
+		step = &iso.StepExportVolume{
+			Config: iso.Config{
+				Name:          name,
+				Namespace:     namespace,
+				ExportPath:    exportPath,
+				ExportFormat:  "raw",
+				ExportTimeout: time.Second,
+			},
+			Client: virtClient,
+			ForwarderFunc: func(client kubecli.KubevirtClient, namespace, pod string) iso.PortForwarder {
+				forwarder.pod = pod
+				return forwarder
+			},
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	Context("Run", func() {
+		It("downloads the raw disk image through the export server pod", func() {
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(forwarder.pod).To(Equal("virt-export-test-vm-export"))
+			Expect(forwarder.remote).To(Equal(8443))
+
+			content, err := os.ReadFile(exportPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(disk))
+			Expect(exportPath + ".part").NotTo(BeAnExistingFile())
+
+			sum := sha256.Sum256([]byte(disk))
+			checksum := hex.EncodeToString(sum[:])
+			Expect(state.Get("export_path")).To(Equal(exportPath))
+			Expect(state.Get("export_format")).To(Equal("raw"))
+			Expect(state.Get("export_sha256")).To(Equal(checksum))
+
+			checksumFile, err := os.ReadFile(exportPath + ".sha256")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(checksumFile)).To(Equal(fmt.Sprintf("%s  test-vm.img\n", checksum)))
+		})
+
+		It("exports the PersistentVolumeClaim with a token Secret", func() {
+			Expect(step.Run(context.Background(), state)).To(Equal(multistep.ActionContinue))
+
+			export, err := vmClient.ExportV1beta1().VirtualMachineExports(namespace).Get(context.Background(), name+"-export", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(export.Spec.Source.Kind).To(Equal("PersistentVolumeClaim"))
+			Expect(export.Spec.Source.Name).To(Equal(name))
+			Expect(export.Spec.TokenSecretRef).To(HaveValue(Equal(name + "-export-token")))
+			Expect(export.Labels).To(HaveKeyWithValue("packer.kubevirt.io/build", name))
+		})
+
+		It("downloads the gzip disk image", func() {
+			step.Config.ExportFormat = "gzip"
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			f, err := os.Open(exportPath)
+			Expect(err).NotTo(HaveOccurred())
+			defer f.Close()
+			r, err := gzip.NewReader(f)
+			Expect(err).NotTo(HaveOccurred())
+			content, err := io.ReadAll(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(disk))
+		})
+
+		It("halts when the gzip disk image is corrupted", func() {
+			step.Config.ExportFormat = "gzip"
+			formats[1].Url = "https://example.com/volumes/test-vm/corrupted.img.gz"
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(ContainSubstring("downloaded disk image is corrupted")))
+			Expect(exportPath).NotTo(BeAnExistingFile())
+		})
+
+		It("halts when the export server rejects the download", func() {
+			formats[0].Url = "https://example.com/volumes/unknown/disk.img"
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(ContainSubstring("404 Not Found")))
+			Expect(uiErr.String()).To(ContainSubstring("failed to download the disk image"))
+		})
+
+		It("halts when the export is not ready before the timeout", func() {
+			vmClient.PrependReactor("get", "virtualmachineexports", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, &exportv1.VirtualMachineExport{
+					ObjectMeta: metav1.ObjectMeta{Name: name + "-export", Namespace: namespace},
+					Status:     &exportv1.VirtualMachineExportStatus{Phase: exportv1.Pending},
+				}, nil
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError("timed out after 1s waiting for the VirtualMachineExport test-ns/test-vm-export to be ready"))
+		})
+
+		It("halts when the export does not serve export_format", func() {
+			formats = formats[:1]
+			step.Config.ExportFormat = "gzip"
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(ContainSubstring("does not serve the volume in the gzip format")))
+		})
+
+		It("halts without an error when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			action := step.Run(ctx, state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			_, ok := state.GetOk("error")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("Cleanup", func() {
+		BeforeEach(func() {
+			Expect(step.Run(context.Background(), state)).To(Equal(multistep.ActionContinue))
+		})
+
+		It("stops the port forward and deletes the export and its token", func() {
+			step.Cleanup(state)
+			Expect(forwarder.stopped).To(BeTrue())
+
+			_, err := vmClient.ExportV1beta1().VirtualMachineExports(namespace).Get(context.Background(), name+"-export", metav1.GetOptions{})
+			Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+			_, err = kubeClient.CoreV1().Secrets(namespace).Get(context.Background(), name+"-export-token", metav1.GetOptions{})
+			Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("keeps the downloaded disk image", func() {
+			step.Cleanup(state)
+			Expect(exportPath).To(BeAnExistingFile())
+		})
+	})
+})
@@ -0,0 +1,69 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/packer-plugin-sdk/communicator/sshkey"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"golang.org/x/crypto/ssh"
+)
+
+// StepPrepareSSHHostKeys prepares the host keys the SSH communicator accepts, either
+// generated for the build and injected through the media files, or read from
+// ssh_known_hosts_file.
+type StepPrepareSSHHostKeys struct {
+	Config Config
+}
+
+func (s *StepPrepareSSHHostKeys) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+
+	var keys []ssh.PublicKey
+	switch s.Config.SSHHostKeyVerification {
+	case "generated":
+		ui.Say("Creating a temporary ED25519 SSH host key...")
+
+		pair, err := sshkey.GeneratePair(sshkey.ED25519, nil, 0)
+		if err != nil {
+			err := fmt.Errorf("failed to create SSH host key: %w", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey(pair.Public)
+		if err != nil {
+			err := fmt.Errorf("failed to read SSH host key: %w", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		keys = []ssh.PublicKey{key}
+
+		state.Put("ssh_host_private_key", string(pair.Private))
+		state.Put("ssh_host_public_key", string(pair.Public))
+	case "known_hosts":
+		ui.Sayf("Reading SSH host keys from %s...", s.Config.SSHKnownHostsFile)
+
+		var err error
+		keys, err = ReadKnownHostsKeys(expandHome(s.Config.SSHKnownHostsFile))
+		if err != nil {
+			err := fmt.Errorf("failed to read SSH host keys: %w", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	state.Put("ssh_host_keys", keys)
+	return multistep.ActionContinue
+}
+
+func (s *StepPrepareSSHHostKeys) Cleanup(state multistep.StateBag) {
+	// Left blank intentionally
+}
@@ -0,0 +1,92 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+	"github.com/hashicorp/packer-plugin-sdk/communicator/sshkey"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"golang.org/x/crypto/ssh"
+)
+
+var _ = Describe("StepPrepareSSHHostKeys", func() {
+	var (
+		state *multistep.BasicStateBag
+		uiErr *strings.Builder
+		step  *iso.StepPrepareSSHHostKeys
+	)
+
+	BeforeEach(func() {
+		uiErr = &strings.Builder{}
+		ui := &packer.BasicUi{
+			Reader:      strings.NewReader(""),
+			Writer:      io.Discard,
+			ErrorWriter: uiErr,
+		}
+		state = new(multistep.BasicStateBag)
+		state.Put("ui", ui)
+
+		step = &iso.StepPrepareSSHHostKeys{
+			Config: iso.Config{
+				Name:      "test-vm",
+				Namespace: "test-ns",
+			},
+		}
+	})
+
+	Context("Run", func() {
+		It("generates a host key and pins its public key", func() {
+			step.Config.SSHHostKeyVerification = "generated"
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			Expect(state.Get("ssh_host_private_key")).To(ContainSubstring("PRIVATE KEY"))
+			publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(state.Get("ssh_host_public_key").(string)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(publicKey.Type()).To(Equal(ssh.KeyAlgoED25519))
+
+			keys := state.Get("ssh_host_keys").([]ssh.PublicKey)
+			Expect(keys).To(HaveLen(1))
+			Expect(keys[0].Marshal()).To(Equal(publicKey.Marshal()))
+		})
+
+		It("pins the host keys of the known_hosts file", func() {
+			pair, err := sshkey.GeneratePair(sshkey.ED25519, nil, 0)
+			Expect(err).NotTo(HaveOccurred())
+			path := filepath.Join(GinkgoT().TempDir(), "known_hosts")
+			Expect(os.WriteFile(path, append([]byte("fedora "), pair.Public...), 0600)).To(Succeed())
+
+			step.Config.SSHHostKeyVerification = "known_hosts"
+			step.Config.SSHKnownHostsFile = path
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			keys := state.Get("ssh_host_keys").([]ssh.PublicKey)
+			Expect(keys).To(HaveLen(1))
+			Expect(ssh.MarshalAuthorizedKey(keys[0])).To(Equal(pair.Public))
+		})
+
+		It("halts when the known_hosts file cannot be read", func() {
+			step.Config.SSHHostKeyVerification = "known_hosts"
+			step.Config.SSHKnownHostsFile = "/nonexistent/known_hosts"
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(ContainSubstring("failed to read SSH host keys")))
+			Expect(uiErr.String()).To(ContainSubstring("failed to read SSH host keys"))
+		})
+	})
+})
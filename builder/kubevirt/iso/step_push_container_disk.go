@@ -0,0 +1,308 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	containerv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// containerDiskUID is the user and group of the disk in a containerDisk image, the qemu
+// user of the virt-launcher pod.
+const containerDiskUID = 107
+
+// StepPushContainerDisk wraps the downloaded disk in a containerDisk image as a compressed
+// qcow2 image, and pushes it to container_disk_image.
+type StepPushContainerDisk struct {
+	Config Config
+}
+
+func (s *StepPushContainerDisk) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+
+	path, ok := state.Get("export_path").(string)
+	if !ok || path == "" {
+		err := errors.New("export path not found in state")
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	format, _ := state.Get("export_format").(string)
+
+	var nameOpts []name.Option
+	if s.Config.ContainerDiskInsecure {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+	ref, err := name.ParseReference(s.Config.ContainerDiskImage, nameOpts...)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Sayf("Building the containerDisk image %s...", ref)
+
+	// The disk is pushed as a compressed qcow2 image, the one of export_convert_formats
+	// when the disk was converted to qcow2.
+	convertedImages, _ := state.Get("converted_images").(map[string]string)
+	qcow2Path := convertedImages["qcow2"]
+	temporaryQCOW2 := qcow2Path == ""
+	if temporaryQCOW2 {
+		qcow2Path = path + ".containerdisk.qcow2"
+		defer os.Remove(qcow2Path)
+		if err := writeContainerDiskQCOW2(ctx, ui, path, format, qcow2Path); err != nil {
+			if ctx.Err() != nil {
+				ui.Say("Context cancelled, stopping the build of the containerDisk image...")
+				return multistep.ActionHalt
+			}
+			err = fmt.Errorf("failed to convert the disk image to qcow2: %w", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	layerPath := path + ".layer.tar.gz"
+	defer os.Remove(layerPath)
+	if err := writeContainerDiskLayer(ui, qcow2Path, layerPath); err != nil {
+		err = fmt.Errorf("failed to build the containerDisk layer: %w", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	// The layer holds the qcow2 image, free its space before the push.
+	if temporaryQCOW2 {
+		os.Remove(qcow2Path)
+	}
+
+	img, err := containerDiskImage(layerPath, s.Config.ContainerDiskArchitecture)
+	if err != nil {
+		err = fmt.Errorf("failed to build the containerDisk image: %w", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Sayf("Pushing the containerDisk image %s...", ref)
+
+	if err := remote.Write(ref, img, s.remoteOptions(ctx)...); err != nil {
+		if ctx.Err() != nil {
+			ui.Say("Context cancelled, stopping the push of the containerDisk image...")
+			return multistep.ActionHalt
+		}
+		err = fmt.Errorf("failed to push the containerDisk image %s: %w", ref, err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Sayf("Pushed the containerDisk image %s (%s).", ref, digest)
+	state.Put("container_disk_image", ref.Name())
+	state.Put("container_disk_image_digest", ref.Context().Digest(digest.String()).String())
+	state.Put("container_disk_digest", digest.String())
+	return multistep.ActionContinue
+}
+
+func (s *StepPushContainerDisk) Cleanup(state multistep.StateBag) {
+	// Left blank intentionally
+}
+
+// remoteOptions returns the options to push to the registry with the credentials of the
+// configuration, or the ones of the Docker configuration.
+func (s *StepPushContainerDisk) remoteOptions(ctx context.Context) []remote.Option {
+	opts := []remote.Option{remote.WithContext(ctx)}
+	if s.Config.ContainerDiskUsername != "" {
+		opts = append(opts, remote.WithAuth(&authn.Basic{
+			Username: s.Config.ContainerDiskUsername,
+			Password: s.Config.ContainerDiskPassword,
+		}))
+	} else {
+		opts = append(opts, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	}
+
+	if s.Config.ContainerDiskInsecure {
+		transport := remote.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		opts = append(opts, remote.WithTransport(transport))
+	}
+	return opts
+}
+
+// containerDiskFile is the path of the disk in the containerDisk image.
+const containerDiskFile = "disk/disk.qcow2"
+
+// writeContainerDiskQCOW2 converts the disk image at path to the qcow2 image at qcow2Path.
+func writeContainerDiskQCOW2(ctx context.Context, ui packer.Ui, path, format, qcow2Path string) error {
+	size, err := diskImageSize(path, format)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(qcow2Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := writeDiskImage(ctx, ui, path, format, size, f, filepath.Base(qcow2Path), "qcow2"); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writeContainerDiskLayer writes the gzip'd tar layer of a containerDisk image holding the
+// qcow2 image at path.
+func writeContainerDiskLayer(ui packer.Ui, path, layerPath string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	disk, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer disk.Close()
+
+	f, err := os.Create(layerPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     filepath.Dir(containerDiskFile) + "/",
+		Mode:     0555,
+		Uid:      containerDiskUID,
+		Gid:      containerDiskUID,
+	})
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     containerDiskFile,
+		Mode:     0440,
+		Uid:      containerDiskUID,
+		Gid:      containerDiskUID,
+		Size:     size,
+	})
+	if err != nil {
+		return err
+	}
+
+	body := ui.TrackProgress(filepath.Base(path), 0, size, disk)
+	_, err = io.Copy(tw, body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// containerDiskImage returns the OCI image made of the containerDisk layer.
+func containerDiskImage(layerPath, architecture string) (containerv1.Image, error) {
+	layer, err := tarball.LayerFromFile(layerPath, tarball.WithMediaType(types.OCILayer))
+	if err != nil {
+		return nil, err
+	}
+
+	img := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, types.OCIConfigJSON)
+	img, err = mutate.ConfigFile(img, &containerv1.ConfigFile{
+		OS:           "linux",
+		Architecture: architecture,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mutate.AppendLayers(img, layer)
+}
+
+// openDiskImage opens the disk image at path, decompressing it when its format is "gzip".
+func openDiskImage(path, format string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if format != "gzip" {
+		return f, nil
+	}
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipFile{Reader: r, file: f}, nil
+}
+
+// diskImageSize returns the size of the content of the disk image at path.
+func diskImageSize(path, format string) (int64, error) {
+	if format != "gzip" {
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+
+	// The size in the gzip trailer is truncated to 32 bits, count the content instead.
+	disk, err := openDiskImage(path, format)
+	if err != nil {
+		return 0, err
+	}
+	defer disk.Close()
+	return io.Copy(io.Discard, disk)
+}
+
+// gzipFile closes the gzip reader and its file.
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	err := g.Reader.Close()
+	if closeErr := g.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,216 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+var _ = Describe("StepPushContainerDisk", func() {
+	var (
+		state      *multistep.BasicStateBag
+		uiErr      *strings.Builder
+		step       *iso.StepPushContainerDisk
+		server     *httptest.Server
+		exportPath string
+		disk       []byte
+	)
+
+	// expectQCOW2 checks that the content is a qcow2 image of the disk.
+	expectQCOW2 := func(content string) {
+		Expect(content[:4]).To(Equal("QFI\xfb"))
+		Expect(binary.BigEndian.Uint64([]byte(content[24:32]))).To(Equal(uint64(len(disk))))
+	}
+
+	// readDisk pulls the pushed image and returns the content of the disk and its tar header.
+	readDisk := func(reference string) (string, *tar.Header) {
+		ref, err := name.ParseReference(reference, name.Insecure)
+		Expect(err).NotTo(HaveOccurred())
+		img, err := remote.Image(ref)
+		Expect(err).NotTo(HaveOccurred())
+
+		configFile, err := img.ConfigFile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configFile.OS).To(Equal("linux"))
+		Expect(configFile.Architecture).To(Equal("amd64"))
+
+		layers, err := img.Layers()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(layers).To(HaveLen(1))
+		rc, err := layers[0].Uncompressed()
+		Expect(err).NotTo(HaveOccurred())
+		defer rc.Close()
+
+		tr := tar.NewReader(rc)
+		for {
+			header, err := tr.Next()
+			Expect(err).NotTo(HaveOccurred())
+			if header.Typeflag == tar.TypeReg {
+				content, err := io.ReadAll(tr)
+				Expect(err).NotTo(HaveOccurred())
+				return string(content), header
+			}
+		}
+	}
+
+	BeforeEach(func() {
+		uiErr = &strings.Builder{}
+		ui := &packer.BasicUi{
+			Reader:      strings.NewReader(""),
+			Writer:      io.Discard,
+			ErrorWriter: uiErr,
+			PB:          &packer.NoopProgressTracker{},
+		}
+		state = new(multistep.BasicStateBag)
+		state.Put("ui", ui)
+
+		server = httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+		DeferCleanup(server.Close)
+
+		disk = make([]byte, 4*1024*1024)
+		copy(disk, "bootable disk content")
+		exportPath = filepath.Join(GinkgoT().TempDir(), "test-vm.img")
+		Expect(os.WriteFile(exportPath, disk, 0644)).To(Succeed())
+		state.Put("export_path", exportPath)
+		state.Put("export_format", "raw")
+
+		step = &iso.StepPushContainerDisk{
+			Config: iso.Config{
+				ContainerDiskImage:        strings.TrimPrefix(server.URL, "http://") + "/images/fedora:42",
+				ContainerDiskInsecure:     true,
+				ContainerDiskArchitecture: "amd64",
+			},
+		}
+	})
+
+	Context("Run", func() {
+		It("pushes the disk as a qcow2 containerDisk image", func() {
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			content, header := readDisk(step.Config.ContainerDiskImage)
+			expectQCOW2(content)
+			Expect(len(content)).To(BeNumerically("<", len(disk)/4))
+			Expect(header.Name).To(Equal("disk/disk.qcow2"))
+			Expect(header.Uid).To(Equal(107))
+			Expect(header.Gid).To(Equal(107))
+			Expect(exportPath + ".layer.tar.gz").NotTo(BeAnExistingFile())
+			Expect(exportPath + ".containerdisk.qcow2").NotTo(BeAnExistingFile())
+		})
+
+		It("records the tag and the digest of the pushed image", func() {
+			Expect(step.Run(context.Background(), state)).To(Equal(multistep.ActionContinue))
+
+			ref, err := name.ParseReference(step.Config.ContainerDiskImage, name.Insecure)
+			Expect(err).NotTo(HaveOccurred())
+			descriptor, err := remote.Head(ref)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(state.Get("container_disk_digest")).To(Equal(descriptor.Digest.String()))
+			Expect(state.Get("container_disk_image")).To(Equal(step.Config.ContainerDiskImage))
+			Expect(state.Get("container_disk_image_digest")).To(Equal(ref.Context().Digest(descriptor.Digest.String()).String()))
+
+			content, _ := readDisk(state.Get("container_disk_image_digest").(string))
+			expectQCOW2(content)
+		})
+
+		It("decompresses a gzip disk image", func() {
+			f, err := os.Create(exportPath)
+			Expect(err).NotTo(HaveOccurred())
+			gz := gzip.NewWriter(f)
+			_, err = gz.Write(disk)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gz.Close()).To(Succeed())
+			Expect(f.Close()).To(Succeed())
+			state.Put("export_format", "gzip")
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			content, _ := readDisk(step.Config.ContainerDiskImage)
+			expectQCOW2(content)
+		})
+
+		It("pushes the qcow2 image when the disk was converted", func() {
+			qcow2Path := filepath.Join(filepath.Dir(exportPath), "test-vm.qcow2")
+			Expect(os.WriteFile(qcow2Path, []byte("qcow2 content"), 0644)).To(Succeed())
+			state.Put("converted_images", map[string]string{"qcow2": qcow2Path})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			content, header := readDisk(step.Config.ContainerDiskImage)
+			Expect(content).To(Equal("qcow2 content"))
+			Expect(header.Name).To(Equal("disk/disk.qcow2"))
+		})
+
+		It("halts when the disk image cannot be converted", func() {
+			Expect(os.WriteFile(exportPath, disk[:1000], 0644)).To(Succeed())
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(ContainSubstring("failed to convert the disk image to qcow2")))
+			Expect(exportPath + ".containerdisk.qcow2").NotTo(BeAnExistingFile())
+		})
+
+		It("authenticates with the registry credentials", func() {
+			var username, password string
+			server.Config.Handler = func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var ok bool
+					if username, password, ok = r.BasicAuth(); !ok {
+						w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					next.ServeHTTP(w, r)
+				})
+			}(server.Config.Handler)
+			step.Config.ContainerDiskUsername = "builder"
+			step.Config.ContainerDiskPassword = "secret"
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(username).To(Equal("builder"))
+			Expect(password).To(Equal("secret"))
+		})
+
+		It("halts when the registry cannot be reached", func() {
+			server.Close()
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(ContainSubstring("failed to push the containerDisk image")))
+			Expect(uiErr.String()).To(ContainSubstring("failed to push"))
+		})
+
+		It("halts without the downloaded disk image", func() {
+			state.Remove("export_path")
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError("export path not found in state"))
+		})
+	})
+})
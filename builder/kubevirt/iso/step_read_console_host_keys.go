@@ -0,0 +1,87 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"golang.org/x/crypto/ssh"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"kubevirt.io/client-go/kubecli"
+)
+
+// consoleLogContainer is the container of the virt-launcher pod that streams the serial
+// console of the guest, when the serial console log is enabled in KubeVirt.
+const consoleLogContainer = "guest-console-log"
+
+// StepReadConsoleHostKeys reads the SSH host keys that cloud-init prints to the serial
+// console of the VM, so that the SSH communicator only accepts them.
+type StepReadConsoleHostKeys struct {
+	Config Config
+	Client kubecli.KubevirtClient
+}
+
+func (s *StepReadConsoleHostKeys) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+	name := s.Config.Name
+	namespace := s.Config.Namespace
+	pollInterval := 10 * time.Second
+
+	timeout := s.Config.Comm.SSHTimeout
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+
+	ui.Sayf("Waiting for the SSH host keys in the serial console log of the VirtualMachine (%s/%s)...", namespace, name)
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var keys []ssh.PublicKey
+	poller := func(ctx context.Context) (bool, error) {
+		pod, err := RunningLauncherPod(ctx, s.Client, namespace, name)
+		if err != nil || pod == nil {
+			return false, err
+		}
+
+		log, err := s.Client.CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+			Container: consoleLogContainer,
+		}).DoRaw(ctx)
+		if err != nil {
+			// The console log container can start after the pod is running.
+			return false, nil
+		}
+		keys = ParseConsoleHostKeys(string(log))
+		return len(keys) > 0, nil
+	}
+
+	if err := wait.PollUntilContextCancel(waitCtx, pollInterval, true, poller); err != nil {
+		if ctx.Err() != nil {
+			ui.Say("Context cancelled, stopping waiting for the SSH host keys...")
+			return multistep.ActionHalt
+		}
+		if errors.Is(waitCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s waiting for the SSH host keys in the serial console log", timeout)
+		}
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Sayf("Found %d SSH host key(s) in the serial console log.", len(keys))
+	state.Put("ssh_host_keys", keys)
+	return multistep.ActionContinue
+}
+
+func (s *StepReadConsoleHostKeys) Cleanup(state multistep.StateBag) {
+	// Left blank intentionally
+}
@@ -0,0 +1,180 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
+	"github.com/hashicorp/packer-plugin-sdk/communicator/sshkey"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"golang.org/x/crypto/ssh"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	restclient "k8s.io/client-go/rest"
+	fakerest "k8s.io/client-go/rest/fake"
+
+	v1 "kubevirt.io/api/core/v1"
+	kubecli "kubevirt.io/client-go/kubecli"
+)
+
+// consoleLogCoreV1 serves the given serial console log as the logs of every pod,
+// since the fake clientset always returns "fake logs".
+type consoleLogCoreV1 struct {
+	corev1client.CoreV1Interface
+	log string
+}
+
+func (c *consoleLogCoreV1) Pods(namespace string) corev1client.PodInterface {
+	return &consoleLogPods{PodInterface: c.CoreV1Interface.Pods(namespace), namespace: namespace, log: c.log}
+}
+
+type consoleLogPods struct {
+	corev1client.PodInterface
+	namespace string
+	log       string
+}
+
+func (p *consoleLogPods) GetLogs(name string, opts *corev1.PodLogOptions) *restclient.Request {
+	client := &fakerest.RESTClient{
+		Client: fakerest.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
+			if opts.Container != "guest-console-log" {
+				return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader(""))}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(p.log))}, nil
+		}),
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		GroupVersion:         corev1.SchemeGroupVersion,
+		VersionedAPIPath:     fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log", p.namespace, name),
+	}
+	return client.Request()
+}
+
+var _ = Describe("StepReadConsoleHostKeys", func() {
+	const (
+		namespace = "test-ns"
+		name      = "test-vm"
+	)
+
+	var (
+		mockCtrl   *gomock.Controller
+		kubeClient *fakek8sclient.Clientset
+		coreClient *consoleLogCoreV1
+		state      *multistep.BasicStateBag
+		uiErr      *strings.Builder
+		step       *iso.StepReadConsoleHostKeys
+	)
+
+	createLauncherPod := func(phase corev1.PodPhase) {
+		_, err := kubeClient.CoreV1().Pods(namespace).Create(context.Background(), &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "virt-launcher-" + name,
+				Namespace: namespace,
+				Labels: map[string]string{
+					v1.AppLabel:                "virt-launcher",
+					v1.VirtualMachineNameLabel: name,
+				},
+			},
+			Status: corev1.PodStatus{Phase: phase},
+		}, metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		uiErr = &strings.Builder{}
+		ui := &packer.BasicUi{
+			Reader:      strings.NewReader(""),
+			Writer:      io.Discard,
+			ErrorWriter: uiErr,
+		}
+		state = new(multistep.BasicStateBag)
+		state.Put("ui", ui)
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		kubeClient = fakek8sclient.NewSimpleClientset()
+		coreClient = &consoleLogCoreV1{CoreV1Interface: kubeClient.CoreV1()}
+
+		kubecli.GetKubevirtClientFromClientConfig = kubecli.GetMockKubevirtClientFromClientConfig
+		mockVirt := kubecli.NewMockKubevirtClient(mockCtrl)
+		kubecli.MockKubevirtClientInstance = mockVirt
+		mockVirt.EXPECT().CoreV1().Return(coreClient).AnyTimes()
+		virtClient, _ := kubecli.GetKubevirtClientFromClientConfig(nil)
+
+		step = &iso.StepReadConsoleHostKeys{
+			Config: iso.Config{
+				Name:      name,
+				Namespace: namespace,
+				Comm: communicator.Config{
+					Type: "ssh",
+					SSH:  communicator.SSH{SSHTimeout: time.Second},
+				},
+			},
+			Client: virtClient,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	Context("Run", func() {
+		It("pins the host keys printed to the serial console", func() {
+			pair, err := sshkey.GeneratePair(sshkey.ED25519, nil, 0)
+			Expect(err).NotTo(HaveOccurred())
+			coreClient.log = "-----BEGIN SSH HOST KEY KEYS-----\n" + string(pair.Public) + "-----END SSH HOST KEY KEYS-----\n"
+			createLauncherPod(corev1.PodRunning)
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			keys := state.Get("ssh_host_keys").([]ssh.PublicKey)
+			Expect(keys).To(HaveLen(1))
+			Expect(ssh.MarshalAuthorizedKey(keys[0])).To(Equal(pair.Public))
+		})
+
+		It("halts when the serial console has no host keys before the timeout", func() {
+			coreClient.log = "Fedora Linux 42 (Server Edition)\n"
+			createLauncherPod(corev1.PodRunning)
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(ContainSubstring("timed out after 1s waiting for the SSH host keys")))
+			Expect(uiErr.String()).To(ContainSubstring("timed out"))
+		})
+
+		It("ignores launcher pods that are not running", func() {
+			coreClient.log = "-----BEGIN SSH HOST KEY KEYS-----\n-----END SSH HOST KEY KEYS-----\n"
+			createLauncherPod(corev1.PodSucceeded)
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(ContainSubstring("timed out")))
+		})
+
+		It("halts without an error when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			action := step.Run(ctx, state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			_, ok := state.GetOk("error")
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,67 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	v1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+)
+
+type StepRunShutdownCommand struct {
+	Config Config
+	Client kubecli.KubevirtClient
+}
+
+func (s *StepRunShutdownCommand) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+	name := s.Config.Name
+	namespace := s.Config.Namespace
+	shutdownCommand := s.Config.ShutdownCommand
+	shutdownTimeout := s.Config.ShutdownTimeout
+
+	if shutdownCommand == "" {
+		return multistep.ActionContinue
+	}
+
+	// The Always run strategy would restart the guest as soon as it powers off.
+	if err := UpdateVirtualMachineRunStrategy(ctx, s.Client, namespace, name, v1.RunStrategyRerunOnFailure); err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Say("Gracefully halting the guest with the shutdown command...")
+
+	comm := state.Get("communicator").(packer.Communicator)
+	cmd := &packer.RemoteCmd{Command: shutdownCommand}
+	if err := comm.Start(ctx, cmd); err != nil {
+		err := fmt.Errorf("failed to send shutdown command: %w", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Sayf("Waiting up to %s for the guest to shut down...", shutdownTimeout.String())
+
+	waitCtx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+
+	if err := WaitUntilVirtualMachineStopped(waitCtx, s.Client, namespace, name); err != nil {
+		if ctx.Err() != nil {
+			return multistep.ActionHalt
+		}
+		ui.Sayf("Guest did not shut down within %s, the VirtualMachine will be stopped through the API.", shutdownTimeout.String())
+	}
+	return multistep.ActionContinue
+}
+
+func (s *StepRunShutdownCommand) Cleanup(state multistep.StateBag) {
+	// Left blank intentionally
+}
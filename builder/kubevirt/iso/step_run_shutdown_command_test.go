@@ -0,0 +1,146 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/shutdowncommand"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "kubevirt.io/api/core/v1"
+	kubecli "kubevirt.io/client-go/kubecli"
+	kubevirtfake "kubevirt.io/client-go/kubevirt/fake"
+)
+
+var _ = Describe("StepRunShutdownCommand", func() {
+	const (
+		namespace = "test-ns"
+		name      = "test-vm"
+	)
+
+	var (
+		state      *multistep.BasicStateBag
+		step       *iso.StepRunShutdownCommand
+		comm       *packer.MockCommunicator
+		vmClient   *kubevirtfake.Clientset
+		virtClient kubecli.KubevirtClient
+		mockCtrl   *gomock.Controller
+	)
+
+	createVM := func(phase v1.VirtualMachineInstancePhase) {
+		_, err := vmClient.KubevirtV1().VirtualMachines(namespace).Create(context.Background(),
+			&v1.VirtualMachine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+			},
+			metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = vmClient.KubevirtV1().VirtualMachineInstances(namespace).Create(context.Background(),
+			&v1.VirtualMachineInstance{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+				Status: v1.VirtualMachineInstanceStatus{Phase: phase},
+			},
+			metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		ui := &packer.BasicUi{
+			Reader:      strings.NewReader(""),
+			Writer:      io.Discard,
+			ErrorWriter: &strings.Builder{},
+		}
+		comm = &packer.MockCommunicator{}
+		state = new(multistep.BasicStateBag)
+		state.Put("ui", ui)
+		state.Put("communicator", comm)
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		vmClient = kubevirtfake.NewSimpleClientset()
+
+		kubecli.GetKubevirtClientFromClientConfig = kubecli.GetMockKubevirtClientFromClientConfig
+		kubecli.MockKubevirtClientInstance = kubecli.NewMockKubevirtClient(mockCtrl)
+		kubecli.MockKubevirtClientInstance.EXPECT().
+			VirtualMachine(namespace).
+			Return(vmClient.KubevirtV1().VirtualMachines(namespace)).
+			AnyTimes()
+		kubecli.MockKubevirtClientInstance.EXPECT().
+			VirtualMachineInstance(namespace).
+			Return(vmClient.KubevirtV1().VirtualMachineInstances(namespace)).
+			AnyTimes()
+
+		virtClient, _ = kubecli.GetKubevirtClientFromClientConfig(nil)
+
+		step = &iso.StepRunShutdownCommand{
+			Config: iso.Config{
+				Name:      name,
+				Namespace: namespace,
+				ShutdownConfig: shutdowncommand.ShutdownConfig{
+					ShutdownCommand: "sudo shutdown -P now",
+					ShutdownTimeout: time.Second,
+				},
+			},
+			Client: virtClient,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	Context("Run", func() {
+		It("continues without running anything when no shutdown command is set", func() {
+			step.Config.ShutdownCommand = ""
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(comm.StartCalled).To(BeFalse())
+		})
+
+		It("runs the shutdown command and continues once the guest is stopped", func() {
+			createVM(v1.Succeeded)
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(comm.StartCalled).To(BeTrue())
+			Expect(comm.StartCmd.Command).To(Equal("sudo shutdown -P now"))
+
+			vm, err := vmClient.KubevirtV1().VirtualMachines(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*vm.Spec.RunStrategy).To(Equal(v1.RunStrategyRerunOnFailure))
+		})
+
+		It("continues when the guest does not shut down before the timeout", func() {
+			createVM(v1.Running)
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(comm.StartCalled).To(BeTrue())
+		})
+
+		It("halts when the VM cannot be retrieved", func() {
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(HaveOccurred())
+			Expect(comm.StartCalled).To(BeFalse())
+		})
+	})
+})
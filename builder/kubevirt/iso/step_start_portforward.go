@@ -5,6 +5,7 @@ package iso
 
 import (
 	"context"
+	"fmt"
 	"net"
 
 	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/common"
@@ -18,10 +19,13 @@ type StepStartPortForward struct {
 	Config        Config
 	Client        kubecli.KubevirtClient
 	ForwarderFunc PortForwarderFactory
+
+	forwarder PortForwarder
 }
 
 type PortForwarder interface {
-	StartForwarding(address *net.IPAddr, port common.ForwardedPort) error
+	StartForwarding(ctx context.Context, address *net.IPAddr, port common.ForwardedPort) (net.Addr, error)
+	Stop()
 }
 
 type PortForwarderFactory func(kind, namespace, name string, resource common.PortforwardableResource) PortForwarder
@@ -35,19 +39,25 @@ func (s *StepStartPortForward) Run(ctx context.Context, state multistep.StateBag
 	name := s.Config.Name
 	namespace := s.Config.Namespace
 
-	if s.Config.Communicator == "ssh" {
-		ipAddress = s.Config.SSHHost
+	if s.Config.Comm.Type == "ssh" {
+		ipAddress = s.Config.Comm.SSHHost
 		localPort = s.Config.SSHLocalPort
 		remotePort = s.Config.SSHRemotePort
 	}
 
-	if s.Config.Communicator == "winrm" {
-		ipAddress = s.Config.WinRMHost
+	if s.Config.Comm.Type == "winrm" {
+		ipAddress = s.Config.Comm.WinRMHost
 		localPort = s.Config.WinRMLocalPort
 		remotePort = s.Config.WinRMRemotePort
 	}
 
-	address, _ := net.ResolveIPAddr("", ipAddress)
+	address, err := net.ResolveIPAddr("", ipAddress)
+	if err != nil {
+		err := fmt.Errorf("failed to resolve the port forwarding address %q: %w", ipAddress, err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
 	vm := s.Client.VirtualMachine(namespace)
 
 	// Use the factory if provided, otherwise fallback to default
@@ -57,31 +67,47 @@ func (s *StepStartPortForward) Run(ctx context.Context, state multistep.StateBag
 	}
 	forwarder := factory("vm", namespace, name, vm)
 
-	errChan := make(chan error, 1)
-	go func() {
-		err := forwarder.StartForwarding(address, common.ForwardedPort{
-			Local:    localPort,
-			Remote:   remotePort,
-			Protocol: common.ProtocolTCP,
-		})
-		errChan <- err
-	}()
-
-	select {
-	case <-ctx.Done():
+	if ctx.Err() != nil {
 		ui.Say("Context cancelled, stopping port forwarding...")
 		return multistep.ActionHalt
-	case err := <-errChan:
-		if err != nil {
-			ui.Error(err.Error())
-			return multistep.ActionHalt
-		}
 	}
+
+	// The forwarder outlives Run, it is stopped in Cleanup.
+	addr, err := forwarder.StartForwarding(context.WithoutCancel(ctx), address, common.ForwardedPort{
+		Local:    localPort,
+		Remote:   remotePort,
+		Protocol: common.ProtocolTCP,
+	})
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	s.forwarder = forwarder
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		err := fmt.Errorf("unexpected port forwarding address %v", addr)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Sayf("Forwarding %s:%d to port %d of the VirtualMachine (%s/%s)...", ipAddress, tcpAddr.Port, remotePort, namespace, name)
+	state.Put("communicator_host", ipAddress)
+	state.Put("communicator_port", tcpAddr.Port)
 	return multistep.ActionContinue
 }
 
 func (s *StepStartPortForward) Cleanup(state multistep.StateBag) {
-	// Left blank intentionally
+	if s.forwarder == nil {
+		return
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	ui.Say("Stopping port forwarding...")
+	s.forwarder.Stop()
+	s.forwarder = nil
 }
 
 func DefaultPortForwarder(kind, namespace, name string, resource common.PortforwardableResource) PortForwarder {
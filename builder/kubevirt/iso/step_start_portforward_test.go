@@ -16,6 +16,7 @@ import (
 
 	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/common"
 	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
 
@@ -24,13 +25,28 @@ import (
 )
 
 type mockPortForwarder struct {
-	called bool
-	err    error
+	called  bool
+	stopped bool
+	port    common.ForwardedPort
+	err     error
 }
 
-func (m *mockPortForwarder) StartForwarding(address *net.IPAddr, port common.ForwardedPort) error {
+func (m *mockPortForwarder) Stop() {
+	m.stopped = true
+}
+
+func (m *mockPortForwarder) StartForwarding(ctx context.Context, address *net.IPAddr, port common.ForwardedPort) (net.Addr, error) {
 	m.called = true
-	return m.err
+	m.port = port
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	local := port.Local
+	if local == 0 {
+		local = 40022
+	}
+	return &net.TCPAddr{IP: address.IP, Port: local}, nil
 }
 
 var _ = Describe("StepStartPortForward", func() {
@@ -77,10 +93,12 @@ var _ = Describe("StepStartPortForward", func() {
 		mockFwd = &mockPortForwarder{}
 		step = &iso.StepStartPortForward{
 			Config: iso.Config{
-				Name:          name,
-				Namespace:     namespace,
-				Communicator:  "ssh",
-				SSHHost:       "127.0.0.1",
+				Name:      name,
+				Namespace: namespace,
+				Comm: communicator.Config{
+					Type: "ssh",
+					SSH:  communicator.SSH{SSHHost: "127.0.0.1"},
+				},
 				SSHLocalPort:  2222,
 				SSHRemotePort: 22,
 			},
@@ -100,12 +118,43 @@ var _ = Describe("StepStartPortForward", func() {
 			action := step.Run(context.Background(), state)
 			Expect(action).To(Equal(multistep.ActionContinue))
 			Expect(mockFwd.called).To(BeTrue())
+			Expect(state.Get("communicator_host")).To(Equal("127.0.0.1"))
+			Expect(state.Get("communicator_port")).To(Equal(2222))
+		})
+
+		It("puts the allocated port in the state when the local port is not set", func() {
+			step.Config.SSHLocalPort = 0
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(mockFwd.port.Local).To(Equal(0))
+			Expect(state.Get("communicator_port")).To(Equal(40022))
+		})
+
+		It("allocates a free local port with the default forwarder", func() {
+			step.ForwarderFunc = iso.DefaultPortForwarder
+			step.Config.SSHLocalPort = 0
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(state.Get("communicator_port")).NotTo(Equal(0))
+			step.Cleanup(state)
 		})
 
 		It("halts when forwarding returns an error", func() {
 			mockFwd.err = fmt.Errorf("simulated forward error")
 			action := step.Run(context.Background(), state)
 			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(HaveOccurred())
+		})
+
+		It("halts when the host cannot be resolved", func() {
+			step.Config.Comm.SSHHost = "no-such-host.invalid"
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(ContainSubstring(`failed to resolve the port forwarding address "no-such-host.invalid"`)))
+			Expect(mockFwd.called).To(BeFalse())
 		})
 
 		It("halts when context is cancelled", func() {
@@ -114,17 +163,38 @@ var _ = Describe("StepStartPortForward", func() {
 
 			action := step.Run(ctx, state)
 			Expect(action).To(Equal(multistep.ActionHalt))
+			_, hasError := state.GetOk("error")
+			Expect(hasError).To(BeFalse())
 		})
 
 		It("works with WinRM configuration", func() {
-			step.Config.Communicator = "winrm"
-			step.Config.WinRMHost = "127.0.0.1"
+			step.Config.Comm.Type = "winrm"
+			step.Config.Comm.WinRMHost = "127.0.0.1"
 			step.Config.WinRMLocalPort = 5985
 			step.Config.WinRMRemotePort = 5985
 
 			action := step.Run(context.Background(), state)
 			Expect(action).To(Equal(multistep.ActionContinue))
 			Expect(mockFwd.called).To(BeTrue())
+			Expect(state.Get("communicator_port")).To(Equal(5985))
+		})
+	})
+
+	Context("Cleanup", func() {
+		It("stops the forwarder", func() {
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			step.Cleanup(state)
+			Expect(mockFwd.stopped).To(BeTrue())
+		})
+
+		It("does nothing when forwarding did not start", func() {
+			mockFwd.err = fmt.Errorf("simulated forward error")
+			step.Run(context.Background(), state)
+
+			step.Cleanup(state)
+			Expect(mockFwd.stopped).To(BeFalse())
 		})
 	})
 })
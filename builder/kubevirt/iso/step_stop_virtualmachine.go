@@ -5,10 +5,12 @@ package iso
 
 import (
 	"context"
+	"time"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
 
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/ptr"
 
@@ -25,18 +27,50 @@ func (s *StepStopVirtualMachine) Run(ctx context.Context, state multistep.StateB
 	ui := state.Get("ui").(packer.Ui)
 	name := s.Config.Name
 	namespace := s.Config.Namespace
+	stopTimeout := s.Config.StopTimeout
 
 	ui.Sayf("Stopping the temporary VirtualMachine (%s/%s)...", namespace, name)
 
-	vm, err := s.Client.VirtualMachine(namespace).Get(ctx, name, metav1.GetOptions{})
-	if err != nil {
+	// The Halted run strategy makes KubeVirt send an ACPI shutdown to the guest.
+	if err := UpdateVirtualMachineRunStrategy(ctx, s.Client, namespace, name, v1.RunStrategyHalted); err != nil {
+		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
-	vm.Spec.RunStrategy = ptr.To(v1.RunStrategyHalted)
 
-	_, err = s.Client.VirtualMachine(vm.Namespace).Update(ctx, vm, metav1.UpdateOptions{})
+	stopCtx, cancel := context.WithTimeout(ctx, stopTimeout)
+	err := WaitUntilVirtualMachineInstanceDeleted(stopCtx, s.Client, namespace, name)
+	cancel()
 	if err != nil {
+		if ctx.Err() != nil {
+			return multistep.ActionHalt
+		}
+
+		ui.Sayf("VirtualMachine did not shut down within %s, forcing it to stop...", stopTimeout.String())
+
+		if err := s.forceStop(ctx); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+
+		forceCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+		err = WaitUntilVirtualMachineInstanceDeleted(forceCtx, s.Client, namespace, name)
+		cancel()
+		if err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	ui.Say("Waiting for the virt-launcher pod to release the root disk...")
+
+	releaseCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	if err := WaitUntilLauncherPodsTerminated(releaseCtx, s.Client, namespace, name); err != nil {
+		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
@@ -46,3 +80,24 @@ func (s *StepStopVirtualMachine) Run(ctx context.Context, state multistep.StateB
 func (s *StepStopVirtualMachine) Cleanup(state multistep.StateBag) {
 	// Left blank intentionally
 }
+
+// forceStop terminates the VirtualMachineInstance without waiting for the guest to shut down.
+// The guest can still finish shutting down before the stop request, which KubeVirt then rejects
+// since the VM is no longer running: that is not an error when the VMI is gone.
+func (s *StepStopVirtualMachine) forceStop(ctx context.Context) error {
+	name := s.Config.Name
+	namespace := s.Config.Namespace
+
+	err := s.Client.VirtualMachine(namespace).Stop(ctx, name, &v1.StopOptions{
+		GracePeriod: ptr.To(int64(0)),
+	})
+	if !k8serrors.IsNotFound(err) && !k8serrors.IsConflict(err) {
+		return err
+	}
+
+	_, getErr := s.Client.VirtualMachineInstance(namespace).Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(getErr) {
+		return nil
+	}
+	return err
+}
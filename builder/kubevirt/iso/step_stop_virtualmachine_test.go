@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
@@ -17,13 +18,19 @@ import (
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
 
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
 	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
 
 	v1 "kubevirt.io/api/core/v1"
 	kubecli "kubevirt.io/client-go/kubecli"
 	kubevirtfake "kubevirt.io/client-go/kubevirt/fake"
+	kvtesting "kubevirt.io/client-go/testing"
 )
 
 var _ = Describe("StepStopVirtualMachine", func() {
@@ -36,6 +43,7 @@ var _ = Describe("StepStopVirtualMachine", func() {
 		state      *multistep.BasicStateBag
 		step       *iso.StepStopVirtualMachine
 		vmClient   *kubevirtfake.Clientset
+		kubeClient *fakek8sclient.Clientset
 		virtClient kubecli.KubevirtClient
 		mockCtrl   *gomock.Controller
 		mockVirt   *kubecli.MockKubevirtClient
@@ -53,6 +61,7 @@ var _ = Describe("StepStopVirtualMachine", func() {
 
 		mockCtrl = gomock.NewController(GinkgoT())
 		vmClient = kubevirtfake.NewSimpleClientset()
+		kubeClient = fakek8sclient.NewSimpleClientset()
 
 		kubecli.GetKubevirtClientFromClientConfig = kubecli.GetMockKubevirtClientFromClientConfig
 		mockVirt = kubecli.NewMockKubevirtClient(mockCtrl)
@@ -62,13 +71,19 @@ var _ = Describe("StepStopVirtualMachine", func() {
 			VirtualMachine(namespace).
 			Return(vmClient.KubevirtV1().VirtualMachines(namespace)).
 			AnyTimes()
+		mockVirt.EXPECT().
+			VirtualMachineInstance(namespace).
+			Return(vmClient.KubevirtV1().VirtualMachineInstances(namespace)).
+			AnyTimes()
+		mockVirt.EXPECT().CoreV1().Return(kubeClient.CoreV1()).AnyTimes()
 
 		virtClient, _ = kubecli.GetKubevirtClientFromClientConfig(nil)
 
 		step = &iso.StepStopVirtualMachine{
 			Config: iso.Config{
-				Name:      name,
-				Namespace: namespace,
+				Name:        name,
+				Namespace:   namespace,
+				StopTimeout: time.Second,
 			},
 			Client: virtClient,
 		}
@@ -78,6 +93,18 @@ var _ = Describe("StepStopVirtualMachine", func() {
 		mockCtrl.Finish()
 	})
 
+	createVM := func() {
+		_, err := vmClient.KubevirtV1().VirtualMachines(namespace).Create(context.Background(),
+			&v1.VirtualMachine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+			},
+			metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
 	Context("Run", func() {
 		It("continues when VM is retrieved and updated successfully", func() {
 			_, err := vmClient.KubevirtV1().VirtualMachines(namespace).Create(context.Background(),
@@ -97,6 +124,7 @@ var _ = Describe("StepStopVirtualMachine", func() {
 		It("halts when VM cannot be retrieved", func() {
 			action := step.Run(context.Background(), state)
 			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(HaveOccurred())
 		})
 
 		It("halts when VM update fails", func() {
@@ -117,6 +145,152 @@ var _ = Describe("StepStopVirtualMachine", func() {
 
 			action := step.Run(context.Background(), state)
 			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(HaveOccurred())
+		})
+
+		It("retries the update on conflict", func() {
+			createVM()
+
+			conflicts := 0
+			vmClient.Fake.PrependReactor("update", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if conflicts > 0 {
+					return false, nil, nil
+				}
+				conflicts++
+				return true, nil, k8serrors.NewConflict(schema.GroupResource{Resource: "virtualmachines"}, name, fmt.Errorf("object was modified"))
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			vm, err := vmClient.KubevirtV1().VirtualMachines(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*vm.Spec.RunStrategy).To(Equal(v1.RunStrategyHalted))
+		})
+
+		It("forces the VM to stop when the guest does not shut down in time", func() {
+			createVM()
+			_, err := vmClient.KubevirtV1().VirtualMachineInstances(namespace).Create(context.Background(),
+				&v1.VirtualMachineInstance{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      name,
+						Namespace: namespace,
+					},
+				},
+				metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			var stopOptions *v1.StopOptions
+			vmClient.Fake.PrependReactor("put", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() != "stop" {
+					return false, nil, nil
+				}
+				stopOptions = action.(kvtesting.PutAction[*v1.StopOptions]).GetOptions()
+				// KubeVirt deletes the VirtualMachineInstance once it is stopped.
+				return true, nil, vmClient.Tracker().Delete(v1.SchemeGroupVersion.WithResource("virtualmachineinstances"), namespace, name)
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(stopOptions).To(Equal(&v1.StopOptions{GracePeriod: ptr.To(int64(0))}))
+
+			_, err = vmClient.KubevirtV1().VirtualMachineInstances(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("continues when the guest shuts down before the VM is forced to stop", func() {
+			createVM()
+			_, err := vmClient.KubevirtV1().VirtualMachineInstances(namespace).Create(context.Background(),
+				&v1.VirtualMachineInstance{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      name,
+						Namespace: namespace,
+					},
+				},
+				metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			vmClient.Fake.PrependReactor("put", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() != "stop" {
+					return false, nil, nil
+				}
+				// The VMI is gone by the time the stop request is handled.
+				Expect(vmClient.Tracker().Delete(v1.SchemeGroupVersion.WithResource("virtualmachineinstances"), namespace, name)).To(Succeed())
+				return true, nil, k8serrors.NewConflict(v1.Resource("virtualmachines"), name, fmt.Errorf("VM is not running"))
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(state.Get("error")).To(BeNil())
+		})
+
+		It("halts when the VM cannot be forced to stop", func() {
+			createVM()
+			_, err := vmClient.KubevirtV1().VirtualMachineInstances(namespace).Create(context.Background(),
+				&v1.VirtualMachineInstance{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      name,
+						Namespace: namespace,
+					},
+				},
+				metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			vmClient.Fake.PrependReactor("put", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() != "stop" {
+					return false, nil, nil
+				}
+				return true, nil, k8serrors.NewConflict(v1.Resource("virtualmachines"), name, fmt.Errorf("VM is not running"))
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(ContainSubstring("VM is not running")))
+		})
+
+		It("halts when the launcher pod keeps running", func() {
+			createVM()
+			_, err := kubeClient.CoreV1().Pods(namespace).Create(context.Background(),
+				&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "virt-launcher-" + name + "-abcde",
+						Namespace: namespace,
+						Labels: map[string]string{
+							v1.AppLabel:                "virt-launcher",
+							v1.VirtualMachineNameLabel: name,
+						},
+					},
+					Status: corev1.PodStatus{Phase: corev1.PodRunning},
+				},
+				metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			action := step.Run(ctx, state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+		})
+
+		It("continues when the launcher pod has completed", func() {
+			createVM()
+			_, err := kubeClient.CoreV1().Pods(namespace).Create(context.Background(),
+				&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "virt-launcher-" + name + "-abcde",
+						Namespace: namespace,
+						Labels: map[string]string{
+							v1.AppLabel:                "virt-launcher",
+							v1.VirtualMachineNameLabel: name,
+						},
+					},
+					Status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+				},
+				metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
 		})
 	})
 })
@@ -0,0 +1,71 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso
+
+import (
+	"context"
+
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+
+	"kubevirt.io/client-go/kubecli"
+)
+
+// StepUpdateGeneratedData updates the generated data before provisioning, since the VM
+// may have been restarted on another node after the installation, and the address the
+// communicator connected to is only known once the connection steps ran.
+type StepUpdateGeneratedData struct {
+	Config Config
+	Client kubecli.KubevirtClient
+}
+
+func (s *StepUpdateGeneratedData) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+	name := s.Config.Name
+	namespace := s.Config.Namespace
+
+	putLauncherPodData(ctx, ui, s.Client, state, namespace, name)
+
+	generatedData := &packerbuilderdata.GeneratedData{State: state}
+	host, hostErr := communicatorHost(state)
+	port, portErr := communicatorPort(state)
+	if hostErr == nil && portErr == nil {
+		generatedData.Put("Host", host)
+		generatedData.Put("Port", port)
+	}
+
+	// The provisioner hook data is read from the communicator config, which only holds the
+	// configured address and keys.
+	comm, ok := state.Get("communicator_config").(*communicator.Config)
+	if !ok {
+		return multistep.ActionContinue
+	}
+
+	// Copy the config, since it is shared with the builder.
+	updated := *comm
+	if hostErr == nil && portErr == nil {
+		switch updated.Type {
+		case "ssh":
+			updated.SSHHost = host
+			updated.SSHPort = port
+		case "winrm":
+			updated.WinRMHost = host
+			updated.WinRMPort = port
+		}
+	}
+	if publicKey, ok := state.Get("ssh_public_key").(string); ok {
+		updated.SSHPublicKey = []byte(publicKey)
+	}
+	if privateKey, ok := state.Get("privateKey").(string); ok {
+		updated.SSHPrivateKey = []byte(privateKey)
+	}
+	state.Put("communicator_config", &updated)
+	return multistep.ActionContinue
+}
+
+func (s *StepUpdateGeneratedData) Cleanup(state multistep.StateBag) {
+	// Left blank intentionally
+}
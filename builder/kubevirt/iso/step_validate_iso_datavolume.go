@@ -5,12 +5,15 @@ package iso
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"kubevirt.io/client-go/kubecli"
+	"kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
 )
 
 type StepValidateIsoDataVolume struct {
@@ -25,13 +28,16 @@ func (s *StepValidateIsoDataVolume) Run(ctx context.Context, state multistep.Sta
 
 	ui.Sayf("Validating the existence of the ISO DataVolume (%s/%s)...", isoVolumeNamespace, isoVolumeName)
 
-	_, err := s.Client.CdiClient().CdiV1beta1().DataVolumes(isoVolumeNamespace).Get(ctx, isoVolumeName, metav1.GetOptions{})
+	isoVolume, err := s.Client.CdiClient().CdiV1beta1().DataVolumes(isoVolumeNamespace).Get(ctx, isoVolumeName, metav1.GetOptions{})
 	if err != nil {
+		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
+	state.Put("iso_source", dataVolumeSource(isoVolume))
 
 	if err := WaitUntilDataVolumeSucceeded(ctx, s.Client, isoVolumeNamespace, isoVolumeName); err != nil {
+		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
@@ -41,3 +47,37 @@ func (s *StepValidateIsoDataVolume) Run(ctx context.Context, state multistep.Sta
 func (s *StepValidateIsoDataVolume) Cleanup(state multistep.StateBag) {
 	// Left blank intentionally
 }
+
+// dataVolumeSource describes where the content of a DataVolume comes from, e.g. the URL
+// it was imported from, or an empty string when it is unknown.
+func dataVolumeSource(dv *v1beta1.DataVolume) string {
+	if ref := dv.Spec.SourceRef; ref != nil {
+		namespace := dv.Namespace
+		if ref.Namespace != nil {
+			namespace = *ref.Namespace
+		}
+		return fmt.Sprintf("%s:%s/%s", strings.ToLower(ref.Kind), namespace, ref.Name)
+	}
+
+	source := dv.Spec.Source
+	switch {
+	case source == nil:
+		return ""
+	case source.HTTP != nil:
+		return source.HTTP.URL
+	case source.Registry != nil && source.Registry.URL != nil:
+		return *source.Registry.URL
+	case source.S3 != nil:
+		return source.S3.URL
+	case source.GCS != nil:
+		return source.GCS.URL
+	case source.PVC != nil:
+		return fmt.Sprintf("pvc:%s/%s", source.PVC.Namespace, source.PVC.Name)
+	case source.Snapshot != nil:
+		return fmt.Sprintf("snapshot:%s/%s", source.Snapshot.Namespace, source.Snapshot.Name)
+	case source.Upload != nil:
+		return "upload"
+	default:
+		return ""
+	}
+}
@@ -17,6 +17,7 @@ import (
 
 	"github.com/golang/mock/gomock"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ptr "k8s.io/utils/ptr"
 	fakecdiclient "kubevirt.io/client-go/containerizeddataimporter/fake"
 	"kubevirt.io/client-go/kubecli"
 	cdiv1beta1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
@@ -80,11 +81,46 @@ var _ = Describe("StepValidateIsoDataVolume", func() {
 
 			action := step.Run(context.Background(), state)
 			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(state.Get("iso_source")).To(Equal(""))
 		})
 
+		DescribeTable("records where the ISO was imported from",
+			func(spec cdiv1beta1.DataVolumeSpec, expected string) {
+				_, err := cdiClient.CdiV1beta1().DataVolumes(namespace).Create(context.Background(), &cdiv1beta1.DataVolume{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      isoName,
+						Namespace: namespace,
+					},
+					Spec:   spec,
+					Status: cdiv1beta1.DataVolumeStatus{Phase: cdiv1beta1.Succeeded},
+				}, metav1.CreateOptions{})
+				Expect(err).NotTo(HaveOccurred())
+
+				action := step.Run(context.Background(), state)
+				Expect(action).To(Equal(multistep.ActionContinue))
+				Expect(state.Get("iso_source")).To(Equal(expected))
+			},
+			Entry("from an HTTP URL", cdiv1beta1.DataVolumeSpec{
+				Source: &cdiv1beta1.DataVolumeSource{HTTP: &cdiv1beta1.DataVolumeSourceHTTP{URL: "https://example.com/fedora.iso"}},
+			}, "https://example.com/fedora.iso"),
+			Entry("from a registry", cdiv1beta1.DataVolumeSpec{
+				Source: &cdiv1beta1.DataVolumeSource{Registry: &cdiv1beta1.DataVolumeSourceRegistry{URL: ptr.To("docker://quay.io/isos/fedora:42")}},
+			}, "docker://quay.io/isos/fedora:42"),
+			Entry("from a PVC", cdiv1beta1.DataVolumeSpec{
+				Source: &cdiv1beta1.DataVolumeSource{PVC: &cdiv1beta1.DataVolumeSourcePVC{Namespace: "isos", Name: "fedora"}},
+			}, "pvc:isos/fedora"),
+			Entry("from a DataSource", cdiv1beta1.DataVolumeSpec{
+				SourceRef: &cdiv1beta1.DataVolumeSourceRef{Kind: "DataSource", Name: "fedora"},
+			}, "datasource:"+namespace+"/fedora"),
+			Entry("from an upload", cdiv1beta1.DataVolumeSpec{
+				Source: &cdiv1beta1.DataVolumeSource{Upload: &cdiv1beta1.DataVolumeSourceUpload{}},
+			}, "upload"),
+		)
+
 		It("halts when DataVolume not found", func() {
 			action := step.Run(context.Background(), state)
 			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(HaveOccurred())
 		})
 
 		It("halts when DataVolume never succeeds", func() {
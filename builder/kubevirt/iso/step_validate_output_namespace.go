@@ -0,0 +1,114 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kubevirt.io/client-go/kubecli"
+	cdiv1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+)
+
+// StepValidateOutputNamespace checks, before the build starts, that the image can be
+// published to output_namespace: CDI only clones the root disk across namespaces for users
+// allowed to create 'datavolumes/source' in the namespace of the root disk.
+type StepValidateOutputNamespace struct {
+	Config Config
+	Client kubecli.KubevirtClient
+}
+
+func (s *StepValidateOutputNamespace) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+	namespace := s.Config.Namespace
+	outputNamespace := s.Config.OutputNamespace
+
+	ui.Sayf("Validating the permissions to publish the image to the namespace %s...", outputNamespace)
+
+	checks := []struct {
+		attributes authorizationv1.ResourceAttributes
+		reason     string
+	}{
+		{
+			attributes: authorizationv1.ResourceAttributes{
+				Namespace:   namespace,
+				Verb:        "create",
+				Group:       cdiv1.SchemeGroupVersion.Group,
+				Resource:    "datavolumes",
+				Subresource: "source",
+			},
+			reason: fmt.Sprintf("to clone the root disk from the namespace %s", namespace),
+		},
+		{
+			attributes: authorizationv1.ResourceAttributes{
+				Namespace: outputNamespace,
+				Verb:      "create",
+				Group:     cdiv1.SchemeGroupVersion.Group,
+				Resource:  "datavolumes",
+			},
+			reason: "to create the DataVolume of the image",
+		},
+		{
+			attributes: authorizationv1.ResourceAttributes{
+				Namespace: outputNamespace,
+				Verb:      "create",
+				Group:     cdiv1.SchemeGroupVersion.Group,
+				Resource:  "datasources",
+			},
+			reason: "to create the DataSource of the image",
+		},
+	}
+
+	var errs []error
+	for _, check := range checks {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &check.attributes,
+			},
+		}
+		review, err := s.Client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			err = fmt.Errorf("failed to review the permissions to publish the image to the namespace %s: %w", outputNamespace, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		if !review.Status.Allowed {
+			errs = append(errs, missingPermissionError(check.attributes, check.reason, review.Status.Reason))
+		}
+	}
+
+	if len(errs) > 0 {
+		err := fmt.Errorf("cannot publish the image to output_namespace %s: %w", outputNamespace, errors.Join(errs...))
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	return multistep.ActionContinue
+}
+
+func (s *StepValidateOutputNamespace) Cleanup(state multistep.StateBag) {
+	// Left blank intentionally
+}
+
+// missingPermissionError describes a permission the build is denied, and why it needs it.
+func missingPermissionError(attributes authorizationv1.ResourceAttributes, reason, denial string) error {
+	resource := attributes.Resource
+	if attributes.Subresource != "" {
+		resource += "/" + attributes.Subresource
+	}
+	err := fmt.Errorf("the permission to %s %s.%s in the namespace %s is required %s",
+		attributes.Verb, resource, attributes.Group, attributes.Namespace, reason)
+	if denial != "" {
+		err = fmt.Errorf("%w (%s)", err, denial)
+	}
+	return err
+}
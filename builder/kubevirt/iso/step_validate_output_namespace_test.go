@@ -0,0 +1,141 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	"github.com/golang/mock/gomock"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/testing"
+	"kubevirt.io/client-go/kubecli"
+)
+
+var _ = Describe("StepValidateOutputNamespace", func() {
+	const (
+		namespace       = "packer-builds"
+		outputNamespace = "golden-images"
+	)
+
+	var (
+		ctrl       *gomock.Controller
+		state      *multistep.BasicStateBag
+		uiErr      *strings.Builder
+		step       *iso.StepValidateOutputNamespace
+		kubeClient *fakek8sclient.Clientset
+		reviews    []authorizationv1.ResourceAttributes
+		denied     func(attributes authorizationv1.ResourceAttributes) bool
+	)
+
+	BeforeEach(func() {
+		uiErr = &strings.Builder{}
+		ui := &packer.BasicUi{
+			Reader:      strings.NewReader(""),
+			Writer:      io.Discard,
+			ErrorWriter: uiErr,
+		}
+		state = new(multistep.BasicStateBag)
+		state.Put("ui", ui)
+
+		reviews = nil
+		denied = func(authorizationv1.ResourceAttributes) bool { return false }
+		kubeClient = fakek8sclient.NewSimpleClientset()
+		kubeClient.PrependReactor("create", "selfsubjectaccessreviews", func(action testing.Action) (bool, runtime.Object, error) {
+			review := action.(testing.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+			attributes := *review.Spec.ResourceAttributes
+			reviews = append(reviews, attributes)
+			if denied(attributes) {
+				review.Status = authorizationv1.SubjectAccessReviewStatus{Reason: "no RBAC policy matched"}
+			} else {
+				review.Status = authorizationv1.SubjectAccessReviewStatus{Allowed: true}
+			}
+			return true, review, nil
+		})
+
+		ctrl = gomock.NewController(GinkgoT())
+		kubecli.GetKubevirtClientFromClientConfig = kubecli.GetMockKubevirtClientFromClientConfig
+		kubecli.MockKubevirtClientInstance = kubecli.NewMockKubevirtClient(ctrl)
+		kubecli.MockKubevirtClientInstance.EXPECT().AuthorizationV1().Return(kubeClient.AuthorizationV1()).AnyTimes()
+		virtClient, _ := kubecli.GetKubevirtClientFromClientConfig(nil)
+
+		step = &iso.StepValidateOutputNamespace{
+			Config: iso.Config{
+				Namespace:       namespace,
+				OutputNamespace: outputNamespace,
+			},
+			Client: virtClient,
+		}
+	})
+
+	AfterEach(func() {
+		ctrl.Finish()
+	})
+
+	Context("Run", func() {
+		It("continues when the image can be published to the output namespace", func() {
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(reviews).To(ConsistOf(
+				authorizationv1.ResourceAttributes{
+					Namespace: namespace, Verb: "create", Group: "cdi.kubevirt.io", Resource: "datavolumes", Subresource: "source",
+				},
+				authorizationv1.ResourceAttributes{
+					Namespace: outputNamespace, Verb: "create", Group: "cdi.kubevirt.io", Resource: "datavolumes",
+				},
+				authorizationv1.ResourceAttributes{
+					Namespace: outputNamespace, Verb: "create", Group: "cdi.kubevirt.io", Resource: "datasources",
+				},
+			))
+		})
+
+		It("halts when the root disk cannot be cloned across namespaces", func() {
+			denied = func(attributes authorizationv1.ResourceAttributes) bool {
+				return attributes.Subresource == "source"
+			}
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(
+				"cannot publish the image to output_namespace golden-images: the permission to create " +
+					"datavolumes/source.cdi.kubevirt.io in the namespace packer-builds is required to clone the root disk " +
+					"from the namespace packer-builds (no RBAC policy matched)"))
+			Expect(uiErr.String()).To(ContainSubstring("datavolumes/source"))
+		})
+
+		It("reports every missing permission", func() {
+			denied = func(attributes authorizationv1.ResourceAttributes) bool {
+				return attributes.Namespace == outputNamespace
+			}
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			err, _ := state.Get("error").(error)
+			Expect(err).To(MatchError(ContainSubstring("create datavolumes.cdi.kubevirt.io in the namespace golden-images")))
+			Expect(err).To(MatchError(ContainSubstring("create datasources.cdi.kubevirt.io in the namespace golden-images")))
+			Expect(err).NotTo(MatchError(ContainSubstring("datavolumes/source")))
+		})
+
+		It("halts when the permissions cannot be reviewed", func() {
+			kubeClient.PrependReactor("create", "selfsubjectaccessreviews", func(action testing.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("connection refused")
+			})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(ContainSubstring("failed to review the permissions")))
+		})
+	})
+})
@@ -5,20 +5,34 @@ package iso
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	v1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
 )
 
 type StepWaitForInstallation struct {
 	Config Config
+	Client kubecli.KubevirtClient
 }
 
 func (s *StepWaitForInstallation) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	ui := state.Get("ui").(packer.Ui)
 	installationWaitTimeout := s.Config.InstallationWaitTimeout
 
+	switch s.Config.InstallationCompleteOn {
+	case "shutdown":
+		return s.waitForShutdown(ctx, state)
+	case "guest_agent":
+		return s.waitForGuestAgent(ctx, state)
+	}
+
 	if int64(installationWaitTimeout) > 0 {
 		ui.Sayf("Waiting %s to complete ISO installation...", installationWaitTimeout.String())
 
@@ -35,3 +49,80 @@ func (s *StepWaitForInstallation) Run(ctx context.Context, state multistep.State
 func (s *StepWaitForInstallation) Cleanup(multistep.StateBag) {
 	// Left blank intentionally
 }
+
+func (s *StepWaitForInstallation) waitForShutdown(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+	name := s.Config.Name
+	namespace := s.Config.Namespace
+
+	err := s.waitForSignal(ctx, ui, "the guest to shut down", func(ctx context.Context) error {
+		return WaitUntilVirtualMachineStopped(ctx, s.Client, namespace, name)
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+		}
+		return multistep.ActionHalt
+	}
+
+	if s.Config.Comm.Type != "ssh" && s.Config.Comm.Type != "winrm" {
+		return multistep.ActionContinue
+	}
+
+	ui.Sayf("Starting the VirtualMachine (%s/%s) again for provisioning...", namespace, name)
+
+	if err := UpdateVirtualMachineRunStrategy(ctx, s.Client, namespace, name, v1.RunStrategyAlways); err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	return multistep.ActionContinue
+}
+
+func (s *StepWaitForInstallation) waitForGuestAgent(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+	var vmi *v1.VirtualMachineInstance
+
+	err := s.waitForSignal(ctx, ui, "the guest agent to connect", func(ctx context.Context) error {
+		var err error
+		vmi, err = WaitUntilGuestAgentConnected(ctx, s.Client, s.Config.Namespace, s.Config.Name)
+		return err
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+		}
+		return multistep.ActionHalt
+	}
+
+	guestOS := vmi.Status.GuestOSInfo.PrettyName
+	if guestOS == "" {
+		guestOS = vmi.Status.GuestOSInfo.Name
+	}
+	ui.Sayf("Guest agent connected, installed OS: %s.", guestOS)
+	return multistep.ActionContinue
+}
+
+// waitForSignal runs waitFunc bounded by the installation wait timeout, if any.
+func (s *StepWaitForInstallation) waitForSignal(ctx context.Context, ui packer.Ui, signal string, waitFunc func(ctx context.Context) error) error {
+	installationWaitTimeout := s.Config.InstallationWaitTimeout
+
+	waitCtx := ctx
+	if int64(installationWaitTimeout) > 0 {
+		ui.Sayf("Waiting up to %s for %s after ISO installation...", installationWaitTimeout.String(), signal)
+
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, installationWaitTimeout)
+		defer cancel()
+	} else {
+		ui.Sayf("Waiting for %s after ISO installation...", signal)
+	}
+
+	err := waitFunc(waitCtx)
+	if err != nil && ctx.Err() == nil && wait.Interrupted(err) {
+		return fmt.Errorf("timed out after %s waiting for %s", installationWaitTimeout.String(), signal)
+	}
+	return err
+}
@@ -9,12 +9,22 @@ import (
 	"strings"
 	"time"
 
+	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
 	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ptr "k8s.io/utils/ptr"
+
+	v1 "kubevirt.io/api/core/v1"
+	kubecli "kubevirt.io/client-go/kubecli"
+	kubevirtfake "kubevirt.io/client-go/kubevirt/fake"
 )
 
 var _ = Describe("StepWaitForInstallation", func() {
@@ -70,6 +80,309 @@ var _ = Describe("StepWaitForInstallation", func() {
 				cancel()
 			}()
 
+			action := step.Run(ctx, state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			_, hasError := state.GetOk("error")
+			Expect(hasError).To(BeFalse())
+		})
+	})
+
+	Context("Run with shutdown detection", func() {
+		const (
+			namespace = "test-ns"
+			name      = "test-vm"
+		)
+
+		var (
+			mockCtrl   *gomock.Controller
+			vmClient   *kubevirtfake.Clientset
+			virtClient kubecli.KubevirtClient
+		)
+
+		createVMI := func(phase v1.VirtualMachineInstancePhase) {
+			_, err := vmClient.KubevirtV1().VirtualMachineInstances(namespace).Create(context.Background(),
+				&v1.VirtualMachineInstance{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      name,
+						Namespace: namespace,
+					},
+					Status: v1.VirtualMachineInstanceStatus{Phase: phase},
+				},
+				metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		BeforeEach(func() {
+			mockCtrl = gomock.NewController(GinkgoT())
+			vmClient = kubevirtfake.NewSimpleClientset()
+
+			kubecli.GetKubevirtClientFromClientConfig = kubecli.GetMockKubevirtClientFromClientConfig
+			kubecli.MockKubevirtClientInstance = kubecli.NewMockKubevirtClient(mockCtrl)
+			kubecli.MockKubevirtClientInstance.EXPECT().
+				VirtualMachine(namespace).
+				Return(vmClient.KubevirtV1().VirtualMachines(namespace)).
+				AnyTimes()
+			kubecli.MockKubevirtClientInstance.EXPECT().
+				VirtualMachineInstance(namespace).
+				Return(vmClient.KubevirtV1().VirtualMachineInstances(namespace)).
+				AnyTimes()
+
+			virtClient, _ = kubecli.GetKubevirtClientFromClientConfig(nil)
+
+			_, err := vmClient.KubevirtV1().VirtualMachines(namespace).Create(context.Background(),
+				&v1.VirtualMachine{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      name,
+						Namespace: namespace,
+					},
+					Spec: v1.VirtualMachineSpec{
+						RunStrategy: ptr.To(v1.RunStrategyRerunOnFailure),
+					},
+				},
+				metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			step = &iso.StepWaitForInstallation{
+				Config: iso.Config{
+					Name:                    name,
+					Namespace:               namespace,
+					InstallationCompleteOn:  "shutdown",
+					InstallationWaitTimeout: 2 * time.Second,
+				},
+				Client: virtClient,
+			}
+		})
+
+		AfterEach(func() {
+			mockCtrl.Finish()
+		})
+
+		It("continues when the guest has shut down", func() {
+			createVMI(v1.Succeeded)
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			vm, err := vmClient.KubevirtV1().VirtualMachines(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*vm.Spec.RunStrategy).To(Equal(v1.RunStrategyRerunOnFailure))
+		})
+
+		It("continues as soon as the guest shuts down", func() {
+			createVMI(v1.Running)
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(500 * time.Millisecond)
+				vmi, err := vmClient.KubevirtV1().VirtualMachineInstances(namespace).Get(context.Background(), name, metav1.GetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				vmi.Status.Phase = v1.Succeeded
+				_, err = vmClient.KubevirtV1().VirtualMachineInstances(namespace).Update(context.Background(), vmi, metav1.UpdateOptions{})
+				Expect(err).NotTo(HaveOccurred())
+			}()
+
+			step.Config.InstallationWaitTimeout = 10 * time.Second
+			start := time.Now()
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		})
+
+		It("continues when the VMI is gone", func() {
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+		})
+
+		It("starts the VM again when a communicator is configured", func() {
+			createVMI(v1.Succeeded)
+			step.Config.Comm.Type = "ssh"
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+
+			vm, err := vmClient.KubevirtV1().VirtualMachines(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*vm.Spec.RunStrategy).To(Equal(v1.RunStrategyAlways))
+		})
+
+		It("halts when the guest does not shut down before the timeout", func() {
+			createVMI(v1.Running)
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(HaveOccurred())
+		})
+
+		It("halts when the VMI fails", func() {
+			createVMI(v1.Failed)
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(ContainSubstring("VirtualMachineInstance (test-ns/test-vm) failed")))
+		})
+	})
+
+	Context("Run with guest agent detection", func() {
+		const (
+			namespace = "test-ns"
+			name      = "test-vm"
+		)
+
+		var (
+			mockCtrl   *gomock.Controller
+			vmClient   *kubevirtfake.Clientset
+			virtClient kubecli.KubevirtClient
+		)
+
+		newVMI := func(uid types.UID, agentConnected corev1.ConditionStatus, guestOSName string) *v1.VirtualMachineInstance {
+			return &v1.VirtualMachineInstance{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+					UID:       uid,
+				},
+				Status: v1.VirtualMachineInstanceStatus{
+					Conditions: []v1.VirtualMachineInstanceCondition{
+						{
+							Type:   v1.VirtualMachineInstanceAgentConnected,
+							Status: agentConnected,
+						},
+					},
+					GuestOSInfo: v1.VirtualMachineInstanceGuestOSInfo{Name: guestOSName},
+				},
+			}
+		}
+
+		createVMI := func(uid types.UID, agentConnected corev1.ConditionStatus, guestOSName string) {
+			_, err := vmClient.KubevirtV1().VirtualMachineInstances(namespace).Create(context.Background(),
+				newVMI(uid, agentConnected, guestOSName), metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		updateVMI := func(agentConnected corev1.ConditionStatus, guestOSName string) {
+			vmi, err := vmClient.KubevirtV1().VirtualMachineInstances(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = vmClient.KubevirtV1().VirtualMachineInstances(namespace).Update(context.Background(),
+				newVMI(vmi.UID, agentConnected, guestOSName), metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		// recreateVMI replaces the VMI, as KubeVirt does when the VM restarts.
+		recreateVMI := func(uid types.UID, agentConnected corev1.ConditionStatus, guestOSName string) {
+			err := vmClient.KubevirtV1().VirtualMachineInstances(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			createVMI(uid, agentConnected, guestOSName)
+		}
+
+		BeforeEach(func() {
+			mockCtrl = gomock.NewController(GinkgoT())
+			vmClient = kubevirtfake.NewSimpleClientset()
+
+			kubecli.GetKubevirtClientFromClientConfig = kubecli.GetMockKubevirtClientFromClientConfig
+			kubecli.MockKubevirtClientInstance = kubecli.NewMockKubevirtClient(mockCtrl)
+			kubecli.MockKubevirtClientInstance.EXPECT().
+				VirtualMachineInstance(namespace).
+				Return(vmClient.KubevirtV1().VirtualMachineInstances(namespace)).
+				AnyTimes()
+
+			virtClient, _ = kubecli.GetKubevirtClientFromClientConfig(nil)
+
+			step = &iso.StepWaitForInstallation{
+				Config: iso.Config{
+					Name:                    name,
+					Namespace:               namespace,
+					InstallationCompleteOn:  "guest_agent",
+					InstallationWaitTimeout: 2 * time.Second,
+				},
+				Client: virtClient,
+			}
+		})
+
+		AfterEach(func() {
+			mockCtrl.Finish()
+		})
+
+		It("continues when the guest agent of the restarted VMI reports the installed OS", func() {
+			createVMI("installer", corev1.ConditionFalse, "")
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(500 * time.Millisecond)
+				recreateVMI("installed", corev1.ConditionTrue, "fedora")
+			}()
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+		})
+
+		It("continues when the guest agent connects again after a reboot", func() {
+			createVMI("vmi", corev1.ConditionTrue, "fedora")
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(300 * time.Millisecond)
+				updateVMI(corev1.ConditionFalse, "")
+				time.Sleep(300 * time.Millisecond)
+				updateVMI(corev1.ConditionTrue, "fedora")
+			}()
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+		})
+
+		It("continues when the first guest agent connects after the installation", func() {
+			createVMI("vmi", corev1.ConditionFalse, "")
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(300 * time.Millisecond)
+				updateVMI(corev1.ConditionTrue, "windows")
+			}()
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+		})
+
+		It("ignores the guest agent of the installer until the guest reboots", func() {
+			createVMI("installer", corev1.ConditionTrue, "fedora")
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(300 * time.Millisecond)
+				updateVMI(corev1.ConditionTrue, "fedora")
+			}()
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(MatchError(ContainSubstring("timed out")))
+		})
+
+		It("halts when the guest agent does not connect before the timeout", func() {
+			createVMI("installer", corev1.ConditionFalse, "")
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(300 * time.Millisecond)
+				recreateVMI("installed", corev1.ConditionFalse, "")
+			}()
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(HaveOccurred())
+		})
+
+		It("halts when the guest OS information is not reported before the timeout", func() {
+			createVMI("installer", corev1.ConditionFalse, "")
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(300 * time.Millisecond)
+				recreateVMI("installed", corev1.ConditionTrue, "")
+			}()
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+		})
+
+		It("keeps waiting while the VMI does not exist", func() {
+			step.Config.InstallationWaitTimeout = 0
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
 			action := step.Run(ctx, state)
 			Expect(action).To(Equal(multistep.ActionHalt))
 		})
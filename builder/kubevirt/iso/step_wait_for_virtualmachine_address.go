@@ -0,0 +1,68 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	"kubevirt.io/client-go/kubecli"
+)
+
+// StepWaitForVirtualMachineAddress waits for the IP address of a network interface of the VM,
+// so that the communicator connects to it directly instead of through a port forward.
+type StepWaitForVirtualMachineAddress struct {
+	Config Config
+	Client kubecli.KubevirtClient
+}
+
+func (s *StepWaitForVirtualMachineAddress) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+	name := s.Config.Name
+	namespace := s.Config.Namespace
+	interfaceName := s.Config.connectionInterface()
+
+	port := s.Config.SSHRemotePort
+	timeout := s.Config.Comm.SSHTimeout
+	if s.Config.Comm.Type == "winrm" {
+		port = s.Config.WinRMRemotePort
+		timeout = s.Config.Comm.WinRMTimeout
+	}
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+
+	ui.Sayf("Waiting for the interface %s of the VirtualMachineInstance (%s/%s) to report an IP address...", interfaceName, namespace, name)
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	address, err := WaitUntilInterfaceAddress(waitCtx, s.Client, namespace, name, interfaceName)
+	if err != nil {
+		if ctx.Err() != nil {
+			ui.Say("Context cancelled, stopping waiting for the IP address...")
+			return multistep.ActionHalt
+		}
+		if errors.Is(waitCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s waiting for an IP address on interface %s", timeout, interfaceName)
+		}
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Sayf("Connecting to %s:%d...", address, port)
+	state.Put("communicator_host", address)
+	state.Put("communicator_port", port)
+	return multistep.ActionContinue
+}
+
+func (s *StepWaitForVirtualMachineAddress) Cleanup(state multistep.StateBag) {
+	// Left blank intentionally
+}
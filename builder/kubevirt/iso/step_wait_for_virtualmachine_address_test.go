@@ -0,0 +1,166 @@
+// Copyright (c) Red Hat, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package iso_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hashicorp/packer-plugin-kubevirt/builder/kubevirt/iso"
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "kubevirt.io/api/core/v1"
+	kubecli "kubevirt.io/client-go/kubecli"
+	kubevirtfake "kubevirt.io/client-go/kubevirt/fake"
+)
+
+var _ = Describe("StepWaitForVirtualMachineAddress", func() {
+	const (
+		namespace = "test-ns"
+		name      = "test-vm"
+	)
+
+	var (
+		mockCtrl *gomock.Controller
+		vmClient *kubevirtfake.Clientset
+		state    *multistep.BasicStateBag
+		uiErr    *strings.Builder
+		step     *iso.StepWaitForVirtualMachineAddress
+	)
+
+	createVMI := func(interfaces ...v1.VirtualMachineInstanceNetworkInterface) {
+		_, err := vmClient.KubevirtV1().VirtualMachineInstances(namespace).Create(context.Background(),
+			&v1.VirtualMachineInstance{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+				Status: v1.VirtualMachineInstanceStatus{Interfaces: interfaces},
+			},
+			metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		uiErr = &strings.Builder{}
+		ui := &packer.BasicUi{
+			Reader:      strings.NewReader(""),
+			Writer:      io.Discard,
+			ErrorWriter: uiErr,
+		}
+		state = new(multistep.BasicStateBag)
+		state.Put("ui", ui)
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		vmClient = kubevirtfake.NewSimpleClientset()
+
+		kubecli.GetKubevirtClientFromClientConfig = kubecli.GetMockKubevirtClientFromClientConfig
+		mockVirt := kubecli.NewMockKubevirtClient(mockCtrl)
+		kubecli.MockKubevirtClientInstance = mockVirt
+		mockVirt.EXPECT().
+			VirtualMachineInstance(namespace).
+			Return(vmClient.KubevirtV1().VirtualMachineInstances(namespace)).
+			AnyTimes()
+		virtClient, _ := kubecli.GetKubevirtClientFromClientConfig(nil)
+
+		step = &iso.StepWaitForVirtualMachineAddress{
+			Config: iso.Config{
+				Name:      name,
+				Namespace: namespace,
+				Comm: communicator.Config{
+					Type: "ssh",
+					SSH:  communicator.SSH{SSHTimeout: 2 * time.Second},
+				},
+				ConnectionMode: "pod_ip",
+				SSHRemotePort:  22,
+			},
+			Client: virtClient,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	Context("Run", func() {
+		It("puts the pod IP address in the state", func() {
+			createVMI(v1.VirtualMachineInstanceNetworkInterface{Name: "default", IP: "10.244.0.15"})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(state.Get("communicator_host")).To(Equal("10.244.0.15"))
+			Expect(state.Get("communicator_port")).To(Equal(22))
+		})
+
+		It("uses the pod network of the configured networks", func() {
+			step.Config.Networks = []iso.Network{
+				{Name: "bridge", NetworkSource: iso.NetworkSource{Multus: &iso.MultusNetwork{NetworkName: "multus-01"}}},
+				{Name: "cluster", NetworkSource: iso.NetworkSource{Pod: &iso.PodNetwork{}}},
+			}
+			createVMI(
+				v1.VirtualMachineInstanceNetworkInterface{Name: "bridge", IP: "192.168.10.20"},
+				v1.VirtualMachineInstanceNetworkInterface{Name: "cluster", IP: "10.244.0.15"},
+			)
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(state.Get("communicator_host")).To(Equal("10.244.0.15"))
+		})
+
+		It("uses the named interface and the WinRM port", func() {
+			step.Config.Comm.Type = "winrm"
+			step.Config.ConnectionMode = "interface:bridge"
+			step.Config.WinRMRemotePort = 5985
+			step.Config.Comm.WinRMTimeout = 2 * time.Second
+			step.Config.Networks = []iso.Network{
+				{Name: "bridge", NetworkSource: iso.NetworkSource{Multus: &iso.MultusNetwork{NetworkName: "multus-01"}}},
+			}
+			createVMI(v1.VirtualMachineInstanceNetworkInterface{Name: "bridge", IPs: []string{"192.168.10.20"}})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionContinue))
+			Expect(state.Get("communicator_host")).To(Equal("192.168.10.20"))
+			Expect(state.Get("communicator_port")).To(Equal(5985))
+		})
+
+		It("halts when no IP address is reported before the timeout", func() {
+			createVMI(v1.VirtualMachineInstanceNetworkInterface{Name: "default"})
+
+			action := step.Run(context.Background(), state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			Expect(state.Get("error")).To(HaveOccurred())
+			Expect(uiErr.String()).To(ContainSubstring("timed out after 2s waiting for an IP address on interface default"))
+		})
+
+		It("halts without error when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			action := step.Run(ctx, state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			_, hasError := state.GetOk("error")
+			Expect(hasError).To(BeFalse())
+		})
+
+		It("halts without error when the deadline of the context is exceeded", func() {
+			ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+			defer cancel()
+
+			action := step.Run(ctx, state)
+			Expect(action).To(Equal(multistep.ActionHalt))
+			_, hasError := state.GetOk("error")
+			Expect(hasError).To(BeFalse())
+		})
+	})
+})
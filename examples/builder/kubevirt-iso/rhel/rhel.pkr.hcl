@@ -55,7 +55,7 @@ source "kubevirt-iso" "rhel" {
   ssh_remote_port   = 22
   ssh_username      = "user"
   ssh_password      = "root"
-  ssh_wait_timeout  = "20m"
+  ssh_timeout       = "20m"
 }
 
 build {
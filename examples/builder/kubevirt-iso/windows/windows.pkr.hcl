@@ -60,7 +60,7 @@ source "kubevirt-iso" "windows" {
   winrm_remote_port  = 5985
   winrm_username     = "Administrator"
   winrm_password     = "shadowman"
-  winrm_wait_timeout = "25m"
+  winrm_timeout      = "25m"
 }
 
 build {